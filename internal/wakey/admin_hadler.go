@@ -1,6 +1,7 @@
 package wakey
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -9,23 +10,33 @@ import (
 	tele "gopkg.in/telebot.v3"
 )
 
+const flowNotifyAll FlowID = "notify_all"
+
 type AdminHandler struct {
-	db       *DB
-	stateMan *StateManager
-	api      BotAPI
-	adm      int64
-	log      *zap.SugaredLogger
+	db        *DB
+	flows     *FlowRunner
+	api       BotAPI
+	adm       int64
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
 }
 
-func NewAdminHandler(db *DB, api BotAPI, stateMan *StateManager, log *zap.SugaredLogger, adminID int64, maxToxic int16) *AdminHandler {
+func NewAdminHandler(db *DB, api BotAPI, flows *FlowRunner, limiter *RateLimiter, callbacks *CallbackCodec, log *zap.SugaredLogger, adminID int64, maxToxic int16) *AdminHandler {
 	ah := &AdminHandler{
-		db:       db,
-		stateMan: stateMan,
-		api:      api,
-		adm:      adminID,
-		log:      log,
+		db:        db,
+		flows:     flows,
+		api:       api,
+		adm:       adminID,
+		callbacks: callbacks,
+		log:       log,
 	}
 
+	flows.Register(&Flow{
+		ID:         flowNotifyAll,
+		Steps:      []FlowStep{{State: StateWaitingForNotification, Prompt: "admin.notify_prompt", Validate: validateNotification}},
+		OnComplete: ah.completeNotification,
+	})
+
 	// Subscribe to toxicity updates
 	toxicCh, _ := db.SubscribeToToxicity(100)
 	go ah.monitorToxicity(toxicCh, maxToxic)
@@ -34,6 +45,14 @@ func NewAdminHandler(db *DB, api BotAPI, stateMan *StateManager, log *zap.Sugare
 	stateCh, _ := db.SubscribeToStateUpdates(100)
 	go ah.monitorWishStates(stateCh)
 
+	// Subscribe to wishes that need human review
+	reviewCh, _ := db.SubscribeToReviews(100)
+	go ah.monitorReviews(reviewCh)
+
+	// Subscribe to users who keep hitting the rate limits
+	offenseCh, _ := limiter.SubscribeToOffenses(100)
+	go ah.monitorRateLimits(offenseCh)
+
 	return ah
 }
 
@@ -64,15 +83,12 @@ func (ah *AdminHandler) HandleAction(c tele.Context, action string) error {
 		return ah.handleSkip(c, userID)
 	default:
 		ah.log.Errorw("unexpected action for AdminHandler", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
 func (ah *AdminHandler) States() []UserState {
-	return []UserState{
-		StateNotifyAll,
-		StateWaitingForNotification,
-	}
+	return []UserState{StateNotifyAll}
 }
 
 func (ah *AdminHandler) HandleState(c tele.Context, state UserState) error {
@@ -83,11 +99,9 @@ func (ah *AdminHandler) HandleState(c tele.Context, state UserState) error {
 	switch state {
 	case StateNotifyAll:
 		return ah.HandleNotifyAll(c)
-	case StateWaitingForNotification:
-		return ah.handleNotification(c)
 	default:
 		ah.log.Errorw("unexpected state for AdminHandler", "state", state)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
@@ -102,73 +116,97 @@ func (h *AdminHandler) handleAdminAction(c tele.Context) (int64, error) {
 	userID, err := strconv.ParseInt(userIDStr, 10, 64)
 	if err != nil {
 		h.log.Errorw("failed to parse user id", "error", err, "userID", userIDStr)
-		return 0, c.Send("Ошибка при обработке ID пользователя.")
+		return 0, c.Send(tr(c, "admin.invalid_user_id"))
 	}
 
 	return userID, nil
 }
 
 func (h *AdminHandler) HandleWarn(c tele.Context, userID int64) error {
-	warningMessage := "⚠️ Предупреждение. Ваше сообщение было помечено как неуместное. " +
-		"Пожалуйста, будьте вежливы и уважительны к другим пользователям. " +
-		"Повторные нарушения могут привести к бану."
+	warningMessage := T(h.db.GetUserLang(userID), "admin.warning")
 
 	_, err := h.api.Send(tele.ChatID(userID), warningMessage)
 	if err != nil {
+		if markIfUnreachable(h.db, h.log, userID, err) {
+			return c.Send(tr(c, "admin.user_unreachable", userID))
+		}
 		h.log.Errorw("failed to send warning to user", "error", err, "userID", userID)
-		return c.Send("Ошибка при отправке предупреждения пользователю.")
+		return c.Send(tr(c, "admin.warn_error"))
 	}
 
-	return c.Send(fmt.Sprintf("Предупреждение отправлено пользователю %d.", userID))
+	return c.Send(tr(c, "admin.warned", userID))
 }
 
 func (h *AdminHandler) handleBan(c tele.Context, userID int64) error {
-	if err := h.db.BanUser(userID); err != nil {
+	if err := h.db.BanUser(userID, h.adm); err != nil {
 		h.log.Errorw("failed to ban user", "error", err, "userID", userID)
-		return c.Send("Ошибка при бане пользователя.")
+		return c.Send(tr(c, "admin.ban_error"))
 	}
 
 	// Notify the banned user
-	banMessage := "Вы были забанены за нарушение правил использования бота."
+	banMessage := T(h.db.GetUserLang(userID), "admin.ban_notice")
 	_, err := h.api.Send(tele.ChatID(userID), banMessage)
-	if err != nil {
+	if err != nil && !markIfUnreachable(h.db, h.log, userID, err) {
 		h.log.Errorw("failed to send ban notification to user", "error", err, "userID", userID)
 	}
 
-	return c.Send(fmt.Sprintf("Пользователь %d забанен и уведомлен.", userID))
+	return c.Send(tr(c, "admin.banned", userID))
 }
 
 func (h *AdminHandler) handleSkip(c tele.Context, userID int64) error {
-	return c.Send(fmt.Sprintf("Бан пользователя %d пропущен.", userID))
+	// The user's wishes held for the review are fine to deliver
+	released, err := h.db.ReleaseHeldWishes(userID, h.adm)
+	if err != nil {
+		h.log.Errorw("failed to release held wishes", "error", err, "userID", userID)
+	} else if released > 0 {
+		h.log.Infow("released held wishes", "userID", userID, "count", released)
+	}
+
+	return c.Send(tr(c, "admin.ban_skipped", userID))
 }
 
 func (ah *AdminHandler) HandleNotifyAll(c tele.Context) error {
-	ah.stateMan.SetState(ah.adm, StateWaitingForNotification)
-	return c.Send("Пожалуйста, отправьте текст уведомления, которое нужно разослать всем пользователям. Используйте /cancel для отмены.")
+	return ah.flows.Start(c, flowNotifyAll, nil)
 }
 
-func (ah *AdminHandler) handleNotification(c tele.Context) error {
-	message := c.Text()
-	if message == "" {
-		return c.Send("Текст уведомления не может быть пустым. Попробуйте еще раз или используйте /cancel для отмены.")
+func validateNotification(c tele.Context) error {
+	if c.Text() == "" {
+		return errors.New(tr(c, "admin.notify_empty"))
 	}
+	return nil
+}
+
+func (ah *AdminHandler) completeNotification(c tele.Context, data *UserData) error {
+	if c.Sender().ID != ah.adm {
+		return nil
+	}
+	message := c.Text()
 
 	users, err := ah.db.GetAllUsers()
 	if err != nil {
 		ah.log.Errorw("failed to get users for notification", "error", err)
-		return c.Send("Ошибка при получении списка пользователей.")
+		return c.Send(tr(c, "admin.users_error"))
 	}
 
 	successCount := 0
 	failCount := 0
+	unreachableCount := 0
 
 	for _, user := range users {
 		if user.IsBanned {
 			continue
 		}
+		if user.IsUnreachable {
+			unreachableCount++
+			continue
+		}
 
 		_, err := ah.api.Send(tele.ChatID(user.ID), message)
 		if err != nil {
+			if markIfUnreachable(ah.db, ah.log, user.ID, err) {
+				unreachableCount++
+				continue
+			}
 			ah.log.Warnw("failed to send notification to user",
 				"error", err,
 				"userID", user.ID)
@@ -178,15 +216,27 @@ func (ah *AdminHandler) handleNotification(c tele.Context) error {
 		}
 	}
 
-	return c.Send(fmt.Sprintf(
-		"Уведомление отправлено:\n"+
-			"✅ Успешно: %d\n"+
-			"❌ С ошибкой: %d",
+	return c.Send(tr(c, "admin.notify_result",
 		successCount,
 		failCount,
+		unreachableCount,
 	))
 }
 
+// moderationKeyboard has the buttons for the admin to warn, ban or let the user be
+func (ah *AdminHandler) moderationKeyboard(lang string, userID int64) *tele.ReplyMarkup {
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnWarn := ah.callbacks.btn(ah.adm, T(lang, btnWarnUserText), btnWarnUserID, fmt.Sprintf("%d", userID))
+	btnBan := ah.callbacks.btn(ah.adm, T(lang, btnBanUserText), btnBanUserID, fmt.Sprintf("%d", userID))
+	btnSkip := ah.callbacks.btn(ah.adm, T(lang, btnSkipBanText), btnSkipBanID, fmt.Sprintf("%d", userID))
+	inlineKeyboard.Inline(
+		inlineKeyboard.Row(btnWarn),
+		inlineKeyboard.Row(btnBan),
+		inlineKeyboard.Row(btnSkip),
+	)
+	return inlineKeyboard
+}
+
 func (ah *AdminHandler) monitorToxicity(ch <-chan *Wish, threshold int16) {
 	for wish := range ch {
 		if !wish.Toxicity.Valid {
@@ -200,22 +250,10 @@ func (ah *AdminHandler) monitorToxicity(ch <-chan *Wish, threshold int16) {
 }
 
 func (ah *AdminHandler) notifyAdminAboutToxicWish(wish *Wish) {
-	// Create inline keyboard
-	inlineKeyboard := &tele.ReplyMarkup{}
-	btnWarn := inlineKeyboard.Data(btnWarnUserText, btnWarnUserID, fmt.Sprintf("%d", wish.FromID))
-	btnBan := inlineKeyboard.Data(btnBanUserText, btnBanUserID, fmt.Sprintf("%d", wish.FromID))
-	btnSkip := inlineKeyboard.Data(btnSkipBanText, btnSkipBanID, fmt.Sprintf("%d", wish.FromID))
-	inlineKeyboard.Inline(
-		inlineKeyboard.Row(btnWarn),
-		inlineKeyboard.Row(btnBan),
-		inlineKeyboard.Row(btnSkip),
-	)
+	lang := ah.db.GetUserLang(ah.adm)
+	inlineKeyboard := ah.moderationKeyboard(lang, wish.FromID)
 
-	message := fmt.Sprintf(
-		"⚠️ Обнаружено токсичное сообщение\n\n"+
-			"От пользователя: %d\n"+
-			"Уровень токсичности: %d%%\n"+
-			"Текст: %s",
+	message := T(lang, "admin.toxic_wish",
 		wish.FromID,
 		wish.Toxicity.Int16,
 		wish.Content,
@@ -231,6 +269,40 @@ func (ah *AdminHandler) notifyAdminAboutToxicWish(wish *Wish) {
 	}
 }
 
+func (ah *AdminHandler) monitorReviews(ch <-chan *Wish) {
+	for wish := range ch {
+		ah.notifyAdminAboutMediaWish(wish)
+	}
+}
+
+func (ah *AdminHandler) notifyAdminAboutMediaWish(wish *Wish) {
+	_, err := ah.api.Send(tele.ChatID(ah.adm), wishSendable(wish))
+	if err != nil {
+		ah.log.Errorw("failed to send media wish to admin",
+			"error", err,
+			"wishID", wish.ID,
+			"fromID", wish.FromID)
+		return
+	}
+
+	lang := ah.db.GetUserLang(ah.adm)
+	inlineKeyboard := ah.moderationKeyboard(lang, wish.FromID)
+
+	message := T(lang, "admin.media_wish",
+		wish.FromID,
+		wish.MediaType,
+		wish.Content,
+	)
+
+	_, err = ah.api.Send(tele.ChatID(ah.adm), message, inlineKeyboard)
+	if err != nil {
+		ah.log.Errorw("failed to notify admin about media wish",
+			"error", err,
+			"wishID", wish.ID,
+			"fromID", wish.FromID)
+	}
+}
+
 func (ah *AdminHandler) monitorWishStates(ch <-chan *Wish) {
 	for wish := range ch {
 		if wish.State == WishStateReported {
@@ -240,22 +312,10 @@ func (ah *AdminHandler) monitorWishStates(ch <-chan *Wish) {
 }
 
 func (ah *AdminHandler) notifyAdminAboutReportedWish(wish *Wish) {
-	// Create inline keyboard
-	inlineKeyboard := &tele.ReplyMarkup{}
-	btnWarn := inlineKeyboard.Data(btnWarnUserText, btnWarnUserID, fmt.Sprintf("%d", wish.FromID))
-	btnBan := inlineKeyboard.Data(btnBanUserID, btnBanUserID, fmt.Sprintf("%d", wish.FromID))
-	btnSkip := inlineKeyboard.Data(btnSkipBanText, btnSkipBanID, fmt.Sprintf("%d", wish.FromID))
-	inlineKeyboard.Inline(
-		inlineKeyboard.Row(btnWarn),
-		inlineKeyboard.Row(btnBan),
-		inlineKeyboard.Row(btnSkip),
-	)
+	lang := ah.db.GetUserLang(ah.adm)
+	inlineKeyboard := ah.moderationKeyboard(lang, wish.FromID)
 
-	message := fmt.Sprintf(
-		"🚫 Жалоба на сообщение\n\n"+
-			"От пользователя: %d\n"+
-			"Уровень токсичности: %d%%\n"+
-			"Текст: %s",
+	message := T(lang, "admin.reported_wish",
 		wish.FromID,
 		wish.Toxicity.Int16,
 		wish.Content,
@@ -269,3 +329,29 @@ func (ah *AdminHandler) notifyAdminAboutReportedWish(wish *Wish) {
 			"fromID", wish.FromID)
 	}
 }
+
+func (ah *AdminHandler) monitorRateLimits(ch <-chan RateOffense) {
+	for offense := range ch {
+		ah.notifyAdminAboutRateOffense(offense)
+	}
+}
+
+func (ah *AdminHandler) notifyAdminAboutRateOffense(offense RateOffense) {
+	lang := ah.db.GetUserLang(ah.adm)
+	inlineKeyboard := ah.moderationKeyboard(lang, offense.UserID)
+
+	message := T(lang, "admin.rate_limited",
+		offense.UserID,
+		offense.Throttled,
+		int(offense.Window.Minutes()),
+		offense.Class,
+	)
+
+	_, err := ah.api.Send(tele.ChatID(ah.adm), message, inlineKeyboard)
+	if err != nil {
+		ah.log.Errorw("failed to notify admin about rate limit offender",
+			"error", err,
+			"userID", offense.UserID,
+			"throttled", offense.Throttled)
+	}
+}
@@ -1,8 +1,11 @@
 package wakey
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,15 +17,21 @@ type Bot struct {
 	api            BotAPI
 	db             *DB
 	stateManager   *StateManager
+	limiter        *RateLimiter
+	callbacks      *CallbackCodec
 	actionHandlers map[string]BotHandler
 	stateHandlers  map[UserState]BotHandler
 	log            *zap.SugaredLogger
+	// inFlight counts the updates being handled, so that Stop can wait for them
+	inFlight        sync.WaitGroup
+	shutdownTimeout time.Duration
 }
 
 type BotAPI interface {
 	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
 	Handle(endpoint interface{}, h tele.HandlerFunc, m ...tele.MiddlewareFunc)
 	Use(middlewares ...tele.MiddlewareFunc)
+	SetCommands(opts ...interface{}) error
 	Start()
 	Stop()
 }
@@ -34,6 +43,12 @@ type BotHandler interface {
 	HandleState(c tele.Context, state UserState) error
 }
 
+// menuContextKey is the telebot context key set by showMenu
+const menuContextKey = "menu"
+
+// userContextKey is the telebot context key of the registered user who sent the update
+const userContextKey = "user"
+
 type JobID int64
 type JobFunc func(JobID)
 
@@ -59,37 +74,86 @@ const (
 	btnChangePlansID      = "change_plans"
 	btnChangeWakeTimeID   = "change_wake_time"
 	btnChangeNotifyTimeID = "change_notify_time"
+	btnToggleRedirectID   = "toggle_redirect"
 	btnInviteFriendsID    = "invite_friends"
 	btnDoNothingID        = "do_nothing"
 	btnShowLinkID         = "show_link"
 	btnWarnUserID         = "warn_user"
 	btnBanUserID          = "ban_user"
 	btnSkipBanID          = "skip_ban"
+	btnBlockSenderID      = "block_sender"
+	btnBlockRecipientID   = "block_recipient"
+	btnShowBlocksID       = "show_blocks"
+	btnUnblockID          = "unblock"
+	btnEditWishID         = "edit_wish"
+	btnRetractWishID      = "retract_wish"
+	btnSendDraftID        = "send_draft"
+	btnDigestSettingsID   = "digest_settings"
+	btnDigestDayID        = "digest_day"
+	btnDigestOffID        = "digest_off"
+	btnShowJournalID      = "show_journal"
+	btnJournalPageID      = "journal_page"
+	btnJournalSearchID    = "journal_search"
+	btnMoodRateID         = "mood_rate"
+	btnMoodSkipID         = "mood_skip"
+	btnMoodTagID          = "mood_tag"
+	btnMoodNeedsID        = "mood_needs"
+	btnMoodDoneID         = "mood_done"
+	btnShowMoodTrendID    = "show_mood_trend"
+	btnMoodChartID        = "mood_chart"
+	btnChangeLanguageID   = "change_language"
+	btnSetLanguageID      = "set_language"
+	btnShowOriginalID     = "show_original"
+	btnFlowBackID         = "flow_back"
 )
 
+// Button texts are the message catalog keys
 const (
-	btnWishLikeText         = "♥ Спасибо, приятно!"
-	btnWishDislikeText      = "😐 Ну такое…"
-	btnWishReportText       = "🙎 Это даже обидно"
-	btnSendWishYesText      = "💌 Отправить сообщение"
-	btnSendWishNoText       = "❌ Не сейчас"
-	btnKeepPlansText        = "👌 Оставить как есть"
-	btnUpdatePlansText      = "✍ Изменить статус и время"
-	btnNoWishText           = "🚫 Не получать сообщение"
-	btnShowProfileText      = "👤 Показать мой профиль"
-	btnChangeNameText       = "📝 Изменить имя"
-	btnChangeBioText        = "📋 Изменить био"
-	btnChangeTimezoneText   = "🌍 Изменить часовой пояс"
-	btnChangePlansText      = "✍ Изменить статус"
-	btnChangeWakeTimeText   = "⏰ Изменить время пробуждения"
-	btnChangeNotifyTimeText = "🔔 Изменить время уведомления"
-	btnInviteFriendsText    = "👥 Пригласить друзей"
-	btnDoNothingText        = "🤷‍♂️ Ничего, до свидания"
-	btnShowLinkText         = "🔗 Показать ссылку"
-	btnShareLinkText        = "📤 Поделиться ссылкой"
-	btnWarnUserText         = "⚠️ Отправить предупреждение"
-	btnBanUserText          = "🚫 Забанить пользователя"
-	btnSkipBanText          = "⏭️ Пропустить"
+	btnWishLikeText         = "btn.wish_like"
+	btnWishDislikeText      = "btn.wish_dislike"
+	btnWishReportText       = "btn.wish_report"
+	btnSendWishYesText      = "btn.send_wish_yes"
+	btnSendWishNoText       = "btn.send_wish_no"
+	btnKeepPlansText        = "btn.keep_plans"
+	btnUpdatePlansText      = "btn.update_plans"
+	btnNoWishText           = "btn.no_wish"
+	btnShowProfileText      = "btn.show_profile"
+	btnChangeNameText       = "btn.change_name"
+	btnChangeBioText        = "btn.change_bio"
+	btnChangeTimezoneText   = "btn.change_timezone"
+	btnChangePlansText      = "btn.change_plans"
+	btnChangeWakeTimeText   = "btn.change_wake_time"
+	btnChangeNotifyTimeText = "btn.change_notify_time"
+	btnToggleRedirectText   = "btn.toggle_redirect"
+	btnInviteFriendsText    = "btn.invite_friends"
+	btnDoNothingText        = "btn.do_nothing"
+	btnShowLinkText         = "btn.show_link"
+	btnShareLinkText        = "btn.share_link"
+	btnWarnUserText         = "btn.warn_user"
+	btnBanUserText          = "btn.ban_user"
+	btnSkipBanText          = "btn.skip_ban"
+	btnBlockSenderText      = "btn.block_sender"
+	btnBlockRecipientText   = "btn.block_recipient"
+	btnShowBlocksText       = "btn.show_blocks"
+	btnUnblockText          = "btn.unblock"
+	btnEditWishText         = "btn.edit_wish"
+	btnRetractWishText      = "btn.retract_wish"
+	btnSendDraftText        = "btn.send_draft"
+	btnDigestSettingsText   = "btn.digest_settings"
+	btnDigestOffText        = "btn.digest_off"
+	btnShowJournalText      = "btn.show_journal"
+	btnJournalNewerText     = "btn.journal_newer"
+	btnJournalOlderText     = "btn.journal_older"
+	btnJournalSearchText    = "btn.journal_search"
+	btnMoodSkipText         = "btn.mood_skip"
+	btnMoodTagSelectedText  = "btn.mood_tag_selected"
+	btnMoodNeedsText        = "btn.mood_needs"
+	btnMoodDoneText         = "btn.mood_done"
+	btnShowMoodTrendText    = "btn.show_mood_trend"
+	btnMoodChartText        = "btn.mood_chart"
+	btnChangeLanguageText   = "btn.change_language"
+	btnShowOriginalText     = "btn.show_original"
+	btnFlowBackText         = "btn.flow_back"
 )
 
 var btnTextMap = map[string]string{
@@ -108,18 +172,33 @@ var btnTextMap = map[string]string{
 	btnChangePlansID:      btnChangePlansText,
 	btnChangeWakeTimeID:   btnChangeWakeTimeText,
 	btnChangeNotifyTimeID: btnChangeNotifyTimeText,
+	btnToggleRedirectID:   btnToggleRedirectText,
 	btnInviteFriendsID:    btnInviteFriendsText,
 	btnDoNothingID:        btnDoNothingText,
 	btnShowLinkID:         btnShowLinkText,
 	btnWarnUserID:         btnWarnUserText,
 	btnBanUserID:          btnBanUserText,
 	btnSkipBanID:          btnSkipBanText,
+	btnBlockSenderID:      btnBlockSenderText,
+	btnBlockRecipientID:   btnBlockRecipientText,
+	btnShowBlocksID:       btnShowBlocksText,
+	btnEditWishID:         btnEditWishText,
+	btnRetractWishID:      btnRetractWishText,
+	btnSendDraftID:        btnSendDraftText,
+	btnDigestSettingsID:   btnDigestSettingsText,
+	btnShowJournalID:      btnShowJournalText,
+	btnJournalSearchID:    btnJournalSearchText,
+	btnShowMoodTrendID:    btnShowMoodTrendText,
+	btnChangeLanguageID:   btnChangeLanguageText,
+	btnFlowBackID:         btnFlowBackText,
 }
 
-func NewBot(db *DB, stateMan *StateManager) *Bot {
+func NewBot(db *DB, stateMan *StateManager, limiter *RateLimiter, callbacks *CallbackCodec) *Bot {
 	bot := &Bot{
 		db:             db,
 		stateManager:   stateMan,
+		limiter:        limiter,
+		callbacks:      callbacks,
 		log:            zap.L().Named("bot").Sugar(),
 		actionHandlers: make(map[string]BotHandler),
 		stateHandlers:  make(map[UserState]BotHandler),
@@ -134,6 +213,7 @@ func (bot *Bot) Logger() *zap.SugaredLogger {
 
 func (bot *Bot) Start(cfg Config, api BotAPI, handlers []BotHandler) {
 	bot.api = api
+	bot.shutdownTimeout = shutdownTimeout(cfg.Transport)
 
 	for _, handler := range handlers {
 		for _, action := range handler.Actions() {
@@ -146,14 +226,27 @@ func (bot *Bot) Start(cfg Config, api BotAPI, handlers []BotHandler) {
 
 	bot.api.Use(middleware.Recover())
 	bot.api.Use(bot.logMessage)
+	bot.api.Use(bot.setLanguage)
 	bot.api.Use(bot.checkBan)
+	bot.api.Use(bot.rateLimit)
+	bot.api.Use(bot.markWishesRead)
+	bot.api.Use(bot.releaseAbandonedOffer)
 
 	bot.api.Handle(tele.OnCallback, bot.handleCallback)
 	bot.api.Handle(tele.OnText, bot.handleText)
+	bot.api.Handle(tele.OnVoice, bot.handleMedia)
+	bot.api.Handle(tele.OnPhoto, bot.handleMedia)
+	bot.api.Handle(tele.OnSticker, bot.handleMedia)
+	bot.api.Handle(tele.OnVideoNote, bot.handleMedia)
 	bot.api.Handle("/start", bot.handleStart)
 	bot.api.Handle("/cancel", bot.handleCancel)
 	bot.api.Handle("/stat", bot.handleStats)
 	bot.api.Handle("/notify", bot.handleNotify)
+	bot.api.Handle("/mood", bot.handleMood)
+
+	if err := bot.setCommands(cfg.AdminID); err != nil {
+		bot.log.Errorw("failed to publish the command menu", "error", err)
+	}
 
 	go func() {
 		bot.log.Info("starting bot")
@@ -162,8 +255,69 @@ func (bot *Bot) Start(cfg Config, api BotAPI, handlers []BotHandler) {
 	}()
 }
 
+// Stop stops receiving updates and waits for the ones being handled
 func (bot *Bot) Stop() {
 	bot.api.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		bot.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(bot.shutdownTimeout):
+		bot.log.Warnw("stopped before all updates were handled", "timeout", bot.shutdownTimeout)
+	}
+}
+
+// TrackUpdates wraps the poller to handle every update in its own goroutine,
+// counted before it starts, so that Stop waits for all the updates the poller
+// delivered. The bot API must be synchronous, otherwise telebot starts
+// the handlers itself and they can't be counted in time.
+func (bot *Bot) TrackUpdates(poller tele.Poller) tele.Poller {
+	return &trackingPoller{poller: poller, inFlight: &bot.inFlight}
+}
+
+type trackingPoller struct {
+	poller   tele.Poller
+	inFlight *sync.WaitGroup
+}
+
+// Poll returns once the wrapped poller stops and every update it delivered
+// has been counted, so telebot's Stop returning means Wait covers them all
+func (tp *trackingPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	updates := make(chan tele.Update, cap(dest))
+	stopped := make(chan struct{})
+	go func() {
+		tp.poller.Poll(b, updates, stop)
+		close(stopped)
+	}()
+
+	for {
+		select {
+		case update := <-updates:
+			tp.dispatch(b, update)
+		case <-stopped:
+			for {
+				select {
+				case update := <-updates:
+					tp.dispatch(b, update)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (tp *trackingPoller) dispatch(b *tele.Bot, update tele.Update) {
+	tp.inFlight.Add(1)
+	go func() {
+		defer tp.inFlight.Done()
+		b.ProcessUpdate(update)
+	}()
 }
 
 func (bot *Bot) logMessage(next tele.HandlerFunc) tele.HandlerFunc {
@@ -228,6 +382,24 @@ func (bot *Bot) LogError(err error, c tele.Context) {
 	}
 }
 
+// setLanguage stores the user's language and the registered user in the
+// context. Users who haven't registered yet get the language of their
+// Telegram client.
+func (bot *Bot) setLanguage(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		lang := SupportedLang(c.Sender().LanguageCode)
+		if user, err := bot.db.GetUserByID(c.Sender().ID); err == nil {
+			c.Set(userContextKey, user)
+			if user.Lang != "" {
+				lang = user.Lang
+			}
+		}
+		c.Set(langContextKey, lang)
+
+		return next(c)
+	}
+}
+
 func (bot *Bot) checkBan(next tele.HandlerFunc) tele.HandlerFunc {
 	return func(c tele.Context) error {
 		userID := c.Sender().ID
@@ -235,7 +407,7 @@ func (bot *Bot) checkBan(next tele.HandlerFunc) tele.HandlerFunc {
 		// Check if user exists and is banned
 		user, err := bot.db.GetUserByID(userID)
 		if err == nil && user.IsBanned {
-			const msg = "Извините, вы не можете использовать бота, так как были забанены."
+			msg := tr(c, "bot.banned")
 			// Check if it's a callback query
 			if c.Callback() != nil {
 				return c.Respond(&tele.CallbackResponse{
@@ -252,23 +424,124 @@ func (bot *Bot) checkBan(next tele.HandlerFunc) tele.HandlerFunc {
 	}
 }
 
+// rateLimit drops the updates of users who send them faster than the limits
+// allow. A throttled button press is always answered as Telegram shows a
+// spinner until then, a throttled message only the first time in a row.
+func (bot *Bot) rateLimit(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		userID := c.Sender().ID
+		now := time.Now()
+
+		for _, class := range rateClasses(c) {
+			decision := bot.limiter.Allow(userID, class, now)
+			if decision.Allowed {
+				continue
+			}
+
+			bot.log.Infow("update throttled", "user_id", userID, "class", class, "retry_after", decision.RetryAfter)
+			secs := max(1, int64(math.Ceil(decision.RetryAfter.Seconds())))
+			msg := trn(c, "bot.throttled", secs, secs)
+			if c.Callback() != nil {
+				return c.Respond(&tele.CallbackResponse{Text: msg})
+			}
+			if decision.Notify {
+				return c.Send(msg)
+			}
+			return nil
+		}
+
+		return next(c)
+	}
+}
+
+// rateClasses returns the limits the update counts against. Asking for a plan
+// to write a wish for, with the button or the deep link, also counts against
+// the wish lookups as it searches the plans of other users.
+func rateClasses(c tele.Context) []RateClass {
+	if c.Callback() == nil {
+		if c.Message() != nil && parseDeepLink(c.Message().Payload).action == deepLinkSendWish {
+			return []RateClass{RateMessages, RateWishLookups}
+		}
+		return []RateClass{RateMessages}
+	}
+	action := strings.TrimSpace(strings.Split(c.Callback().Data, "|")[0])
+	if action == btnSendWishYesID {
+		return []RateClass{RateCallbacks, RateWishLookups}
+	}
+	return []RateClass{RateCallbacks}
+}
+
+// markWishesRead marks the wishes delivered to the user as read when the user
+// comes back to the bot, as Telegram doesn't tell bots about read messages.
+// Only the messages of registered users count, a button press needs no read
+// mark since the recipient can rate a delivered wish right away.
+func (bot *Bot) markWishesRead(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		_, registered := c.Get(userContextKey).(*User)
+		if registered && c.Callback() == nil && c.Message() != nil {
+			if err := bot.db.MarkWishesRead(c.Sender().ID); err != nil {
+				bot.log.Errorw("failed to mark wishes read", "error", err, "user_id", c.Sender().ID)
+			}
+		}
+
+		return next(c)
+	}
+}
+
+// offeredPlan returns the plan the user is writing a wish for and when it was offered
+func (bot *Bot) offeredPlan(userID int64) (uint, time.Time) {
+	userData, exists := bot.stateManager.GetUserData(userID)
+	if !exists || userData.State != StateAwaitingWish {
+		return 0, time.Time{}
+	}
+	return userData.TargetPlanID, userData.OfferedAt
+}
+
+// releaseAbandonedOffer makes the offered plan available to other senders
+// as soon as the user leaves the wish composition
+func (bot *Bot) releaseAbandonedOffer(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		userID := c.Sender().ID
+		planID, offeredAt := bot.offeredPlan(userID)
+
+		err := next(c)
+
+		if nextPlanID, _ := bot.offeredPlan(userID); planID != 0 && nextPlanID != planID {
+			if err := bot.db.ReleasePlanOffer(planID, offeredAt); err != nil {
+				bot.log.Errorw("failed to release plan offer", "error", err, "planID", planID)
+			}
+		}
+
+		return err
+	}
+}
+
 func (bot *Bot) handleCallback(c tele.Context) error {
+	if err := bot.callbacks.decodeCallback(c); err != nil {
+		bot.log.Warnw("rejected callback", "error", err, "user_id", c.Sender().ID, "data", c.Callback().Data)
+		return c.Respond(&tele.CallbackResponse{
+			Text:      tr(c, "bot.stale_button"),
+			ShowAlert: true,
+		})
+	}
+
 	data := strings.Split(c.Data(), "|")
 	action := strings.TrimSpace(data[0])
 
+	// Add button text to the message if it exists in the map
+	if btnText, ok := btnTextMap[action]; ok {
+		bot.markButtonPressed(c, tr(c, btnText))
+	}
+
+	return bot.handleAction(c, action)
+}
+
+// handleAction runs the handler of a button action
+func (bot *Bot) handleAction(c tele.Context, action string) error {
 	handler, exists := bot.actionHandlers[action]
 	if !exists {
 		bot.log.Warnw("no handler for action", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
-	}
-
-	// Add button text to the message if it exists in the map
-	if btnText, ok := btnTextMap[action]; ok {
-		msg := fmt.Sprintf("%s\n\n%s", c.Message().Text, btnText)
-		err := c.Edit(msg)
-		if err != nil {
-			bot.log.Warnw("failed to edit message with button text", "err", err)
-		}
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 
 	err := handler.HandleAction(c, action)
@@ -276,30 +549,45 @@ func (bot *Bot) handleCallback(c tele.Context) error {
 		return err
 	}
 
-	userID := c.Sender().ID
-	state, exists := bot.stateManager.GetState(userID)
-	if exists && state == StateSuggestActions {
-		bot.stateManager.ClearState(userID)
-		return bot.handleState(c, state)
+	return bot.showRequestedMenu(c)
+}
+
+// markButtonPressed appends the button text to the message and removes its keyboard
+func (bot *Bot) markButtonPressed(c tele.Context, btnText string) {
+	msg := c.Message()
+
+	var err error
+	switch {
+	case msg.Text != "":
+		err = c.Edit(fmt.Sprintf("%s\n\n%s", msg.Text, btnText))
+	case msg.Photo != nil || msg.Voice != nil:
+		err = c.EditCaption(strings.TrimSpace(fmt.Sprintf("%s\n\n%s", msg.Caption, btnText)))
+	default:
+		err = c.Edit(&tele.ReplyMarkup{})
 	}
 
-	return nil
+	if err != nil {
+		bot.log.Warnw("failed to edit message with button text", "err", err)
+	}
 }
 
 func (bot *Bot) handleText(c tele.Context) error {
 	userID := c.Sender().ID
 
 	state, exists := bot.stateManager.GetState(userID)
-	if !exists {
+	if !exists || state == StateNone {
 		state = StateSuggestActions
 	}
 
 	return bot.handleState(c, state)
 }
 
-func (bot *Bot) handleState(c tele.Context, state UserState) error {
-	userID := c.Sender().ID
+// handleMedia passes media messages on like text, flow steps decide whether they accept them
+func (bot *Bot) handleMedia(c tele.Context) error {
+	return bot.handleText(c)
+}
 
+func (bot *Bot) handleState(c tele.Context, state UserState) error {
 	handler, exists := bot.stateHandlers[state]
 	if !exists {
 		bot.log.Warnw("no handler for state", "state", state)
@@ -311,16 +599,45 @@ func (bot *Bot) handleState(c tele.Context, state UserState) error {
 		return err
 	}
 
-	state, exists = bot.stateManager.GetState(userID)
-	if exists && state == StateSuggestActions {
-		bot.stateManager.ClearState(userID)
-		return bot.handleState(c, state)
+	return bot.showRequestedMenu(c)
+}
+
+// showMenu asks the bot to suggest the next actions once the update is handled
+func showMenu(c tele.Context) {
+	c.Set(menuContextKey, true)
+}
+
+// showRequestedMenu shows the main menu if a handler asked for it with showMenu
+func (bot *Bot) showRequestedMenu(c tele.Context) error {
+	if requested, _ := c.Get(menuContextKey).(bool); !requested {
+		return nil
 	}
+	c.Set(menuContextKey, false)
 
-	return nil
+	return bot.handleState(c, StateSuggestActions)
 }
 
+// handleStart starts the registration or greets the registered user. A deep
+// link of a registered user jumps straight into its flow. A registered user
+// who had been unreachable is reactivated whichever way they come back.
 func (bot *Bot) handleStart(c tele.Context) error {
+	link := parseDeepLink(c.Message().Payload)
+	if link.action != "" {
+		bot.log.Infow("deep link", "user_id", c.Sender().ID, "action", link.action, "referral_code", link.referralCode)
+	}
+
+	user, err := bot.db.GetUserByID(c.Sender().ID)
+	if err != nil && err != ErrNotFound {
+		bot.log.Errorw("failed to get user", "error", err, "user_id", c.Sender().ID)
+		return c.Send(tr(c, "common.error"))
+	}
+	if err == nil {
+		reactivateUser(bot.db, bot.log, user)
+		if link.action == deepLinkSendWish {
+			return bot.handleAction(c, btnSendWishYesID)
+		}
+	}
+
 	return bot.handleState(c, StateRegistrationStart)
 }
 
@@ -336,11 +653,32 @@ func (bot *Bot) handleNotify(c tele.Context) error {
 	return bot.handleState(c, StateNotifyAll)
 }
 
+func (bot *Bot) handleMood(c tele.Context) error {
+	return bot.handleState(c, StateShowMoodChart)
+}
+
+// truncateText shortens the text to the given number of runes
+func truncateText(text string, maxLen int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	return string(runes[:maxLen]) + "…"
+}
+
+// isDisableWord reports whether the user sent the word disabling notifications instead of a time
+func isDisableWord(c tele.Context, text string) bool {
+	return strings.EqualFold(strings.TrimSpace(text), tr(c, "time.disable_word"))
+}
+
+// errInvalidTime is returned by parseTime, the "time.invalid_format" message explains it to the user
+var errInvalidTime = errors.New("invalid time format")
+
 func parseTime(timeStr string, userTz int32) (time.Time, error) {
 	// Parse the time
 	t, err := time.Parse("15:04", timeStr)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("Неверный формат времени. Пожалуйста, используйте формат ЧЧ:ММ (например, 14:30)")
+		return time.Time{}, errInvalidTime
 	}
 
 	// Create a time.Location using the user's timezone offset
@@ -0,0 +1,139 @@
+package wakey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// callbackVersion starts the signature suffix, buttons of other versions are
+// rejected as stale
+const callbackVersion = '1'
+
+const (
+	defaultCallbackTTL   = 30 * 24 * time.Hour
+	minCallbackSecretLen = 16
+	callbackSigLen       = 8 // bytes of the HMAC kept in the button
+)
+
+var (
+	ErrCallbackInvalid = errors.New("invalid callback signature")
+	ErrCallbackExpired = errors.New("callback expired")
+)
+
+// CallbackCodec signs the callback data of the buttons. The data keeps the
+// telebot form "\funique|args" followed by "|<version><expiry>.<signature>".
+// The signature covers the user the button was sent to, who isn't stored in
+// the data but is taken from the press, so a button only works for that user.
+type CallbackCodec struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewCallbackCodec creates the codec. Without a configured secret the key is
+// derived from the bot token, so the buttons survive restarts either way.
+func NewCallbackCodec(config CallbackConfig, token string) (*CallbackCodec, error) {
+	secret := config.Secret
+	if secret == "" {
+		if token == "" {
+			return nil, errors.New("callback secret or bot token is required")
+		}
+		secret = "wakey callbacks:" + token
+	} else if len(secret) < minCallbackSecretLen {
+		return nil, errors.New("callback secret must be at least 16 characters")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	cc := &CallbackCodec{
+		key: key[:],
+		ttl: time.Duration(config.TTL) * 24 * time.Hour,
+	}
+	if cc.ttl <= 0 {
+		cc.ttl = defaultCallbackTTL
+	}
+
+	return cc, nil
+}
+
+// Encode returns the signed callback data of a button sent to the user
+func (cc *CallbackCodec) Encode(userID int64, unique string, args []string, now time.Time) string {
+	payload := "\f" + unique
+	if len(args) > 0 {
+		payload += "|" + strings.Join(args, "|")
+	}
+	expiry := strconv.FormatInt(now.Add(cc.ttl).Unix(), 36)
+
+	return payload + "|" + string(callbackVersion) + expiry + "." + cc.sign(userID, payload, expiry)
+}
+
+// Decode checks the data of a button pressed by the user and returns it
+// without the signature
+func (cc *CallbackCodec) Decode(userID int64, data string, now time.Time) (string, error) {
+	idx := strings.LastIndex(data, "|")
+	if idx < 0 {
+		return "", ErrCallbackInvalid
+	}
+	payload, suffix := data[:idx], data[idx+1:]
+
+	if suffix == "" || suffix[0] != callbackVersion {
+		return "", ErrCallbackInvalid
+	}
+	expiry, sig, ok := strings.Cut(suffix[1:], ".")
+	if !ok {
+		return "", ErrCallbackInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(cc.sign(userID, payload, expiry))) {
+		return "", ErrCallbackInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 36, 64)
+	if err != nil {
+		return "", ErrCallbackInvalid
+	}
+	if now.Unix() > expiresAt {
+		return "", ErrCallbackExpired
+	}
+
+	return payload, nil
+}
+
+func (cc *CallbackCodec) sign(userID int64, payload, expiry string) string {
+	mac := hmac.New(sha256.New, cc.key)
+	mac.Write([]byte{callbackVersion})
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(expiry))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSigLen])
+}
+
+// btn creates an inline button that only the user can press
+func (cc *CallbackCodec) btn(userID int64, text, unique string, args ...string) tele.Btn {
+	return tele.Btn{
+		Text: text,
+		Data: cc.Encode(userID, unique, args, time.Now()),
+	}
+}
+
+// senderBtn creates an inline button for the message sent in reply to the user
+func (cc *CallbackCodec) senderBtn(c tele.Context, text, unique string, args ...string) tele.Btn {
+	return cc.btn(c.Sender().ID, text, unique, args...)
+}
+
+// decodeCallback checks the pressed button and replaces the callback data with
+// the unsigned one the handlers parse
+func (cc *CallbackCodec) decodeCallback(c tele.Context) error {
+	data, err := cc.Decode(c.Sender().ID, c.Callback().Data, time.Now())
+	if err != nil {
+		return err
+	}
+	c.Callback().Data = data
+	return nil
+}
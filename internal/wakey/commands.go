@@ -0,0 +1,112 @@
+package wakey
+
+import (
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// botCommand is an entry of the Telegram command menu, the description is a
+// message catalog key
+type botCommand struct {
+	name string
+	desc string
+}
+
+// userCommands are shown to everyone in private chats
+var userCommands = []botCommand{
+	{"start", "command.start"},
+	{"mood", "command.mood"},
+	{"cancel", "command.cancel"},
+}
+
+// adminCommands are shown in the admin's chat only, after the user commands
+var adminCommands = []botCommand{
+	{"stat", "command.stat"},
+	{"notify", "command.notify"},
+}
+
+// Deep links are t.me/<bot>?start=<payload> links, Telegram sends the payload
+// with the /start command. The referral payload is the prefix followed by the
+// referral code of the user who shared the link.
+const (
+	deepLinkReferral = "ref_"
+	deepLinkSendWish = "send_wish"
+)
+
+// deepLink is the parsed payload of a deep link
+type deepLink struct {
+	action string
+	// referralCode is the code of the user who shared a referral link
+	referralCode string
+}
+
+// parseDeepLink returns the action of the /start payload. Unknown payloads
+// give an empty action, so that they start the bot as usual.
+func parseDeepLink(payload string) deepLink {
+	payload = strings.TrimSpace(payload)
+
+	if payload == deepLinkSendWish {
+		return deepLink{action: deepLinkSendWish}
+	}
+	if code, ok := strings.CutPrefix(payload, deepLinkReferral); ok && code != "" {
+		return deepLink{action: deepLinkReferral, referralCode: code}
+	}
+
+	return deepLink{}
+}
+
+// referralLink returns the invite link with the user's referral code
+func referralLink(botName, code string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", botName, deepLinkReferral, code)
+}
+
+func localizedCommands(lang string, lists ...[]botCommand) []tele.Command {
+	var commands []tele.Command
+	for _, list := range lists {
+		for _, cmd := range list {
+			commands = append(commands, tele.Command{Text: cmd.name, Description: T(lang, cmd.desc)})
+		}
+	}
+	return commands
+}
+
+// commandMenu is the menu of the commands published for a scope
+type commandMenu struct {
+	scope tele.CommandScope
+	lists [][]botCommand
+}
+
+func commandMenus(adminID int64) []commandMenu {
+	menus := []commandMenu{
+		{tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}, [][]botCommand{userCommands}},
+	}
+	if adminID != 0 {
+		menus = append(menus, commandMenu{
+			tele.CommandScope{Type: tele.CommandScopeChat, ChatID: adminID},
+			[][]botCommand{userCommands, adminCommands},
+		})
+	}
+	return menus
+}
+
+// setCommands publishes the command menus. Every menu is published for each
+// supported language and in the default one for the clients in other
+// languages. Telegram picks the admin chat menu over the private chats one.
+func (bot *Bot) setCommands(adminID int64) error {
+	for _, menu := range commandMenus(adminID) {
+		err := bot.api.SetCommands(localizedCommands(DefaultLang, menu.lists...), menu.scope)
+		if err != nil {
+			return fmt.Errorf("failed to set %s commands: %w", menu.scope.Type, err)
+		}
+		for _, lang := range Languages() {
+			err := bot.api.SetCommands(localizedCommands(lang, menu.lists...), menu.scope, lang)
+			if err != nil {
+				return fmt.Errorf("failed to set %s commands in %s: %w", menu.scope.Type, lang, err)
+			}
+		}
+	}
+
+	return nil
+}
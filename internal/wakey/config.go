@@ -18,6 +18,87 @@ type Config struct {
 	MaxStateAge int   `koanf:"max_state_age"`
 	MaxToxic    int16 `koanf:"max_toxic"`
 	Moderation  ModerationConfig
+	Translation TranslationConfig
+	Delivery    DeliveryConfig
+	Matching    MatchingConfig
+	Validation  ValidationConfig
+	Transport   TransportConfig
+	RateLimit   RateLimitConfig `koanf:"rate_limit"`
+	Callbacks   CallbackConfig
+	Reports     ReportConfig
+}
+
+type ReportConfig struct {
+	DefaultDays   int     `koanf:"default_days"`   // period of /stat without arguments
+	MaxDays       int     `koanf:"max_days"`       // longest period of a report
+	WeeklyDay     string  `koanf:"weekly_day"`     // weekday the admin gets the weekly report on, Monday by default
+	WeeklyTime    string  `koanf:"weekly_time"`    // UTC time of the weekly report, HH:MM
+	DisableWeekly bool    `koanf:"disable_weekly"` // don't send the weekly report
+	AnomalyDrop   float64 `koanf:"anomaly_drop"`   // percent a metric has to worsen by week over week to be highlighted
+}
+
+type CallbackConfig struct {
+	Secret string // key signing the buttons, derived from the bot token when empty
+	TTL    int    // days a button stays valid
+}
+
+type RateLimitConfig struct {
+	Messages     RateLimit
+	Callbacks    RateLimit
+	WishLookups  RateLimit `koanf:"wish_lookups"`
+	ReportAfter  int       `koanf:"report_after"`  // throttled updates within the window before the admin is told
+	ReportWindow int       `koanf:"report_window"` // minutes
+}
+
+// RateLimit allows bursts of Burst updates and Rate updates per minute on average
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type TransportConfig struct {
+	Mode            TransportMode
+	PollTimeout     int `koanf:"poll_timeout"`     // seconds
+	ShutdownTimeout int `koanf:"shutdown_timeout"` // seconds
+	Webhook         WebhookConfig
+}
+
+type WebhookConfig struct {
+	Listen         string
+	PublicURL      string `koanf:"public_url"`
+	SecretToken    string `koanf:"secret_token"`
+	CertFile       string `koanf:"cert_file"`
+	KeyFile        string `koanf:"key_file"`
+	SelfSigned     bool   `koanf:"self_signed"`
+	MaxConnections int    `koanf:"max_connections"`
+}
+
+type DeliveryConfig struct {
+	GracePeriod   int `koanf:"grace_period"`
+	SweepInterval int `koanf:"sweep_interval"`
+}
+
+type MatchingConfig struct {
+	Strategy         MatchStrategyName
+	MaxWishesPerPlan int `koanf:"max_wishes_per_plan"`
+	RecentDays       int `koanf:"recent_days"`
+}
+
+type ValidationConfig struct {
+	Name         FieldRules
+	Bio          FieldRules
+	Plans        FieldRules
+	Wish         FieldRules
+	RepeatLimit  int `koanf:"repeat_limit"`  // identical wishes a user may send within the window
+	RepeatWindow int `koanf:"repeat_window"` // hours
+}
+
+// FieldRules limit the text users enter into a field
+type FieldRules struct {
+	MinLen     int      `koanf:"min_len"`
+	MaxLen     int      `koanf:"max_len"`
+	MinLetters int      `koanf:"min_letters"` // letters and digits, emoji don't count
+	Disallowed []string // regular expressions the text must not match
 }
 
 type ModerationConfig struct {
@@ -27,6 +108,14 @@ type ModerationConfig struct {
 	MaxTok int `koanf:"max_tok"`
 }
 
+type TranslationConfig struct {
+	Provider TranslatorName
+	Prompt   string
+	Temp     float64
+	MaxTok   int `koanf:"max_tok"`
+	Timeout  int // seconds
+}
+
 type LLMConfig struct {
 	Provider   LLMProvider
 	APIKey     string `koanf:"api_key"`
@@ -1,36 +1,62 @@
 package wakey
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/glebarez/sqlite"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// likeEscaper escapes the special characters of the LIKE patterns
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
 var ErrNotFound = fmt.Errorf("record not found")
 
+const (
+	defaultMaxWishesPerPlan = 1
+	defaultRecentDays       = 7
+)
+
 type DB struct {
-	db        *gorm.DB
-	log       *zap.SugaredLogger
-	wishSubs  *SubscriptionManager
-	toxicSubs *SubscriptionManager
-	stateSubs *SubscriptionManager
+	db         *gorm.DB
+	log        *zap.SugaredLogger
+	matcher    MatchStrategy
+	maxWishes  int
+	recent     time.Duration
+	wishSubs   *SubscriptionManager[*Wish]
+	toxicSubs  *SubscriptionManager[*Wish]
+	stateSubs  *SubscriptionManager[*Wish]
+	reviewSubs *SubscriptionManager[*Wish]
+	userSubs   *SubscriptionManager[*User]
 }
 
 type User struct {
-	ID        int64 `gorm:"primaryKey;autoIncrement:false"`
-	Name      string
-	Bio       string
-	Tz        int32
-	IsBanned  bool
-	NotifyAt  time.Time
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt
+	ID            int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name          string
+	Bio           string
+	Tz            int32
+	Lang          string `gorm:"default:ru"`
+	IsBanned      bool
+	IsUnreachable bool
+	AllowRedirect bool
+	DigestEnabled bool
+	DigestDay     time.Weekday
+	NotifyAt      time.Time
+	ReferrerID    int64          `gorm:"index"`       // user whose invite link brought this one, 0 if none
+	ReferralCode  sql.NullString `gorm:"uniqueIndex"` // opaque code of the user's invite link
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt
 }
 
 type Plan struct {
@@ -39,43 +65,79 @@ type Plan struct {
 	Content   string
 	WakeAt    time.Time
 	OfferedAt time.Time
+	Mood      int8
 }
 
-type WishState string
+type PlanTag struct {
+	ID        uint    `gorm:"primarykey"`
+	PlanID    uint    `gorm:"uniqueIndex:idx_plan_tags_tag"`
+	Kind      TagKind `gorm:"uniqueIndex:idx_plan_tags_tag"`
+	Tag       string  `gorm:"uniqueIndex:idx_plan_tags_tag"`
+	CreatedAt time.Time
+}
+
+type MediaType string
 
 const (
-	WishStateNew      WishState = "N"
-	WishStateSent     WishState = "S"
-	WishStateLiked    WishState = "L"
-	WishStateDisliked WishState = "D"
-	WishStateReported WishState = "R"
-	WishStateBanned   WishState = "B"
+	MediaNone      MediaType = ""
+	MediaVoice     MediaType = "voice"
+	MediaPhoto     MediaType = "photo"
+	MediaSticker   MediaType = "sticker"
+	MediaVideoNote MediaType = "video_note"
 )
 
 type Wish struct {
 	gorm.Model
-	FromID   int64
-	PlanID   uint
-	Content  string
-	State    WishState `gorm:"type:char(1);default:'N'"`
-	Toxicity sql.NullInt16
+	FromID          int64
+	PlanID          uint
+	Content         string
+	MediaType       MediaType
+	FileID          string
+	State           WishState `gorm:"type:char(1);default:'N'"`
+	Toxicity        sql.NullInt16
+	NeedsReview     bool
+	Lang            string // language of the content, detected by the moderator
+	Translation     string // cached translation of the content into TranslationLang
+	TranslationLang string
+	ContentVersion  uint // bumped on every edit, results for older versions are dropped
+}
+
+type Draft struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Content   string
+	MediaType MediaType
+	FileID    string
+	UpdatedAt time.Time
 }
 
-type Stats struct {
-	TotalUsers  int64
-	TotalPlans  int64
-	TotalWishes int64
+type Block struct {
+	ID        uint  `gorm:"primarykey"`
+	UserID    int64 `gorm:"uniqueIndex:idx_blocks_pair"`
+	BlockedID int64 `gorm:"uniqueIndex:idx_blocks_pair"`
+	Label     string
+	CreatedAt time.Time
+}
+
+// Referrer is a user who brought others with the invite link
+type Referrer struct {
+	UserID   int64
+	Name     string
+	Referred int64
+}
 
-	NewUsersLast7Days    int64
-	ActiveUsersLast7Days int64
+// topReferrersLimit is the length of the top referrers list in the report
+const topReferrersLimit = 5
 
-	AvgPlansLast7Days  float64
-	AvgWishesLast7Days float64
+type SenderDigest struct {
+	Sent      int64
+	Delivered int64
+	Read      int64
+	Liked     int64
 
-	TotalLikedWishes            int64
-	LikedWishesPercent          float64
-	LikedWishesLast7Days        int64
-	LikedWishesLast7DaysPercent float64
+	// SupportedUsers is the number of different recipients over all time
+	SupportedUsers int64
+	// WeekStreak is the number of consecutive weeks with at least one wish sent
+	WeekStreak int
 }
 
 type State struct {
@@ -91,19 +153,30 @@ func LoadDatabase(path string) (*DB, bool) {
 		return nil, false
 	}
 
-	err = db.AutoMigrate(&User{}, &Plan{}, &Wish{}, &State{})
+	err = db.AutoMigrate(&User{}, &Plan{}, &Wish{}, &State{}, &Block{}, &Draft{}, &PlanTag{}, &WishTransition{})
 	if err != nil {
 		log.Error(err)
 		return nil, false
 	}
 
-	return &DB{
-		db:  db,
-		log: log,
+	err = assignReferralCodes(db)
+	if err != nil {
+		log.Error(err)
+		return nil, false
+	}
 
-		wishSubs:  NewSubscriptionManager("wish", log),
-		toxicSubs: NewSubscriptionManager("toxicity", log),
-		stateSubs: NewSubscriptionManager("state", log),
+	return &DB{
+		db:        db,
+		log:       log,
+		matcher:   NewFairMatchStrategy(),
+		maxWishes: defaultMaxWishesPerPlan,
+		recent:    defaultRecentDays * 24 * time.Hour,
+
+		wishSubs:   NewSubscriptionManager[*Wish]("wish", log),
+		toxicSubs:  NewSubscriptionManager[*Wish]("toxicity", log),
+		stateSubs:  NewSubscriptionManager[*Wish]("state", log),
+		reviewSubs: NewSubscriptionManager[*Wish]("review", log),
+		userSubs:   NewSubscriptionManager[*User]("user", log),
 	}, true
 }
 
@@ -112,6 +185,24 @@ func (db *DB) Stop() {
 	db.wishSubs.Close()
 	db.toxicSubs.Close()
 	db.stateSubs.Close()
+	db.reviewSubs.Close()
+	db.userSubs.Close()
+}
+
+// SetMatching configures how plans are chosen for new wishes
+func (db *DB) SetMatching(strategy MatchStrategy, cfg MatchingConfig) {
+	db.matcher = strategy
+
+	db.maxWishes = cfg.MaxWishesPerPlan
+	if db.maxWishes <= 0 {
+		db.maxWishes = defaultMaxWishesPerPlan
+	}
+
+	recentDays := cfg.RecentDays
+	if recentDays <= 0 {
+		recentDays = defaultRecentDays
+	}
+	db.recent = time.Duration(recentDays) * 24 * time.Hour
 }
 
 // SubscribeToWishes returns a channel for wish notifications and an unsubscribe function
@@ -129,111 +220,184 @@ func (db *DB) SubscribeToStateUpdates(bufSize int) (<-chan *Wish, func()) {
 	return db.stateSubs.Subscribe(bufSize)
 }
 
-func (db *DB) GetStats() (*Stats, error) {
-	stats := &Stats{}
+// SubscribeToReviews returns a channel for notifications about wishes that need human review and an unsubscribe function
+func (db *DB) SubscribeToReviews(bufSize int) (<-chan *Wish, func()) {
+	return db.reviewSubs.Subscribe(bufSize)
+}
 
-	// Get total counts
-	err := db.db.Model(&User{}).Count(&stats.TotalUsers).Error
-	if err != nil {
-		return nil, err
-	}
+// SubscribeToReachability returns a channel for user reachability change notifications and an unsubscribe function
+func (db *DB) SubscribeToReachability(bufSize int) (<-chan *User, func()) {
+	return db.userSubs.Subscribe(bufSize)
+}
+
+// GetReportData loads the data of the report from the given time. It runs a
+// query per kind of data and leaves the aggregation to BuildReport.
+func (db *DB) GetReportData(since, until time.Time) (*ReportData, error) {
+	data := &ReportData{}
 
-	err = db.db.Model(&Plan{}).Count(&stats.TotalPlans).Error
+	err := db.db.Model(&User{}).Count(&data.TotalUsers).Error
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.db.Model(&Wish{}).Count(&stats.TotalWishes).Error
+	var users []User
+	err = db.db.Select("id", "referrer_id", "created_at").
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
+	for _, user := range users {
+		data.Signups = append(data.Signups, Signup{UserID: user.ID, At: user.CreatedAt, Referred: user.ReferrerID != 0})
+	}
 
-	// Get new users in last 7 days
-	sevenDaysAgo := time.Now().UTC().AddDate(0, 0, -7)
-	err = db.db.Model(&User{}).
-		Where("created_at >= ?", sevenDaysAgo).
-		Count(&stats.NewUsersLast7Days).Error
+	var plans []Plan
+	err = db.db.Select("user_id", "created_at").
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Find(&plans).Error
 	if err != nil {
 		return nil, err
 	}
+	for _, plan := range plans {
+		data.Activity = append(data.Activity, Activity{UserID: plan.UserID, Kind: ActivityPlan, At: plan.CreatedAt})
+	}
 
-	// Get active users (users with plans or wishes) in last 7 days
-	var activeUsers int64
-	err = db.db.Model(&User{}).
-		Where("id IN (?)",
-			db.db.Model(&Plan{}).
-				Select("user_id").
-				Where("created_at >= ?", sevenDaysAgo),
-		).Or("id IN (?)",
-		db.db.Model(&Wish{}).
-			Select("from_id").
-			Where("created_at >= ?", sevenDaysAgo),
-	).Count(&activeUsers).Error
+	var wishes []Wish
+	err = db.db.Select("from_id", "created_at").
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Find(&wishes).Error
 	if err != nil {
 		return nil, err
 	}
-	stats.ActiveUsersLast7Days = activeUsers
+	for _, wish := range wishes {
+		data.Activity = append(data.Activity, Activity{UserID: wish.FromID, Kind: ActivityWish, At: wish.CreatedAt})
+	}
 
-	// Get average plans per day for last 7 days
-	var plansLast7Days int64
-	err = db.db.Model(&Plan{}).
-		Where("created_at >= ?", sevenDaysAgo).
-		Count(&plansLast7Days).Error
+	// Deliveries and reactions with the time the wishes were written
+	states := append([]WishState{WishStateSent}, wishReactions...)
+	err = db.db.Model(&WishTransition{}).
+		Select("wish_transitions.wish_id AS wish_id, wish_transitions.to_state AS state, "+
+			"wish_transitions.created_at AS at, wishes.created_at AS written_at").
+		Joins("JOIN wishes ON wishes.id = wish_transitions.wish_id").
+		Where("wish_transitions.to_state IN ? AND wish_transitions.created_at >= ? AND wish_transitions.created_at < ?", states, since, until).
+		Order("wish_transitions.id").
+		Scan(&data.WishEvents).Error
 	if err != nil {
 		return nil, err
 	}
-	stats.AvgPlansLast7Days = float64(plansLast7Days) / 7.0
 
-	// Get average wishes per day for last 7 days
-	var wishesLast7Days int64
-	err = db.db.Model(&Wish{}).
-		Where("created_at >= ?", sevenDaysAgo).
-		Count(&wishesLast7Days).Error
+	data.TopReferrers, err = db.GetTopReferrers(topReferrersLimit)
 	if err != nil {
 		return nil, err
 	}
-	stats.AvgWishesLast7Days = float64(wishesLast7Days) / 7.0
 
-	// Calculate total liked wishes and percentage
-	var totalLikedWishes int64
-	err = db.db.Model(&Wish{}).
-		Where("state = ?", WishStateLiked).
-		Count(&totalLikedWishes).Error
+	return data, nil
+}
+
+// GetSenderDigest returns statistics about the wishes sent by the user since the given time
+func (db *DB) GetSenderDigest(userID int64, since time.Time) (*SenderDigest, error) {
+	digest := &SenderDigest{}
+
+	var rows []struct {
+		State WishState
+		Count int64
+	}
+	err := db.db.Model(&Wish{}).
+		Select("state, COUNT(*) AS count").
+		Where("from_id = ? AND created_at >= ? AND state != ?", userID, since, WishStateRetracted).
+		Group("state").
+		Scan(&rows).Error
 	if err != nil {
 		return nil, err
 	}
-	stats.TotalLikedWishes = totalLikedWishes
 
-	if stats.TotalWishes > 0 {
-		stats.LikedWishesPercent = float64(totalLikedWishes) * 100.0 / float64(stats.TotalWishes)
+	for _, row := range rows {
+		digest.Sent += row.Count
+		switch row.State {
+		case WishStateSent:
+			digest.Delivered += row.Count
+		case WishStateLiked:
+			digest.Liked += row.Count
+			fallthrough
+		case WishStateRead, WishStateDisliked, WishStateReported:
+			digest.Delivered += row.Count
+			digest.Read += row.Count
+		}
 	}
 
-	// Calculate liked wishes in last 7 days and percentage
-	var likedWishesLast7Days int64
 	err = db.db.Model(&Wish{}).
-		Where("state = ? AND created_at >= ?", WishStateLiked, sevenDaysAgo).
-		Count(&likedWishesLast7Days).Error
+		Joins("JOIN plans ON wishes.plan_id = plans.id").
+		Where("wishes.from_id = ?", userID).
+		Where("wishes.state IN ?", []WishState{WishStateSent, WishStateRead, WishStateLiked, WishStateDisliked, WishStateReported}).
+		Distinct("plans.user_id").
+		Count(&digest.SupportedUsers).Error
 	if err != nil {
 		return nil, err
 	}
-	stats.LikedWishesLast7Days = likedWishesLast7Days
 
-	var totalWishesLast7Days int64
+	var sentAt []time.Time
 	err = db.db.Model(&Wish{}).
-		Where("created_at >= ?", sevenDaysAgo).
-		Count(&totalWishesLast7Days).Error
+		Where("from_id = ? AND state != ?", userID, WishStateRetracted).
+		Order("created_at DESC").
+		Pluck("created_at", &sentAt).Error
 	if err != nil {
 		return nil, err
 	}
 
-	if totalWishesLast7Days > 0 {
-		stats.LikedWishesLast7DaysPercent = float64(likedWishesLast7Days) * 100.0 / float64(totalWishesLast7Days)
+	// Count weeks back from now until the first one without wishes
+	now := time.Now()
+	weeks := make(map[int]bool)
+	for _, createdAt := range sentAt {
+		weeks[int(now.Sub(createdAt)/(7*24*time.Hour))] = true
+	}
+	for weeks[digest.WeekStreak] {
+		digest.WeekStreak++
+	}
+
+	return digest, nil
+}
+
+// assignReferralCodes gives the codes to the users who registered before
+// the invite links got them
+func assignReferralCodes(db *gorm.DB) error {
+	var ids []int64
+	err := db.Model(&User{}).Unscoped().Where("referral_code IS NULL").Pluck("id", &ids).Error
+	if err != nil {
+		return err
 	}
 
-	return stats, nil
+	for _, id := range ids {
+		code, err := newReferralCode()
+		if err != nil {
+			return err
+		}
+		err = db.Model(&User{}).Unscoped().Where("id = ?", id).Update("referral_code", code).Error
+		if err != nil {
+			return fmt.Errorf("failed to assign referral code to user %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// newReferralCode returns a random code for the invite link that doesn't
+// reveal the user's Telegram ID
+func newReferralCode() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(binary.BigEndian.Uint64(buf[:]), 36), nil
 }
 
 func (db *DB) CreateUser(user *User) error {
+	if !user.ReferralCode.Valid {
+		code, err := newReferralCode()
+		if err != nil {
+			return err
+		}
+		user.ReferralCode = sql.NullString{String: code, Valid: true}
+	}
+
 	result := db.db.Create(user)
 	if result.Error != nil {
 		if strings.Contains(result.Error.Error(), "UNIQUE constraint failed") {
@@ -264,6 +428,29 @@ func (db *DB) GetUserByID(userID int64) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByReferralCode returns the user whose invite link carries the code
+func (db *DB) GetUserByReferralCode(code string) (*User, error) {
+	var user User
+	result := db.db.Where("referral_code = ?", code).Limit(1).Find(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+// GetUserLang returns the user's language or the default one if it is unknown
+func (db *DB) GetUserLang(userID int64) string {
+	var user User
+	result := db.db.Select("lang").Where("id = ?", userID).Limit(1).Find(&user)
+	if result.Error != nil || result.RowsAffected == 0 || user.Lang == "" {
+		return DefaultLang
+	}
+	return user.Lang
+}
+
 func (db *DB) GetAllUsers() ([]*User, error) {
 	var users []*User
 	result := db.db.Find(&users)
@@ -273,8 +460,9 @@ func (db *DB) GetAllUsers() ([]*User, error) {
 	return users, nil
 }
 
-// BanUser sets a user's IsBanned status to true and updates all their new wishes to banned state
-func (db *DB) BanUser(userID int64) error {
+// BanUser sets a user's IsBanned status to true and bans all their wishes
+// waiting for the delivery on behalf of the admin
+func (db *DB) BanUser(userID, adminID int64) error {
 	// Start a transaction
 	tx := db.db.Begin()
 	if tx.Error != nil {
@@ -297,25 +485,123 @@ func (db *DB) BanUser(userID int64) error {
 		return ErrNotFound
 	}
 
-	// Find all new wishes from this user and update their state to banned
+	// Find all pending wishes from this user and update their state to banned
 	var wishes []Wish
-	result = tx.Where("from_id = ? AND state = ?", userID, WishStateNew).Find(&wishes)
+	result = tx.Where("from_id = ? AND state IN ?", userID, pendingWishStates).Find(&wishes)
 	if result.Error != nil {
 		tx.Rollback()
 		return result.Error
 	}
 
-	for _, wish := range wishes {
-		wish.State = WishStateBanned
-		if err := tx.Save(&wish).Error; err != nil {
+	for i := range wishes {
+		if err := changeWishState(tx, &wishes[i], WishStateBanned, adminID); err != nil {
 			tx.Rollback()
 			return err
 		}
-		// Notify subscribers about state change
-		db.stateSubs.Notify(&wish)
 	}
 
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// Notify subscribers about state change
+	for i := range wishes {
+		db.stateSubs.Notify(&wishes[i])
+	}
+
+	return nil
+}
+
+// ReleaseHeldWishes returns the held wishes of the user to the delivery once
+// the admin has reviewed them
+func (db *DB) ReleaseHeldWishes(userID, adminID int64) (int, error) {
+	var wishes []Wish
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("from_id = ? AND state = ?", userID, WishStateHeld).Find(&wishes)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for i := range wishes {
+			if err := changeWishState(tx, &wishes[i], WishStateNew, adminID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range wishes {
+		db.stateSubs.Notify(&wishes[i])
+	}
+
+	return len(wishes), nil
+}
+
+// MarkWishesRead marks the wishes delivered to the user as read. The wishes
+// rated since the lookup keep their state.
+func (db *DB) MarkWishesRead(userID int64) error {
+	var wishes []Wish
+	result := db.db.
+		Joins("JOIN plans ON wishes.plan_id = plans.id").
+		Where("plans.user_id = ? AND wishes.state = ?", userID, WishStateSent).
+		Find(&wishes)
+	if result.Error != nil {
+		return result.Error
+	}
+	if len(wishes) == 0 {
+		return nil
+	}
+
+	var read []*Wish
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		for i := range wishes {
+			err := changeWishState(tx, &wishes[i], WishStateRead, userID)
+			var transitionErr *WishTransitionError
+			if errors.As(err, &transitionErr) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			read = append(read, &wishes[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, wish := range read {
+		db.stateSubs.Notify(wish)
+	}
+
+	return nil
+}
+
+// SetUserUnreachable updates the user's reachability and notifies subscribers if it has changed
+func (db *DB) SetUserUnreachable(userID int64, unreachable bool) error {
+	result := db.db.Model(&User{}).
+		Where("id = ? AND is_unreachable != ?", userID, unreachable).
+		Update("is_unreachable", unreachable)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		_, err := db.GetUserByID(userID)
+		return err
+	}
+
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	db.userSubs.Notify(user)
+
+	return nil
 }
 
 func (db *DB) SavePlan(plan *Plan) error {
@@ -386,6 +672,15 @@ func (db *DB) GetPlanByID(planID uint) (*Plan, error) {
 	return &plan, nil
 }
 
+// ReleasePlanOffer makes the plan available for other senders right away.
+// Nothing changes if the offer made at offeredAt has already expired and
+// the plan has been offered to someone else.
+func (db *DB) ReleasePlanOffer(planID uint, offeredAt time.Time) error {
+	return db.db.Model(&Plan{}).
+		Where("id = ? AND offered_at = ?", planID, offeredAt).
+		Update("offered_at", time.Time{}).Error
+}
+
 func (db *DB) GetAllPlansForUser(userID int64) ([]Plan, error) {
 	var plans []Plan
 	result := db.db.Where("user_id = ?", userID).
@@ -397,35 +692,325 @@ func (db *DB) GetAllPlansForUser(userID int64) ([]Plan, error) {
 	return plans, nil
 }
 
+// GetPlansPage returns a page of the user's plans from the newest to the oldest
+// together with the total number of plans
+func (db *DB) GetPlansPage(userID int64, offset, limit int) ([]Plan, int64, error) {
+	var total int64
+	if err := db.db.Model(&Plan{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var plans []Plan
+	result := db.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&plans)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return plans, total, nil
+}
+
+// SearchPlans returns the newest user's plans containing the query
+func (db *DB) SearchPlans(userID int64, query string, limit int) ([]Plan, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	var plans []Plan
+	result := db.db.Where("user_id = ? AND content LIKE ? ESCAPE '\\'", userID, pattern).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&plans)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return plans, nil
+}
+
+// GetPlanStreaks returns the current and the longest number of consecutive days
+// with a status update. Days are counted in the given location.
+// The current streak is kept until the end of the day after the last update.
+func (db *DB) GetPlanStreaks(userID int64, loc *time.Location, now time.Time) (current, longest int, err error) {
+	var createdAt []time.Time
+	err = db.db.Model(&Plan{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Pluck("created_at", &createdAt).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dayOf := func(t time.Time) time.Time {
+		local := t.In(loc)
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	var days []time.Time
+	for _, t := range createdAt {
+		day := dayOf(t)
+		if len(days) == 0 || !day.Equal(days[len(days)-1]) {
+			days = append(days, day)
+		}
+	}
+
+	runStart := 0
+	for i := range days {
+		if i > 0 && !days[i].Equal(days[i-1].AddDate(0, 0, -1)) {
+			runStart = i
+		}
+		streak := i - runStart + 1
+		longest = max(longest, streak)
+		if runStart == 0 {
+			current = streak
+		}
+	}
+	if len(days) > 0 && days[0].Before(dayOf(now).AddDate(0, 0, -1)) {
+		current = 0
+	}
+
+	return current, longest, nil
+}
+
+// ResetPlanMood removes the mood rating and the tags of the user's plan
+func (db *DB) ResetPlanMood(planID uint, userID int64) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Plan{}).
+			Where("id = ? AND user_id = ?", planID, userID).
+			Update("mood", 0)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return tx.Where("plan_id = ?", planID).Delete(&PlanTag{}).Error
+	})
+}
+
+// SetPlanMood sets the mood rating of the user's plan
+func (db *DB) SetPlanMood(planID uint, userID int64, mood int8) error {
+	if mood < MinMood || mood > MaxMood {
+		return fmt.Errorf("mood %d is out of range", mood)
+	}
+
+	result := db.db.Model(&Plan{}).
+		Where("id = ? AND user_id = ?", planID, userID).
+		Update("mood", mood)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TogglePlanTag adds the tag to the user's plan or removes it if it is already there.
+// Returns whether the tag is selected after the call.
+func (db *DB) TogglePlanTag(planID uint, userID int64, kind TagKind, tag string) (bool, error) {
+	if _, ok := LookupMoodTag(kind, tag); !ok {
+		return false, fmt.Errorf("unknown %s tag %q", kind, tag)
+	}
+
+	selected := false
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&Plan{}).Where("id = ? AND user_id = ?", planID, userID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+
+		result := tx.Where("plan_id = ? AND kind = ? AND tag = ?", planID, kind, tag).Delete(&PlanTag{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		selected = true
+		return tx.Create(&PlanTag{PlanID: planID, Kind: kind, Tag: tag}).Error
+	})
+
+	return selected, err
+}
+
+func (db *DB) GetPlanTags(planID uint) ([]PlanTag, error) {
+	var tags []PlanTag
+	result := db.db.Where("plan_id = ?", planID).Order("id").Find(&tags)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tags, nil
+}
+
+// GetMoodEntries returns the user's plans created since the given time
+// with their mood ratings and tags, from the oldest to the newest
+func (db *DB) GetMoodEntries(userID int64, since time.Time) ([]MoodEntry, error) {
+	var plans []Plan
+	result := db.db.Where("user_id = ? AND created_at >= ?", userID, since).
+		Order("created_at").
+		Find(&plans)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	entries := make([]MoodEntry, len(plans))
+	planIDs := make([]uint, len(plans))
+	index := make(map[uint]int, len(plans))
+	for i, plan := range plans {
+		entries[i] = MoodEntry{PlanID: plan.ID, CreatedAt: plan.CreatedAt, Mood: plan.Mood}
+		planIDs[i] = plan.ID
+		index[plan.ID] = i
+	}
+	if len(plans) == 0 {
+		return entries, nil
+	}
+
+	var tags []PlanTag
+	result = db.db.Where("plan_id IN ?", planIDs).Order("id").Find(&tags)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, tag := range tags {
+		i := index[tag.PlanID]
+		entries[i].Tags = append(entries[i].Tags, tag)
+	}
+
+	return entries, nil
+}
+
 func (db *DB) FindPlanForWish(senderID int64) (*Plan, error) {
-	var plan Plan
 	now := time.Now().UTC()
 	oneHourAgo := now.Add(-1 * time.Hour)
-
-	result := db.db.
-		Joins("LEFT JOIN wishes ON plans.id = wishes.plan_id").
+	recentSince := now.Add(-db.recent)
+	inactiveStates := []WishState{WishStateBanned, WishStateUndelivered, WishStateRetracted}
+
+	planWishes := db.db.Model(&Wish{}).
+		Select("COUNT(*)").
+		Where("wishes.plan_id = plans.id AND wishes.state NOT IN ?", inactiveStates)
+	recentWishes := db.db.Model(&Wish{}).
+		Select("COUNT(*)").
+		Joins("JOIN plans AS p ON wishes.plan_id = p.id").
+		Where("p.user_id = plans.user_id AND wishes.created_at >= ?", recentSince)
+	pairWishes := db.db.Model(&Wish{}).
+		Select("COUNT(*)").
+		Joins("JOIN plans AS p ON wishes.plan_id = p.id").
+		Where("p.user_id = plans.user_id AND wishes.from_id = ?", senderID)
+
+	var candidates []MatchCandidate
+	result := db.db.Model(&Plan{}).
+		Select("plans.*, (?) AS plan_wishes, (?) AS recent_wishes, (?) AS pair_wishes",
+			planWishes, recentWishes, pairWishes).
+		Joins("JOIN users ON plans.user_id = users.id").
 		Where("plans.user_id != ?", senderID).
+		Where("users.is_unreachable = ? AND users.is_banned = ?", false, false).
 		Where("plans.wake_at > ?", now).
-		Where("wishes.id IS NULL").
 		Where("plans.offered_at < ?", oneHourAgo).
-		Order("RANDOM()").
-		Limit(1).
-		Find(&plan)
+		Where("(?) < ?", planWishes, db.maxWishes).
+		Where("NOT EXISTS (?)", db.blocksBetween("plans.user_id", senderID)).
+		Scan(&candidates)
 
 	if result.Error != nil {
 		return nil, result.Error
 	}
 
-	if result.RowsAffected == 0 {
+	idx, ok := db.matcher.Choose(candidates, now)
+	if !ok {
 		return nil, ErrNotFound
 	}
 
+	plan := candidates[idx].Plan
+	err := db.db.Model(&plan).Update("offered_at", now).Error
+	if err != nil {
+		return nil, err
+	}
 	plan.OfferedAt = now
-	db.db.Save(&plan)
 
 	return &plan, nil
 }
 
+// SaveDraft stores the user's unsent wish, replacing the previous one
+func (db *DB) SaveDraft(draft *Draft) error {
+	return db.db.Save(draft).Error
+}
+
+func (db *DB) GetDraft(userID int64) (*Draft, error) {
+	var draft Draft
+	result := db.db.Where("user_id = ?", userID).Limit(1).Find(&draft)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &draft, nil
+}
+
+func (db *DB) DeleteDraft(userID int64) error {
+	return db.db.Where("user_id = ?", userID).Delete(&Draft{}).Error
+}
+
+// blocksBetween returns a subquery for blocks in either direction between
+// the user in the given column and another user
+func (db *DB) blocksBetween(column string, userID int64) *gorm.DB {
+	return db.db.Model(&Block{}).
+		Select("1").
+		Where("(blocks.user_id = "+column+" AND blocks.blocked_id = ?) OR "+
+			"(blocks.user_id = ? AND blocks.blocked_id = "+column+")", userID, userID)
+}
+
+// BlockUser prevents any wishes between the two users
+func (db *DB) BlockUser(userID, blockedID int64, label string) error {
+	block := Block{
+		UserID:    userID,
+		BlockedID: blockedID,
+		Label:     label,
+	}
+	return db.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error
+}
+
+// UnblockUser removes the block created by the user
+func (db *DB) UnblockUser(userID int64, blockID uint) error {
+	result := db.db.Where("id = ? AND user_id = ?", blockID, userID).Delete(&Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetBlocks returns all blocks created by the user
+func (db *DB) GetBlocks(userID int64) ([]Block, error) {
+	var blocks []Block
+	result := db.db.Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&blocks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return blocks, nil
+}
+
+// IsBlocked reports whether either of the users has blocked the other
+func (db *DB) IsBlocked(userID, otherID int64) (bool, error) {
+	var count int64
+	err := db.db.Model(&Block{}).
+		Where("(user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)",
+			userID, otherID, otherID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func (db *DB) SaveWish(wish *Wish) error {
 	err := db.db.Create(wish).Error
 	if err != nil {
@@ -437,6 +1022,18 @@ func (db *DB) SaveWish(wish *Wish) error {
 	return nil
 }
 
+// CountRecentWishes returns the number of wishes with the same text the user has sent since the time
+func (db *DB) CountRecentWishes(fromID int64, content string, since time.Time) (int64, error) {
+	var count int64
+	err := db.db.Model(&Wish{}).
+		Where("from_id = ? AND TRIM(content) = ? AND created_at >= ?", fromID, strings.TrimSpace(content), since).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (db *DB) GetWishByID(wishID uint) (*Wish, error) {
 	var wish Wish
 	result := db.db.
@@ -465,62 +1062,254 @@ func (db *DB) GetNewWishesByUserID(userID int64) ([]Wish, error) {
 	return wishes, nil
 }
 
-func (db *DB) UpdateWishState(wishID uint, state WishState) error {
-	// First get the wish to send in notification
-	var wish Wish
-	result := db.db.Where("id = ?", wishID).First(&wish)
+// UpdateWishContent replaces the content of a wish that hasn't been delivered yet
+// and sends it to moderation again
+func (db *DB) UpdateWishContent(wishID uint, content string, mediaType MediaType, fileID string) error {
+	result := db.db.Model(&Wish{}).
+		Where("id = ? AND state = ?", wishID, WishStateNew).
+		Updates(map[string]interface{}{
+			"content":          content,
+			"media_type":       mediaType,
+			"file_id":          fileID,
+			"toxicity":         nil,
+			"needs_review":     false,
+			"lang":             "",
+			"translation":      "",
+			"translation_lang": "",
+			"content_version":  gorm.Expr("content_version + 1"),
+		})
 	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			return ErrNotFound
-		}
 		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
 
-	// Update state
-	result = db.db.Model(&wish).Update("state", state)
+	wish, err := db.GetWishByID(wishID)
+	if err != nil {
+		return err
+	}
+
+	db.wishSubs.Notify(wish)
+
+	return nil
+}
+
+// SetWishLanguage sets the detected language of the content version
+func (db *DB) SetWishLanguage(wishID, version uint, lang string) error {
+	result := db.db.Model(&Wish{}).
+		Where("id = ? AND content_version = ?", wishID, version).
+		Update("lang", lang)
 	if result.Error != nil {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return ErrNotFound
+		return staleContentError(db.db, wishID)
 	}
+	return nil
+}
 
-	// Update the wish object with new state
-	wish.State = state
-	db.stateSubs.Notify(&wish)
-
+// SaveWishTranslation caches the translation of the content version into the language
+func (db *DB) SaveWishTranslation(wishID, version uint, lang, translation string) error {
+	result := db.db.Model(&Wish{}).
+		Where("id = ? AND content_version = ?", wishID, version).
+		Updates(map[string]interface{}{
+			"translation":      translation,
+			"translation_lang": lang,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return staleContentError(db.db, wishID)
+	}
 	return nil
 }
 
-// GetUnratedWishes returns all wishes where toxicity is not set (equals 0)
-func (db *DB) GetUnratedWishes() ([]Wish, error) {
+// staleContentError tells why no wish has the content version: it returns
+// ErrNotFound if the wish is missing and ErrStaleContent if it was edited
+func staleContentError(tx *gorm.DB, wishID uint) error {
+	var count int64
+	if err := tx.Model(&Wish{}).Where("id = ?", wishID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrStaleContent
+}
+
+// GetUndeliveredWishes returns new wishes for plans whose wake time is before
+// the given time. Held wishes are left to the admin review.
+func (db *DB) GetUndeliveredWishes(before time.Time) ([]Wish, error) {
 	var wishes []Wish
-	result := db.db.Where("toxicity IS NULL").Find(&wishes)
+	result := db.db.
+		Joins("JOIN plans ON wishes.plan_id = plans.id").
+		Where("wishes.state = ? AND plans.wake_at < ?", WishStateNew, before).
+		Find(&wishes)
+
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return wishes, nil
 }
 
-// UpdateWishToxicity updates the toxicity score for a specific wish
-func (db *DB) UpdateWishToxicity(wishID uint, toxicity int) error {
-	// First get the wish to send in notification
+// ReassignWish moves a wish waiting for the delivery to another plan. It
+// returns ErrWishNotPending if the wish has already left the waiting states,
+// e.g. because the sender retracted it.
+func (db *DB) ReassignWish(wishID uint, planID uint) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		var wish Wish
+		result := tx.Where("id = ?", wishID).Limit(1).Find(&wish)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if !slices.Contains(pendingWishStates, wish.State) {
+			return ErrWishNotPending
+		}
+
+		result = tx.Model(&Wish{}).
+			Where("id = ? AND state = ?", wish.ID, wish.State).
+			Update("plan_id", planID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrWishNotPending
+		}
+		return nil
+	})
+}
+
+// UpdateWishState moves the wish to the state and records the transition made
+// by the actor. It returns a *WishTransitionError if the wish can't get the state.
+func (db *DB) UpdateWishState(wishID uint, state WishState, actorID int64) error {
 	var wish Wish
-	result := db.db.Where("id = ?", wishID).First(&wish)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", wishID).Limit(1).Find(&wish)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
 			return ErrNotFound
 		}
-		return result.Error
+
+		return changeWishState(tx, &wish, state, actorID)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Update toxicity
-	result = db.db.Model(&wish).Update("toxicity", toxicity)
+	db.stateSubs.Notify(&wish)
+
+	return nil
+}
+
+// GetWishHistory returns the state changes of the wish in the order they were made
+func (db *DB) GetWishHistory(wishID uint) ([]WishTransition, error) {
+	var history []WishTransition
+	result := db.db.Where("wish_id = ?", wishID).Order("id").Find(&history)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return history, nil
+}
+
+// changeWishState moves the wish to the state and records the transition. The
+// update is conditional on the state the wish was loaded in, so of concurrent
+// changes only the first one succeeds.
+func changeWishState(tx *gorm.DB, wish *Wish, state WishState, actorID int64) error {
+	if !CanTransition(wish.State, state) {
+		return &WishTransitionError{WishID: wish.ID, From: wish.State, To: state}
+	}
+
+	result := tx.Model(&Wish{}).
+		Where("id = ? AND state = ?", wish.ID, wish.State).
+		Update("state", state)
 	if result.Error != nil {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return ErrNotFound
+		return &WishTransitionError{WishID: wish.ID, From: wish.State, To: state}
+	}
+
+	transition := &WishTransition{WishID: wish.ID, FromState: wish.State, ToState: state, ActorID: actorID}
+	if err := tx.Create(transition).Error; err != nil {
+		return err
+	}
+
+	wish.State = state
+	return nil
+}
+
+// ReactToWish changes the state of a delivered wish on behalf of its
+// recipient. It returns ErrNotWishRecipient if the wish was delivered to
+// another user and a *WishTransitionError if the wish can't get the state,
+// e.g. because it has already been rated.
+func (db *DB) ReactToWish(wishID uint, userID int64, state WishState) (*Wish, error) {
+	var wish Wish
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", wishID).Limit(1).Find(&wish)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		var plan Plan
+		result = tx.Where("id = ?", wish.PlanID).Limit(1).Find(&plan)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || plan.UserID != userID {
+			return ErrNotWishRecipient
+		}
+
+		if !canReact(wish.State, state) {
+			return &WishTransitionError{WishID: wish.ID, From: wish.State, To: state}
+		}
+
+		return changeWishState(tx, &wish, state, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.stateSubs.Notify(&wish)
+
+	return &wish, nil
+}
+
+// GetUnratedWishes returns all wishes where toxicity is not set (equals 0)
+func (db *DB) GetUnratedWishes() ([]Wish, error) {
+	var wishes []Wish
+	result := db.db.Where("toxicity IS NULL").Find(&wishes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return wishes, nil
+}
+
+// UpdateWishToxicity updates the toxicity score of the content version
+func (db *DB) UpdateWishToxicity(wishID, version uint, toxicity int) error {
+	var wish Wish
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND content_version = ?", wishID, version).Limit(1).Find(&wish)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return staleContentError(tx, wishID)
+		}
+
+		return tx.Model(&wish).Update("toxicity", toxicity).Error
+	})
+	if err != nil {
+		return err
 	}
 
 	// Update the wish object with new toxicity value
@@ -530,6 +1319,45 @@ func (db *DB) UpdateWishToxicity(wishID uint, toxicity int) error {
 	return nil
 }
 
+// MarkWishForReview flags a content version that can't be checked
+// automatically for human review and holds the wish back from the delivery
+// until the admin releases it
+func (db *DB) MarkWishForReview(wishID, version uint) error {
+	var wish Wish
+	held := false
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND content_version = ?", wishID, version).Limit(1).Find(&wish)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return staleContentError(tx, wishID)
+		}
+
+		result = tx.Model(&wish).Update("needs_review", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		wish.NeedsReview = true
+
+		if wish.State != WishStateNew {
+			return nil
+		}
+		held = true
+		return changeWishState(tx, &wish, WishStateHeld, BotActorID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if held {
+		db.stateSubs.Notify(&wish)
+	}
+	db.reviewSubs.Notify(&wish)
+
+	return nil
+}
+
 func (db *DB) GetFuturePlans() ([]Plan, error) {
 	var plans []Plan
 	now := time.Now().UTC()
@@ -577,3 +1405,28 @@ func (db *DB) LoadStates() (map[int64]*UserData, error) {
 
 	return result, nil
 }
+
+// CountReferrals returns the number of users who joined with the user's invite link
+func (db *DB) CountReferrals(userID int64) (int64, error) {
+	var count int64
+	err := db.db.Model(&User{}).Where("referrer_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// GetTopReferrers returns the users who brought the most users with their
+// invite links, the ones who brought more first
+func (db *DB) GetTopReferrers(limit int) ([]Referrer, error) {
+	var referrers []Referrer
+	err := db.db.Model(&User{}).
+		Select("referrers.id AS user_id, referrers.name AS name, COUNT(*) AS referred").
+		Joins("JOIN users AS referrers ON referrers.id = users.referrer_id").
+		Where("users.referrer_id <> 0").
+		Group("referrers.id, referrers.name").
+		Order("referred DESC, referrers.id").
+		Limit(limit).
+		Scan(&referrers).Error
+	if err != nil {
+		return nil, err
+	}
+	return referrers, nil
+}
@@ -7,8 +7,8 @@ import (
 )
 
 // SubscriptionManager handles channel subscriptions and notifications
-type SubscriptionManager struct {
-	subs     map[int]chan *Wish
+type SubscriptionManager[T any] struct {
+	subs     map[int]chan T
 	subMutex sync.RWMutex
 	nextID   int
 	log      *zap.SugaredLogger
@@ -16,23 +16,23 @@ type SubscriptionManager struct {
 }
 
 // NewSubscriptionManager creates a new subscription manager
-func NewSubscriptionManager(name string, log *zap.SugaredLogger) *SubscriptionManager {
-	return &SubscriptionManager{
-		subs: make(map[int]chan *Wish),
+func NewSubscriptionManager[T any](name string, log *zap.SugaredLogger) *SubscriptionManager[T] {
+	return &SubscriptionManager[T]{
+		subs: make(map[int]chan T),
 		log:  log,
 		name: name,
 	}
 }
 
 // Subscribe returns a channel for notifications and an unsubscribe function
-func (sm *SubscriptionManager) Subscribe(bufSize int) (<-chan *Wish, func()) {
+func (sm *SubscriptionManager[T]) Subscribe(bufSize int) (<-chan T, func()) {
 	sm.subMutex.Lock()
 	defer sm.subMutex.Unlock()
 
 	id := sm.nextID
 	sm.nextID++
 
-	ch := make(chan *Wish, bufSize)
+	ch := make(chan T, bufSize)
 	sm.subs[id] = ch
 
 	unsubscribe := func() {
@@ -48,24 +48,23 @@ func (sm *SubscriptionManager) Subscribe(bufSize int) (<-chan *Wish, func()) {
 	return ch, unsubscribe
 }
 
-// Notify sends a wish to all subscribers
-func (sm *SubscriptionManager) Notify(wish *Wish) {
+// Notify sends an item to all subscribers
+func (sm *SubscriptionManager[T]) Notify(item T) {
 	sm.subMutex.RLock()
 	defer sm.subMutex.RUnlock()
 
 	for id, ch := range sm.subs {
 		select {
-		case ch <- wish:
-			sm.log.Debugf("Notified %s subscriber %d about wish %d", sm.name, id, wish.ID)
+		case ch <- item:
+			sm.log.Debugf("Notified %s subscriber %d", sm.name, id)
 		default:
-			sm.log.Warnf("%s subscriber %d's channel is full, skipping notification for wish %d",
-				sm.name, id, wish.ID)
+			sm.log.Warnf("%s subscriber %d's channel is full, skipping notification", sm.name, id)
 		}
 	}
 }
 
 // Close closes all subscription channels
-func (sm *SubscriptionManager) Close() {
+func (sm *SubscriptionManager[T]) Close() {
 	sm.subMutex.Lock()
 	defer sm.subMutex.Unlock()
 
@@ -0,0 +1,262 @@
+package wakey
+
+import (
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultDigestHour is the local hour the digest is sent at when notifications are disabled
+const defaultDigestHour = 12
+
+// weekdayNames are the catalog keys of the weekday names used after "on"
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "weekday.monday",
+	time.Tuesday:   "weekday.tuesday",
+	time.Wednesday: "weekday.wednesday",
+	time.Thursday:  "weekday.thursday",
+	time.Friday:    "weekday.friday",
+	time.Saturday:  "weekday.saturday",
+	time.Sunday:    "weekday.sunday",
+}
+
+var weekdayOrder = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday,
+	time.Friday, time.Saturday, time.Sunday,
+}
+
+type DigestHandler struct {
+	api         BotAPI
+	db          *DB
+	stateMan    *StateManager
+	digestSched Scheduler
+	callbacks   *CallbackCodec
+	log         *zap.SugaredLogger
+}
+
+func NewDigestHandler(db *DB, api BotAPI, digestSched Scheduler, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *DigestHandler {
+	dh := &DigestHandler{
+		api:         api,
+		db:          db,
+		stateMan:    stateMan,
+		digestSched: digestSched,
+		callbacks:   callbacks,
+		log:         log,
+	}
+
+	digestSched.SetJobFunc(dh.SendDigest)
+	dh.ScheduleAllDigests()
+
+	// Subscribe to user reachability updates
+	userCh, _ := db.SubscribeToReachability(100)
+	go dh.monitorReachability(userCh)
+
+	return dh
+}
+
+func (dh *DigestHandler) Actions() []string {
+	return []string{btnDigestSettingsID, btnDigestDayID, btnDigestOffID}
+}
+
+func (dh *DigestHandler) HandleAction(c tele.Context, action string) error {
+	switch action {
+	case btnDigestSettingsID:
+		return dh.HandleDigestSettings(c)
+	case btnDigestDayID:
+		return dh.HandleDigestDay(c)
+	case btnDigestOffID:
+		return dh.HandleDigestOff(c)
+	default:
+		dh.log.Errorw("unexpected action for DigestHandler", "action", action)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+func (dh *DigestHandler) States() []UserState {
+	return []UserState{}
+}
+
+func (dh *DigestHandler) HandleState(c tele.Context, state UserState) error {
+	dh.log.Errorw("unexpected state for DigestHandler", "state", state)
+	return c.Send(tr(c, "common.unknown_action"))
+}
+
+// NextDigestTime returns the next time the weekly digest should be sent to the user.
+// The digest is sent at the local notification time on the chosen weekday.
+func NextDigestTime(user *User, now time.Time) time.Time {
+	userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
+	hour, minute := defaultDigestHour, 0
+	if !user.NotifyAt.IsZero() {
+		notifyAt := user.NotifyAt.In(userLoc)
+		hour, minute = notifyAt.Hour(), notifyAt.Minute()
+	}
+
+	local := now.In(userLoc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, userLoc)
+	next = next.AddDate(0, 0, (int(user.DigestDay)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+
+	return next.UTC()
+}
+
+func (dh *DigestHandler) scheduleDigest(user *User) {
+	if !user.DigestEnabled || user.IsBanned || user.IsUnreachable {
+		dh.digestSched.Cancel(JobID(user.ID))
+		return
+	}
+
+	nextDigest := NextDigestTime(user, time.Now().UTC())
+	dh.digestSched.Schedule(nextDigest, JobID(user.ID))
+	dh.log.Infow("scheduled digest", "userID", user.ID, "digestAt", nextDigest)
+}
+
+func (dh *DigestHandler) ScheduleAllDigests() {
+	users, err := dh.db.GetAllUsers()
+	if err != nil {
+		dh.log.Errorw("failed to get all users", "error", err)
+		return
+	}
+
+	cnt := 0
+	for _, user := range users {
+		if user.DigestEnabled && !user.IsBanned && !user.IsUnreachable {
+			dh.scheduleDigest(user)
+			cnt++
+		}
+	}
+
+	dh.log.Infow("Scheduled digests", "users", cnt)
+}
+
+func (dh *DigestHandler) monitorReachability(ch <-chan *User) {
+	for user := range ch {
+		dh.scheduleDigest(user)
+	}
+}
+
+func (dh *DigestHandler) HandleDigestSettings(c tele.Context) error {
+	userID := c.Sender().ID
+
+	user, err := dh.db.GetUserByID(userID)
+	if err != nil {
+		dh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	message := tr(c, "digest.about") + "\n\n"
+	if user.DigestEnabled {
+		message += tr(c, "digest.current_day", tr(c, weekdayNames[user.DigestDay])) + " "
+	}
+	message += tr(c, "digest.choose_day")
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(weekdayOrder)+1)
+	for _, day := range weekdayOrder {
+		btnDay := dh.callbacks.senderBtn(c, tr(c, weekdayNames[day]), btnDigestDayID, strconv.Itoa(int(day)))
+		rows = append(rows, inlineKeyboard.Row(btnDay))
+	}
+	if user.DigestEnabled {
+		rows = append(rows, inlineKeyboard.Row(dh.callbacks.senderBtn(c, tr(c, btnDigestOffText), btnDigestOffID)))
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return c.Send(message, inlineKeyboard)
+}
+
+func (dh *DigestHandler) HandleDigestDay(c tele.Context) error {
+	userID := c.Sender().ID
+
+	day, err := getButtonID(c, "digest.invalid_day")
+	if err != nil || day > uint64(time.Saturday) {
+		return c.Send(tr(c, "digest.invalid_day"))
+	}
+
+	user, err := dh.db.GetUserByID(userID)
+	if err != nil {
+		dh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	user.DigestEnabled = true
+	user.DigestDay = time.Weekday(day)
+	if err := dh.db.SaveUser(user); err != nil {
+		dh.log.Errorw("failed to save user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.save_error"))
+	}
+	dh.scheduleDigest(user)
+
+	showMenu(c)
+	return c.Edit(tr(c, "digest.enabled", tr(c, weekdayNames[user.DigestDay])))
+}
+
+func (dh *DigestHandler) HandleDigestOff(c tele.Context) error {
+	userID := c.Sender().ID
+
+	user, err := dh.db.GetUserByID(userID)
+	if err != nil {
+		dh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	user.DigestEnabled = false
+	if err := dh.db.SaveUser(user); err != nil {
+		dh.log.Errorw("failed to save user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.save_error"))
+	}
+	dh.scheduleDigest(user)
+
+	showMenu(c)
+	return c.Edit(tr(c, "digest.disabled"))
+}
+
+// SendDigest sends the weekly digest to the user and schedules the next one
+func (dh *DigestHandler) SendDigest(id JobID) {
+	userID := int64(id)
+
+	user, err := dh.db.GetUserByID(userID)
+	if err != nil {
+		dh.log.Errorw("failed to get user", "error", err, "userID", userID)
+		return
+	}
+	if !user.DigestEnabled || user.IsBanned || user.IsUnreachable {
+		return
+	}
+	defer dh.scheduleDigest(user)
+
+	digest, err := dh.db.GetSenderDigest(userID, time.Now().UTC().AddDate(0, 0, -7))
+	if err != nil {
+		dh.log.Errorw("failed to get sender digest", "error", err, "userID", userID)
+		return
+	}
+
+	_, err = dh.api.Send(tele.ChatID(userID), formatDigest(user.Lang, digest))
+	if err != nil {
+		dh.log.Errorw("failed to send digest", "error", err, "userID", userID)
+		if markIfUnreachable(dh.db, dh.log, userID, err) {
+			user.IsUnreachable = true
+		}
+	}
+}
+
+func formatDigest(lang string, digest *SenderDigest) string {
+	message := T(lang, "digest.title") + "\n\n"
+	if digest.Sent == 0 {
+		message += T(lang, "digest.nothing_sent") + "\n"
+	} else {
+		message += TN(lang, "digest.sent", digest.Sent, digest.Sent) + "\n" +
+			T(lang, "digest.stats", digest.Delivered, digest.Read, digest.Liked) + "\n"
+	}
+
+	if digest.SupportedUsers > 0 {
+		message += "\n" + TN(lang, "digest.supported", digest.SupportedUsers, digest.SupportedUsers)
+	}
+	if digest.WeekStreak > 1 {
+		message += "\n" + TN(lang, "digest.streak", int64(digest.WeekStreak), digest.WeekStreak)
+	}
+
+	return message
+}
@@ -0,0 +1,246 @@
+package wakey
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+type FlowID string
+
+// FlowStep is one input of a conversation flow
+type FlowStep struct {
+	// State is the user state while the step waits for the input
+	State UserState
+	// Prompt is the message key asking for the input
+	Prompt string
+	// PromptArgs returns the arguments of the prompt message
+	PromptArgs func(c tele.Context, data *UserData) []any
+	// Ask sends a custom prompt instead of the Prompt message
+	Ask func(c tele.Context, data *UserData) error
+	// Media allows answering with voice, photo, sticker and video note messages
+	Media bool
+	// Validate checks the input. The returned error is a message for the user,
+	// who stays on the step to try again.
+	Validate func(c tele.Context) error
+	// Accept stores the input in the user data. Like Validate, it returns
+	// errors as messages for the user.
+	Accept func(c tele.Context, data *UserData) error
+}
+
+// Flow is a declarative multi-step conversation. Every step waits in its own
+// user state, so a state belongs to exactly one flow.
+type Flow struct {
+	ID    FlowID
+	Steps []FlowStep
+	// Back shows a button returning to the previous step
+	Back bool
+	// OnComplete is called after the last step has accepted its input.
+	// The user has no state at this point, so it can start another flow.
+	OnComplete func(c tele.Context, data *UserData) error
+	// OnCancel is called when the user cancels the flow with /cancel
+	// instead of the default reply
+	OnCancel func(c tele.Context, data *UserData) error
+}
+
+type flowStepRef struct {
+	flow  *Flow
+	index int
+}
+
+// FlowRunner runs the registered flows on top of the state manager.
+// It is a BotHandler for the states of all flow steps.
+type FlowRunner struct {
+	stateMan  *StateManager
+	flows     map[FlowID]*Flow
+	steps     map[UserState]flowStepRef
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
+}
+
+func NewFlowRunner(stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *FlowRunner {
+	return &FlowRunner{
+		stateMan:  stateMan,
+		flows:     make(map[FlowID]*Flow),
+		steps:     make(map[UserState]flowStepRef),
+		callbacks: callbacks,
+		log:       log,
+	}
+}
+
+// Register adds the flows to the runner. It panics if a flow or a state is
+// registered twice as that is a programming error.
+func (fr *FlowRunner) Register(flows ...*Flow) {
+	for _, flow := range flows {
+		if _, exists := fr.flows[flow.ID]; exists {
+			panic(fmt.Sprintf("flow %s is already registered", flow.ID))
+		}
+		if len(flow.Steps) == 0 {
+			panic(fmt.Sprintf("flow %s has no steps", flow.ID))
+		}
+
+		for i, step := range flow.Steps {
+			if ref, exists := fr.steps[step.State]; exists {
+				panic(fmt.Sprintf("state %d of flow %s is already used by flow %s", step.State, flow.ID, ref.flow.ID))
+			}
+			fr.steps[step.State] = flowStepRef{flow: flow, index: i}
+		}
+		fr.flows[flow.ID] = flow
+	}
+}
+
+// Start puts the user on the first step of the flow. The flow continues
+// with the current user data if data is nil.
+func (fr *FlowRunner) Start(c tele.Context, id FlowID, data *UserData) error {
+	flow, exists := fr.flows[id]
+	if !exists {
+		fr.log.Errorw("unknown flow", "flow", id)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if data == nil {
+		data = fr.userData(c.Sender().ID)
+	}
+
+	return fr.enterStep(c, flow, 0, data)
+}
+
+// Cancel stops the flow the user is in. Nothing is sent if the user isn't in a flow.
+func (fr *FlowRunner) Cancel(c tele.Context) error {
+	userID := c.Sender().ID
+
+	data, exists := fr.stateMan.GetUserData(userID)
+	if !exists {
+		return nil
+	}
+	ref, inFlow := fr.steps[data.State]
+	if !inFlow {
+		return nil
+	}
+
+	fr.stateMan.ClearState(userID)
+	if ref.flow.OnCancel != nil {
+		return ref.flow.OnCancel(c, data)
+	}
+
+	return c.Send(tr(c, "general.cancelled"))
+}
+
+// Back returns the user to the previous step of the flow
+func (fr *FlowRunner) Back(c tele.Context) error {
+	userID := c.Sender().ID
+
+	state, err := getButtonID(c, "common.invalid_data")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	data, exists := fr.stateMan.GetUserData(userID)
+	if !exists || data.State != UserState(state) {
+		return c.Send(tr(c, "flow.back_outdated"))
+	}
+
+	ref, inFlow := fr.steps[data.State]
+	if !inFlow || !ref.flow.Back || ref.index == 0 {
+		return c.Send(tr(c, "flow.back_outdated"))
+	}
+
+	return fr.enterStep(c, ref.flow, ref.index-1, data)
+}
+
+func (fr *FlowRunner) Actions() []string {
+	return []string{btnFlowBackID}
+}
+
+func (fr *FlowRunner) HandleAction(c tele.Context, action string) error {
+	switch action {
+	case btnFlowBackID:
+		return fr.Back(c)
+	default:
+		fr.log.Errorw("unexpected action for FlowRunner", "action", action)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+func (fr *FlowRunner) States() []UserState {
+	states := make([]UserState, 0, len(fr.steps))
+	for state := range fr.steps {
+		states = append(states, state)
+	}
+	return states
+}
+
+// HandleState passes the user's message to the current step
+func (fr *FlowRunner) HandleState(c tele.Context, state UserState) error {
+	ref, exists := fr.steps[state]
+	if !exists {
+		fr.log.Errorw("unexpected state for FlowRunner", "state", state)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+	step := ref.flow.Steps[ref.index]
+
+	if mediaType, _ := messageMedia(c.Message()); mediaType != MediaNone && !step.Media {
+		return c.Send(tr(c, "bot.text_only"))
+	}
+
+	if step.Validate != nil {
+		if err := step.Validate(c); err != nil {
+			return c.Send(err.Error())
+		}
+	}
+
+	data := fr.userData(c.Sender().ID)
+	if step.Accept != nil {
+		if err := step.Accept(c, data); err != nil {
+			return c.Send(err.Error())
+		}
+	}
+
+	if ref.index+1 < len(ref.flow.Steps) {
+		return fr.enterStep(c, ref.flow, ref.index+1, data)
+	}
+
+	data.State = StateNone
+	fr.stateMan.SetUserData(c.Sender().ID, data)
+	if ref.flow.OnComplete == nil {
+		return nil
+	}
+
+	return ref.flow.OnComplete(c, data)
+}
+
+func (fr *FlowRunner) userData(userID int64) *UserData {
+	data, exists := fr.stateMan.GetUserData(userID)
+	if !exists {
+		return &UserData{}
+	}
+	return data
+}
+
+func (fr *FlowRunner) enterStep(c tele.Context, flow *Flow, index int, data *UserData) error {
+	step := flow.Steps[index]
+	data.State = step.State
+	fr.stateMan.SetUserData(c.Sender().ID, data)
+
+	if step.Ask != nil {
+		return step.Ask(c, data)
+	}
+
+	var args []any
+	if step.PromptArgs != nil {
+		args = step.PromptArgs(c, data)
+	}
+	prompt := tr(c, step.Prompt, args...)
+
+	if !flow.Back || index == 0 {
+		return c.Send(prompt)
+	}
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnBack := fr.callbacks.senderBtn(c, tr(c, btnFlowBackText), btnFlowBackID, strconv.Itoa(int(step.State)))
+	inlineKeyboard.Inline(inlineKeyboard.Row(btnBack))
+
+	return c.Send(prompt, inlineKeyboard)
+}
@@ -1,7 +1,6 @@
 package wakey
 
 import (
-	"fmt"
 	"net/url"
 
 	"go.uber.org/zap"
@@ -9,18 +8,22 @@ import (
 )
 
 type GeneralHandler struct {
-	db       *DB
-	stateMan *StateManager
-	log      *zap.SugaredLogger
-	name     string
+	db        *DB
+	flows     *FlowRunner
+	stateMan  *StateManager
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
+	name      string
 }
 
-func NewGeneralHandler(db *DB, stateMan *StateManager, log *zap.SugaredLogger, botName string) *GeneralHandler {
+func NewGeneralHandler(db *DB, flows *FlowRunner, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger, botName string) *GeneralHandler {
 	return &GeneralHandler{
-		db:       db,
-		stateMan: stateMan,
-		log:      log,
-		name:     botName,
+		db:        db,
+		flows:     flows,
+		stateMan:  stateMan,
+		callbacks: callbacks,
+		log:       log,
+		name:      botName,
 	}
 }
 
@@ -29,34 +32,47 @@ func (gh *GeneralHandler) Actions() []string {
 }
 
 func (gh *GeneralHandler) HandleAction(c tele.Context, action string) error {
-	inviteLink := "https://t.me/" + gh.name
 	switch action {
-	case btnInviteFriendsID:
-		message := "Пригласите друзей присоединиться к нашему боту! Выберите способ:"
-
-		inlineKeyboard := &tele.ReplyMarkup{}
-		btnShowLink := inlineKeyboard.Data(btnShowLinkText, btnShowLinkID)
-		btnShareLink := inlineKeyboard.URL(btnShareLinkText, createShareLink(inviteLink))
-
-		inlineKeyboard.Inline(
-			inlineKeyboard.Row(btnShowLink),
-			inlineKeyboard.Row(btnShareLink),
-		)
-
-		return c.Send(message, inlineKeyboard)
-	case btnShowLinkID:
-		message := fmt.Sprintf("Вот ссылка для приглашения друзей:\n\n%s\n\nПросто скопируйте и отправьте её вашим друзьям!", inviteLink)
-		return c.Send(message)
+	case btnInviteFriendsID, btnShowLinkID:
+		return gh.shareInviteLink(c, action)
 	case btnDoNothingID:
-		return c.Send("Хорошо, до свидания! Если вам что-то понадобится, просто напишите мне.")
+		return c.Send(tr(c, "general.goodbye"))
 	default:
 		gh.log.Errorw("unexpected action for GeneralHandler", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
+// shareInviteLink offers the ways to share the user's invite link or shows the link itself
+func (gh *GeneralHandler) shareInviteLink(c tele.Context, action string) error {
+	user, err := gh.db.GetUserByID(c.Sender().ID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Send(tr(c, "common.not_registered"))
+		}
+		gh.log.Errorw("failed to get user", "error", err, "user_id", c.Sender().ID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	inviteLink := referralLink(gh.name, user.ReferralCode.String)
+	if action == btnShowLinkID {
+		return c.Send(tr(c, "general.invite_link", inviteLink))
+	}
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnShowLink := gh.callbacks.senderBtn(c, tr(c, btnShowLinkText), btnShowLinkID)
+	btnShareLink := inlineKeyboard.URL(tr(c, btnShareLinkText), createShareLink(c, inviteLink))
+
+	inlineKeyboard.Inline(
+		inlineKeyboard.Row(btnShowLink),
+		inlineKeyboard.Row(btnShareLink),
+	)
+
+	return c.Send(tr(c, "general.invite"), inlineKeyboard)
+}
+
 func (gh *GeneralHandler) States() []UserState {
-	return []UserState{StateSuggestActions, StateCancelAction, StatePrintStats}
+	return []UserState{StateSuggestActions, StateCancelAction}
 }
 
 func (gh *GeneralHandler) HandleState(c tele.Context, state UserState) error {
@@ -65,24 +81,14 @@ func (gh *GeneralHandler) HandleState(c tele.Context, state UserState) error {
 		return gh.suggestActions(c)
 	case StateCancelAction:
 		return gh.cancelAction(c)
-	case StatePrintStats:
-		return gh.printStats(c)
 	default:
 		gh.log.Errorw("unexpected state for GeneralHandler", "state", state)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
-func createShareLink(botLink string) string {
-	sellingText := `Присоединяйтесь к нашему боту — повысьте свою осознанность, получайте поддержку и вдохновение каждый день!
-
-Что умеет наш бот:
-• Напоминает останавливаться и анализировать свое состояние
-• Позволяет обмениваться вдохновляющими пожеланиями с другими пользователями
-• Доставляет поддерживающие сообщения к моменту вашего пробуждения
-`
-
-	encodedText := url.QueryEscape(sellingText + "\n\n" + botLink)
+func createShareLink(c tele.Context, botLink string) string {
+	encodedText := url.QueryEscape(tr(c, "general.share_text") + "\n\n" + botLink)
 	return "https://t.me/share/url?url=" + encodedText
 }
 
@@ -93,87 +99,57 @@ func (gh *GeneralHandler) suggestActions(c tele.Context) error {
 	_, err := gh.db.GetUserByID(userID)
 	if err != nil {
 		if err == ErrNotFound {
-			return c.Send("Похоже, вы еще не зарегистрированы. Пожалуйста, используйте команду /start чтобы начать процесс регистрации.")
+			return c.Send(tr(c, "common.not_registered"))
 		}
 		gh.log.Errorw("failed to get user", "error", err, "userID", userID)
-		return c.Send("Произошла ошибка при проверке вашего профиля. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "general.profile_check_error"))
 	}
 
 	inlineKeyboard := &tele.ReplyMarkup{}
 
-	btnShowProfile := inlineKeyboard.Data(btnShowProfileText, btnShowProfileID)
-	btnChangeName := inlineKeyboard.Data(btnChangeNameText, btnChangeNameID)
-	btnChangeBio := inlineKeyboard.Data(btnChangeBioText, btnChangeBioID)
-	btnChangeTimezone := inlineKeyboard.Data(btnChangeTimezoneText, btnChangeTimezoneID)
-	btnChangePlans := inlineKeyboard.Data(btnChangePlansText, btnChangePlansID)
-	btnChangeWakeTime := inlineKeyboard.Data(btnChangeWakeTimeText, btnChangeWakeTimeID)
-	btnChangeNotifyTime := inlineKeyboard.Data(btnChangeNotifyTimeText, btnChangeNotifyTimeID)
-	btnSendWish := inlineKeyboard.Data(btnSendWishYesText, btnSendWishYesID)
-	btnInviteFriends := inlineKeyboard.Data(btnInviteFriendsText, btnInviteFriendsID)
-	btnDoNothing := inlineKeyboard.Data(btnDoNothingText, btnDoNothingID)
+	btnShowProfile := gh.callbacks.senderBtn(c, tr(c, btnShowProfileText), btnShowProfileID)
+	btnShowJournal := gh.callbacks.senderBtn(c, tr(c, btnShowJournalText), btnShowJournalID)
+	btnShowMoodTrend := gh.callbacks.senderBtn(c, tr(c, btnShowMoodTrendText), btnShowMoodTrendID)
+	btnChangeName := gh.callbacks.senderBtn(c, tr(c, btnChangeNameText), btnChangeNameID)
+	btnChangeBio := gh.callbacks.senderBtn(c, tr(c, btnChangeBioText), btnChangeBioID)
+	btnChangeTimezone := gh.callbacks.senderBtn(c, tr(c, btnChangeTimezoneText), btnChangeTimezoneID)
+	btnChangePlans := gh.callbacks.senderBtn(c, tr(c, btnChangePlansText), btnChangePlansID)
+	btnChangeWakeTime := gh.callbacks.senderBtn(c, tr(c, btnChangeWakeTimeText), btnChangeWakeTimeID)
+	btnChangeNotifyTime := gh.callbacks.senderBtn(c, tr(c, btnChangeNotifyTimeText), btnChangeNotifyTimeID)
+	btnToggleRedirect := gh.callbacks.senderBtn(c, tr(c, btnToggleRedirectText), btnToggleRedirectID)
+	btnShowBlocks := gh.callbacks.senderBtn(c, tr(c, btnShowBlocksText), btnShowBlocksID)
+	btnDigestSettings := gh.callbacks.senderBtn(c, tr(c, btnDigestSettingsText), btnDigestSettingsID)
+	btnChangeLanguage := gh.callbacks.senderBtn(c, tr(c, btnChangeLanguageText), btnChangeLanguageID)
+	btnSendWish := gh.callbacks.senderBtn(c, tr(c, btnSendWishYesText), btnSendWishYesID)
+	btnInviteFriends := gh.callbacks.senderBtn(c, tr(c, btnInviteFriendsText), btnInviteFriendsID)
+	btnDoNothing := gh.callbacks.senderBtn(c, tr(c, btnDoNothingText), btnDoNothingID)
 
 	inlineKeyboard.Inline(
 		inlineKeyboard.Row(btnShowProfile),
+		inlineKeyboard.Row(btnShowJournal),
+		inlineKeyboard.Row(btnShowMoodTrend),
 		inlineKeyboard.Row(btnChangeName),
 		inlineKeyboard.Row(btnChangeBio),
 		inlineKeyboard.Row(btnChangeTimezone),
 		inlineKeyboard.Row(btnChangePlans),
 		inlineKeyboard.Row(btnChangeWakeTime),
 		inlineKeyboard.Row(btnChangeNotifyTime),
+		inlineKeyboard.Row(btnToggleRedirect),
+		inlineKeyboard.Row(btnShowBlocks),
+		inlineKeyboard.Row(btnDigestSettings),
+		inlineKeyboard.Row(btnChangeLanguage),
 		inlineKeyboard.Row(btnSendWish),
 		inlineKeyboard.Row(btnInviteFriends),
 		inlineKeyboard.Row(btnDoNothing),
 	)
 
-	return c.Send("Что бы вы хотели сделать?", inlineKeyboard)
+	return c.Send(tr(c, "general.menu"), inlineKeyboard)
 }
 
 func (gh *GeneralHandler) cancelAction(c tele.Context) error {
-	userID := c.Sender().ID
-	state, exists := gh.stateMan.GetState(userID)
-	if exists && state != StateNone {
-		err := c.Send("Действие отменено.")
-		if err != nil {
-			return err
-		}
+	if err := gh.flows.Cancel(c); err != nil {
+		return err
 	}
 
 	return gh.suggestActions(c)
 }
-
-func (gh *GeneralHandler) printStats(c tele.Context) error {
-	stats, err := gh.db.GetStats()
-	if err != nil {
-		gh.log.Errorw("failed to get stats", "error", err)
-		return c.Send("Извините, не удалось получить статистику. Пожалуйста, попробуйте позже.")
-	}
-
-	message := fmt.Sprintf(`📊 *Статистика бота*
-
-*Общая статистика:*
-• Всего пользователей: %d
-• Всего статусов: %d
-• Всего сообщений: %d
-• Понравившихся сообщений: %d (%.2f%%)
-
-*За последние 7 дней:*
-• Новых пользователей: %d
-• Активных пользователей: %d
-• Среднее число статусов в день: %.2f
-• Среднее число сообщений в день: %.2f
-• Понравившихся сообщений: %d (%.2f%%)`,
-		stats.TotalUsers,
-		stats.TotalPlans,
-		stats.TotalWishes,
-		stats.TotalLikedWishes,
-		stats.LikedWishesPercent,
-		stats.NewUsersLast7Days,
-		stats.ActiveUsersLast7Days,
-		stats.AvgPlansLast7Days,
-		stats.AvgWishesLast7Days,
-		stats.LikedWishesLast7Days,
-		stats.LikedWishesLast7DaysPercent,
-	)
-
-	return c.Send(message, tele.ModeMarkdown)
-}
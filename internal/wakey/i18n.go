@@ -0,0 +1,259 @@
+package wakey
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/knadh/koanf/parsers/toml"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const DefaultLang = "ru"
+
+// langContextKey is the telebot context key of the user's language
+const langContextKey = "lang"
+
+//go:embed locales/*.toml
+var localeFiles embed.FS
+
+// message is a catalog entry. Plural messages have forms keyed by
+// the CLDR plural category (one, few, many, other).
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+type catalog map[string]message
+
+// pluralRules returns the plural category of n for every supported language
+var pluralRules = map[string]func(n int64) string{
+	"ru": func(n int64) string {
+		n = max(n, -n)
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	},
+	"en": func(n int64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+var pluralCategories = []string{"zero", "one", "two", "few", "many", "other"}
+
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[string]catalog {
+	catalogs, err := loadCatalogs()
+	if err != nil {
+		panic(err)
+	}
+	return catalogs
+}
+
+func loadCatalogs() (map[string]catalog, error) {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	catalogs := make(map[string]catalog)
+	for _, file := range files {
+		lang := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+		if _, ok := pluralRules[lang]; !ok {
+			return nil, fmt.Errorf("no plural rules for locale %s", lang)
+		}
+
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err := toml.Parser().Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse locale %s: %w", lang, err)
+		}
+
+		cat := make(catalog)
+		if err := flattenMessages(cat, "", tree); err != nil {
+			return nil, fmt.Errorf("invalid locale %s: %w", lang, err)
+		}
+		catalogs[lang] = cat
+	}
+
+	return catalogs, nil
+}
+
+func flattenMessages(cat catalog, prefix string, tree map[string]interface{}) error {
+	for name, value := range tree {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		switch value := value.(type) {
+		case string:
+			cat[key] = message{text: value}
+		case map[string]interface{}:
+			if forms, ok := pluralForms(value); ok {
+				cat[key] = message{forms: forms}
+				continue
+			}
+			if err := flattenMessages(cat, key, value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected value of %s: %T", key, value)
+		}
+	}
+	return nil
+}
+
+// pluralForms returns the forms if all keys of the table are plural categories
+func pluralForms(table map[string]interface{}) (map[string]string, bool) {
+	forms := make(map[string]string, len(table))
+	for category, value := range table {
+		text, ok := value.(string)
+		if !ok || !slices.Contains(pluralCategories, category) {
+			return nil, false
+		}
+		forms[category] = text
+	}
+	return forms, true
+}
+
+// Languages returns the codes of the supported languages
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// SupportedLang maps a Telegram language code like "en-US" to a supported language
+func SupportedLang(code string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(code), "-")
+	if _, ok := catalogs[lang]; ok {
+		return lang
+	}
+	return DefaultLang
+}
+
+// MessageKeys returns the sorted keys of the language catalog
+func MessageKeys(lang string) []string {
+	keys := make([]string, 0, len(catalogs[lang]))
+	for key := range catalogs[lang] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PluralForms returns the plural categories of the message
+// or nil if the message is not a plural one
+func PluralForms(lang, key string) []string {
+	forms := make([]string, 0, len(catalogs[lang][key].forms))
+	for category := range catalogs[lang][key].forms {
+		forms = append(forms, category)
+	}
+	sort.Strings(forms)
+	return forms
+}
+
+// PluralCategory returns the plural category of n in the language
+func PluralCategory(lang string, n int64) string {
+	rule, ok := pluralRules[lang]
+	if !ok {
+		rule = pluralRules[DefaultLang]
+	}
+	return rule(n)
+}
+
+func lookupMessage(lang, key string) (message, bool) {
+	if _, ok := catalogs[lang]; !ok {
+		lang = DefaultLang
+	}
+	if msg, ok := catalogs[lang][key]; ok {
+		return msg, true
+	}
+	if msg, ok := catalogs[DefaultLang][key]; ok {
+		zap.S().Warnw("missing translation", "lang", lang, "key", key)
+		return msg, true
+	}
+	zap.S().Errorw("unknown message key", "key", key)
+	return message{}, false
+}
+
+// T returns the message formatted with fmt.Sprintf
+func T(lang, key string, args ...any) string {
+	msg, ok := lookupMessage(lang, key)
+	if !ok {
+		return key
+	}
+	if msg.forms != nil {
+		return TN(lang, key, 1, args...)
+	}
+	if len(args) == 0 {
+		return msg.text
+	}
+	return fmt.Sprintf(msg.text, args...)
+}
+
+// TN returns the plural form of the message for n formatted with fmt.Sprintf
+func TN(lang, key string, n int64, args ...any) string {
+	msg, ok := lookupMessage(lang, key)
+	if !ok {
+		return key
+	}
+	if msg.forms == nil {
+		return T(lang, key, args...)
+	}
+
+	// The plural rules must match the language the message was found in
+	if _, ok := catalogs[lang][key]; !ok {
+		lang = DefaultLang
+	}
+	text, ok := msg.forms[PluralCategory(lang, n)]
+	if !ok {
+		text = msg.forms["other"]
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// ctxLang returns the language of the user set by the bot middleware
+func ctxLang(c tele.Context) string {
+	if lang, ok := c.Get(langContextKey).(string); ok {
+		return lang
+	}
+	if c.Sender() != nil {
+		return SupportedLang(c.Sender().LanguageCode)
+	}
+	return DefaultLang
+}
+
+// tr translates the message into the language of the context user
+func tr(c tele.Context, key string, args ...any) string {
+	return T(ctxLang(c), key, args...)
+}
+
+// trn translates the plural message into the language of the context user
+func trn(c tele.Context, key string, n int64, args ...any) string {
+	return TN(ctxLang(c), key, n, args...)
+}
@@ -0,0 +1,196 @@
+package wakey
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	journalPageSize    = 5
+	journalSearchLimit = 10
+	journalEntryMaxLen = 300
+	journalMinQueryLen = 2
+)
+
+const flowJournalSearch FlowID = "journal_search"
+
+type JournalHandler struct {
+	db        *DB
+	flows     *FlowRunner
+	stateMan  *StateManager
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
+}
+
+func NewJournalHandler(db *DB, flows *FlowRunner, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *JournalHandler {
+	jh := &JournalHandler{
+		db:        db,
+		flows:     flows,
+		stateMan:  stateMan,
+		callbacks: callbacks,
+		log:       log,
+	}
+
+	flows.Register(&Flow{
+		ID:         flowJournalSearch,
+		Steps:      []FlowStep{{State: StateSearchingJournal, Prompt: "journal.search_prompt", Validate: validateJournalQuery}},
+		OnComplete: jh.completeJournalSearch,
+	})
+
+	return jh
+}
+
+func (jh *JournalHandler) Actions() []string {
+	return []string{btnShowJournalID, btnJournalPageID, btnJournalSearchID}
+}
+
+func (jh *JournalHandler) HandleAction(c tele.Context, action string) error {
+	switch action {
+	case btnShowJournalID:
+		return jh.HandleShowJournal(c)
+	case btnJournalPageID:
+		return jh.HandleJournalPage(c)
+	case btnJournalSearchID:
+		return jh.flows.Start(c, flowJournalSearch, nil)
+	default:
+		jh.log.Errorw("unexpected action for JournalHandler", "action", action)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+func (jh *JournalHandler) States() []UserState {
+	return []UserState{}
+}
+
+func (jh *JournalHandler) HandleState(c tele.Context, state UserState) error {
+	jh.log.Errorw("unexpected state for JournalHandler", "state", state)
+	return c.Send(tr(c, "common.unknown_action"))
+}
+
+func (jh *JournalHandler) HandleShowJournal(c tele.Context) error {
+	text, markup, err := jh.journalPage(c, 0)
+	if err != nil {
+		return c.Send(tr(c, "journal.load_error"))
+	}
+
+	return c.Send(text, markup)
+}
+
+func (jh *JournalHandler) HandleJournalPage(c tele.Context) error {
+	page, err := getButtonID(c, "journal.invalid_page")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	text, markup, err := jh.journalPage(c, int(page))
+	if err != nil {
+		return c.Send(tr(c, "journal.load_error"))
+	}
+
+	if err := c.Edit(text, markup); err != nil {
+		jh.log.Warnw("failed to update journal message", "err", err)
+	}
+
+	return c.Respond()
+}
+
+func (jh *JournalHandler) journalPage(c tele.Context, page int) (string, *tele.ReplyMarkup, error) {
+	userID := c.Sender().ID
+	user, err := jh.db.GetUserByID(userID)
+	if err != nil {
+		jh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return "", nil, err
+	}
+	userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
+
+	plans, total, err := jh.db.GetPlansPage(userID, page*journalPageSize, journalPageSize)
+	if err != nil {
+		jh.log.Errorw("failed to load plans", "error", err, "userID", userID)
+		return "", nil, err
+	}
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	if total == 0 {
+		return tr(c, "journal.empty"), inlineKeyboard, nil
+	}
+
+	current, longest, err := jh.db.GetPlanStreaks(userID, userLoc, time.Now())
+	if err != nil {
+		jh.log.Errorw("failed to get plan streaks", "error", err, "userID", userID)
+		return "", nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(c, "journal.title") + "\n\n")
+	sb.WriteString(trn(c, "journal.current_streak", int64(current), current) + "\n")
+	sb.WriteString(trn(c, "journal.longest_streak", int64(longest), longest) + "\n\n")
+	writeJournalEntries(&sb, plans, userLoc)
+
+	pages := int((total + journalPageSize - 1) / journalPageSize)
+	sb.WriteString(tr(c, "journal.page", page+1, pages))
+
+	var nav tele.Row
+	if page > 0 {
+		nav = append(nav, jh.callbacks.senderBtn(c, tr(c, btnJournalNewerText), btnJournalPageID, strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		nav = append(nav, jh.callbacks.senderBtn(c, tr(c, btnJournalOlderText), btnJournalPageID, strconv.Itoa(page+1)))
+	}
+	rows := []tele.Row{inlineKeyboard.Row(jh.callbacks.senderBtn(c, tr(c, btnJournalSearchText), btnJournalSearchID))}
+	if len(nav) > 0 {
+		rows = append([]tele.Row{nav}, rows...)
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return sb.String(), inlineKeyboard, nil
+}
+
+func writeJournalEntries(sb *strings.Builder, plans []Plan, loc *time.Location) {
+	for _, plan := range plans {
+		fmt.Fprintf(sb, "📅 %s\n%s\n\n", plan.CreatedAt.In(loc).Format("02.01.2006"), truncateText(plan.Content, journalEntryMaxLen))
+	}
+}
+
+func validateJournalQuery(c tele.Context) error {
+	if len([]rune(strings.TrimSpace(c.Text()))) < journalMinQueryLen {
+		return errors.New(tr(c, "journal.query_too_short"))
+	}
+	return nil
+}
+
+func (jh *JournalHandler) completeJournalSearch(c tele.Context, data *UserData) error {
+	userID := c.Sender().ID
+	query := strings.TrimSpace(c.Text())
+
+	user, err := jh.db.GetUserByID(userID)
+	if err != nil {
+		jh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	plans, err := jh.db.SearchPlans(userID, query, journalSearchLimit)
+	if err != nil {
+		jh.log.Errorw("failed to search plans", "error", err, "userID", userID)
+		return c.Send(tr(c, "journal.search_error"))
+	}
+
+	jh.stateMan.ClearState(userID)
+	if len(plans) == 0 {
+		return c.Send(tr(c, "journal.nothing_found", query))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(c, "journal.search_results", query) + "\n\n")
+	writeJournalEntries(&sb, plans, time.FixedZone("User Timezone", int(user.Tz)*60))
+	if len(plans) == journalSearchLimit {
+		sb.WriteString(tr(c, "journal.search_truncated"))
+	}
+
+	return c.Send(strings.TrimSpace(sb.String()))
+}
@@ -0,0 +1,103 @@
+package wakey
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type MatchStrategyName string
+
+const (
+	MatchStrategyFair   MatchStrategyName = "fair"
+	MatchStrategyRandom MatchStrategyName = "random"
+)
+
+// MatchCandidate is a plan that can receive a wish from the sender
+type MatchCandidate struct {
+	Plan
+	// PlanWishes is the number of wishes already attached to the plan
+	PlanWishes int64
+	// RecentWishes is the number of wishes the plan owner has received recently
+	RecentWishes int64
+	// PairWishes is the number of wishes the sender has ever sent to the plan owner
+	PairWishes int64
+}
+
+// MatchStrategy chooses a plan for a wish among the candidates.
+// It returns the index of the chosen candidate.
+type MatchStrategy interface {
+	Choose(candidates []MatchCandidate, now time.Time) (int, bool)
+}
+
+func NewMatchStrategy(cfg MatchingConfig) (MatchStrategy, error) {
+	switch cfg.Strategy {
+	case MatchStrategyFair, "":
+		return NewFairMatchStrategy(), nil
+	case MatchStrategyRandom:
+		return &RandomMatchStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported match strategy: %s", cfg.Strategy)
+	}
+}
+
+// FairMatchStrategy prefers recipients who have received fewer wishes recently,
+// plans that wake up sooner and senders who haven't written to the recipient before.
+// The candidate with the lowest penalty wins.
+type FairMatchStrategy struct {
+	RecentWeight float64 // penalty per wish received by the owner recently
+	PairWeight   float64 // penalty per wish sent by the sender to the owner before
+	PlanWeight   float64 // penalty per wish already attached to the plan
+	WakeWeight   float64 // penalty per hour until the plan wakes up
+}
+
+func NewFairMatchStrategy() *FairMatchStrategy {
+	return &FairMatchStrategy{
+		RecentWeight: 1,
+		PairWeight:   3,
+		PlanWeight:   2,
+		WakeWeight:   1.0 / 24,
+	}
+}
+
+func (s *FairMatchStrategy) penalty(c *MatchCandidate, now time.Time) float64 {
+	hours := max(c.WakeAt.Sub(now).Hours(), 0)
+
+	return float64(c.RecentWishes)*s.RecentWeight +
+		float64(c.PairWishes)*s.PairWeight +
+		float64(c.PlanWishes)*s.PlanWeight +
+		hours*s.WakeWeight
+}
+
+func (s *FairMatchStrategy) Choose(candidates []MatchCandidate, now time.Time) (int, bool) {
+	best := -1
+	var bestPenalty float64
+
+	for i := range candidates {
+		penalty := s.penalty(&candidates[i], now)
+		if best < 0 || penalty < bestPenalty ||
+			(penalty == bestPenalty && s.isEarlier(&candidates[i], &candidates[best])) {
+			best = i
+			bestPenalty = penalty
+		}
+	}
+
+	return best, best >= 0
+}
+
+func (s *FairMatchStrategy) isEarlier(a, b *MatchCandidate) bool {
+	if !a.WakeAt.Equal(b.WakeAt) {
+		return a.WakeAt.Before(b.WakeAt)
+	}
+	return a.ID < b.ID
+}
+
+// RandomMatchStrategy picks any candidate
+type RandomMatchStrategy struct{}
+
+func (s *RandomMatchStrategy) Choose(candidates []MatchCandidate, now time.Time) (int, bool) {
+	if len(candidates) == 0 {
+		return -1, false
+	}
+	return rand.Intn(len(candidates)), true
+}
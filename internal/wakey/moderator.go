@@ -23,29 +23,39 @@ type MessageModerator struct {
 	llm    llms.Model
 }
 
-func NewMessageModerator(config ModerationConfig) (*MessageModerator, error) {
+// newLLM creates the language model client for the configured provider
+func newLLM(config LLMConfig) (llms.Model, error) {
 	var llm llms.Model
 	var err error
 
-	switch config.LLM.Provider {
+	switch config.Provider {
 	case ProviderOpenAI:
 		options := []openai.Option{
-			openai.WithToken(config.LLM.APIKey),
-			openai.WithModel(config.LLM.Model),
+			openai.WithToken(config.APIKey),
+			openai.WithModel(config.Model),
 		}
-		if config.LLM.BaseURL != "" {
-			options = append(options, openai.WithBaseURL(config.LLM.BaseURL))
+		if config.BaseURL != "" {
+			options = append(options, openai.WithBaseURL(config.BaseURL))
 		}
 		llm, err = openai.New(options...)
 
 	default:
-		return nil, fmt.Errorf("unsupported LLM provider: %s", config.LLM.Provider)
+		return nil, fmt.Errorf("unsupported LLM provider: %s", config.Provider)
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
 	}
 
+	return llm, nil
+}
+
+func NewMessageModerator(config ModerationConfig) (*MessageModerator, error) {
+	llm, err := newLLM(config.LLM)
+	if err != nil {
+		return nil, err
+	}
+
 	if config.Prompt == "" {
 		config.Prompt = defaultSystemPrompt
 	}
@@ -56,7 +66,29 @@ func NewMessageModerator(config ModerationConfig) (*MessageModerator, error) {
 	}, nil
 }
 
-func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (float64, error) {
+// ModerationResult is the verdict of the moderator. Lang and Translation are
+// empty if the prompt doesn't ask for them.
+type ModerationResult struct {
+	Score       float64
+	Lang        string // ISO 639-1 code of the message language
+	Translation string // English translation of the message
+}
+
+func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (*ModerationResult, error) {
+	responseText, err := generateWithRetries(ctx, m.llm, m.config.LLM.MaxRetries, m.config.Prompt, message,
+		llms.WithTemperature(m.config.Temp),
+		llms.WithMaxTokens(m.config.MaxTok),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseModeration(responseText)
+}
+
+// generateWithRetries sends the system prompt and the message to the LLM and
+// returns the text of the response. Rate limits and service outages are retried.
+func generateWithRetries(ctx context.Context, llm llms.Model, maxRetries int, prompt, message string, options ...llms.CallOption) (string, error) {
 	var lastErr error
 	retries := 0
 
@@ -64,7 +96,7 @@ func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (fl
 		{
 			Role: llms.ChatMessageTypeSystem,
 			Parts: []llms.ContentPart{
-				llms.TextPart(m.config.Prompt),
+				llms.TextPart(prompt),
 			},
 		},
 		{
@@ -75,19 +107,15 @@ func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (fl
 		},
 	}
 
-	for retries <= m.config.LLM.MaxRetries {
-		response, err := m.llm.GenerateContent(ctx, messages,
-			llms.WithTemperature(m.config.Temp),
-			llms.WithMaxTokens(m.config.MaxTok),
-		)
+	for retries <= maxRetries {
+		response, err := llm.GenerateContent(ctx, messages, options...)
 
 		if err == nil {
 			if len(response.Choices) == 0 {
-				return 0, fmt.Errorf("empty response from LLM")
+				return "", fmt.Errorf("empty response from LLM")
 			}
 
-			responseText := response.Choices[0].Content
-			return parseScore(responseText)
+			return response.Choices[0].Content, nil
 		}
 
 		var waitTime time.Duration
@@ -100,13 +128,13 @@ func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (fl
 			waitTime = 3 * time.Second
 		} else {
 			// If it's not a rate limit or service unavailable error, return immediately
-			return 0, fmt.Errorf("LLM generation failed: %w", err)
+			return "", fmt.Errorf("LLM generation failed: %w", err)
 		}
 
 		// Check if context is cancelled before waiting
 		select {
 		case <-ctx.Done():
-			return 0, ctx.Err()
+			return "", ctx.Err()
 		case <-time.After(waitTime):
 			retries++
 			lastErr = err
@@ -114,7 +142,7 @@ func (m *MessageModerator) CheckMessage(ctx context.Context, message string) (fl
 		}
 	}
 
-	return 0, fmt.Errorf("max retries exceeded, last error: %w", lastErr)
+	return "", fmt.Errorf("max retries exceeded, last error: %w", lastErr)
 }
 
 func parseRateLimit(err error) (time.Duration, bool) {
@@ -137,19 +165,33 @@ func isServiceUnavailable(err error) bool {
 	return strings.Contains(err.Error(), "Service Unavailable")
 }
 
-func parseScore(response string) (float64, error) {
-	re := regexp.MustCompile(`FINAL SCORE:\s*(\d+\.?\d*)`)
-	match := re.FindStringSubmatch(response)
+var (
+	scoreRe       = regexp.MustCompile(`FINAL SCORE:\s*(\d+\.?\d*)`)
+	languageRe    = regexp.MustCompile(`LANGUAGE:\s*\[?([a-zA-Z]{2})\b`)
+	translationRe = regexp.MustCompile(`(?s)TRANSLATION:\s*(.*?)\s*\n[A-Z ]+:`)
+)
+
+// ParseModeration extracts the score, the language and the translation from the LLM response
+func ParseModeration(response string) (*ModerationResult, error) {
+	match := scoreRe.FindStringSubmatch(response)
 	if len(match) < 2 {
-		return 0, fmt.Errorf("invalid response format")
+		return nil, fmt.Errorf("invalid response format")
 	}
 
 	score, err := strconv.ParseFloat(match[1], 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse score: %w", err)
+		return nil, fmt.Errorf("failed to parse score: %w", err)
 	}
 
-	return score, nil
+	result := &ModerationResult{Score: score}
+	if match := languageRe.FindStringSubmatch(response); len(match) == 2 {
+		result.Lang = strings.ToLower(match[1])
+	}
+	if match := translationRe.FindStringSubmatch(response); len(match) == 2 {
+		result.Translation = strings.TrimSpace(match[1])
+	}
+
+	return result, nil
 }
 
 const defaultSystemPrompt = `You are a content moderation AI assistant. Your task is to analyze messages for inappropriate content and assign a violation probability score from 0.0 to 1.0. Reply only in English.
@@ -187,6 +229,9 @@ Follow this analysis structure:
 
 Respond in this exact format:
 
+LANGUAGE:
+[ISO 639-1 code of the language the text is written in, for example en or ru]
+
 TRANSLATION:
 [Translate the text into English]
 
@@ -0,0 +1,167 @@
+package wakey
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+type TagKind string
+
+const (
+	TagFeeling TagKind = "feeling"
+	TagNeed    TagKind = "need"
+)
+
+const (
+	MinMood = 1
+	MaxMood = 5
+)
+
+// MoodTag is a feeling or a need from the wheels sent with the status request.
+// Key is stored in the database, Group is the inner ring of the wheel.
+type MoodTag struct {
+	Kind     TagKind
+	Key      string
+	Group    string
+	LabelKey string
+}
+
+// Label returns the tag name in the language
+func (mt MoodTag) Label(lang string) string {
+	return T(lang, mt.LabelKey)
+}
+
+// moodTags follows the middle rings of data/feelings.png and data/needs.png.
+// Tags are referenced by index in callback data, so new tags must be appended at the end.
+var moodTags = []MoodTag{
+	{TagFeeling, "calm", "joy", "tag.calm"},
+	{TagFeeling, "vigor", "joy", "tag.vigor"},
+	{TagFeeling, "inspiration", "joy", "tag.inspiration"},
+	{TagFeeling, "sympathy", "joy", "tag.sympathy"},
+	{TagFeeling, "admiration", "joy", "tag.admiration"},
+	{TagFeeling, "surprise", "fear", "tag.surprise"},
+	{TagFeeling, "embarrassment", "fear", "tag.embarrassment"},
+	{TagFeeling, "anxiety", "fear", "tag.anxiety"},
+	{TagFeeling, "sadness", "sorrow", "tag.sadness"},
+	{TagFeeling, "despondency", "sorrow", "tag.despondency"},
+	{TagFeeling, "anger", "rage", "tag.anger"},
+	{TagFeeling, "dislike", "rage", "tag.dislike"},
+	{TagFeeling, "resentment", "rage", "tag.resentment"},
+	{TagNeed, "health", "comfort", "tag.health"},
+	{TagNeed, "rest", "comfort", "tag.rest"},
+	{TagNeed, "pleasure", "comfort", "tag.pleasure"},
+	{TagNeed, "integrity", "growth", "tag.integrity"},
+	{TagNeed, "freedom", "growth", "tag.freedom"},
+	{TagNeed, "development", "growth", "tag.development"},
+	{TagNeed, "closeness", "contact", "tag.closeness"},
+	{TagNeed, "support", "contact", "tag.support"},
+	{TagNeed, "cooperation", "contact", "tag.cooperation"},
+	{TagNeed, "contribution", "inclusion", "tag.contribution"},
+	{TagNeed, "belonging", "inclusion", "tag.belonging"},
+}
+
+var moodEmojis = map[int8]string{
+	1: "😣",
+	2: "😕",
+	3: "😐",
+	4: "🙂",
+	5: "😄",
+}
+
+// LookupMoodTag returns the tag with the given kind and key
+func LookupMoodTag(kind TagKind, key string) (MoodTag, bool) {
+	idx := slices.IndexFunc(moodTags, func(tag MoodTag) bool {
+		return tag.Kind == kind && tag.Key == key
+	})
+	if idx < 0 {
+		return MoodTag{}, false
+	}
+	return moodTags[idx], true
+}
+
+// MoodEntry is a plan with its mood rating and tags
+type MoodEntry struct {
+	PlanID    uint
+	CreatedAt time.Time
+	Mood      int8
+	Tags      []PlanTag
+}
+
+// MoodWeek aggregates the mood entries of one week starting on Monday
+type MoodWeek struct {
+	Start       time.Time
+	Entries     int
+	Rated       int
+	AvgMood     float64
+	TagCounts   map[string]int
+	TopFeelings []string
+	TopNeeds    []string
+}
+
+// WeeklyMoodTrend groups the entries by weeks in the given location.
+// Weeks are returned from the oldest to the newest and only tags mentioned
+// at least once are kept in the tops.
+func WeeklyMoodTrend(entries []MoodEntry, loc *time.Location, topN int) []MoodWeek {
+	weeks := make(map[time.Time]*MoodWeek)
+	moodSums := make(map[time.Time]int)
+
+	for _, entry := range entries {
+		start := weekStart(entry.CreatedAt, loc)
+		week, ok := weeks[start]
+		if !ok {
+			week = &MoodWeek{Start: start, TagCounts: make(map[string]int)}
+			weeks[start] = week
+		}
+
+		week.Entries++
+		if entry.Mood >= MinMood {
+			week.Rated++
+			moodSums[start] += int(entry.Mood)
+		}
+		for _, tag := range entry.Tags {
+			week.TagCounts[tag.Tag]++
+		}
+	}
+
+	result := make([]MoodWeek, 0, len(weeks))
+	for start, week := range weeks {
+		if week.Rated > 0 {
+			week.AvgMood = float64(moodSums[start]) / float64(week.Rated)
+		}
+		week.TopFeelings = topTags(week.TagCounts, TagFeeling, topN)
+		week.TopNeeds = topTags(week.TagCounts, TagNeed, topN)
+		result = append(result, *week)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result
+}
+
+// topTags returns the keys of the most frequent tags of the kind,
+// ties are broken in the vocabulary order
+func topTags(counts map[string]int, kind TagKind, n int) []string {
+	var keys []string
+	for _, tag := range moodTags {
+		if tag.Kind == kind && counts[tag.Key] > 0 {
+			keys = append(keys, tag.Key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return counts[keys[i]] > counts[keys[j]]
+	})
+
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func weekStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
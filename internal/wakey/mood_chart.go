@@ -0,0 +1,284 @@
+package wakey
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	chartWidth     = 800
+	chartHeight    = 520
+	chartMargin    = 40
+	chartTimelineH = 280
+	chartTagRowH   = 32
+	chartGlyphW    = 3
+	chartGlyphH    = 5
+	chartFontScale = 3
+)
+
+var (
+	chartBackground = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	chartGrid       = color.RGBA{0x40, 0x40, 0x40, 0xff}
+	chartText       = color.RGBA{0xc8, 0xc8, 0xc8, 0xff}
+	chartUpdates    = color.RGBA{0x3a, 0x5a, 0x80, 0xff}
+	chartMood       = color.RGBA{0xff, 0xa0, 0x00, 0xff}
+)
+
+// chartGroupColors follows the colors of the inner rings of the wheels
+var chartGroupColors = map[string]color.RGBA{
+	"joy":       {0xff, 0xa0, 0x00, 0xff},
+	"fear":      {0xb0, 0x30, 0x90, 0xff},
+	"sorrow":    {0x10, 0x58, 0xe0, 0xff},
+	"rage":      {0xe0, 0x00, 0x30, 0xff},
+	"comfort":   {0xff, 0xa0, 0x00, 0xff},
+	"growth":    {0xb0, 0x30, 0x90, 0xff},
+	"contact":   {0x10, 0x58, 0xe0, 0xff},
+	"inclusion": {0xe0, 0x00, 0x30, 0xff},
+}
+
+// chartDigits is a 3x5 bitmap font, one row per string
+var chartDigits = map[rune][chartGlyphH]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+}
+
+// TagCount is a tag with the number of plans it was mentioned in
+type TagCount struct {
+	Tag   MoodTag
+	Count int
+}
+
+// MoodChart holds the daily mood data for a period ending today
+type MoodChart struct {
+	Start   time.Time
+	Moods   []float64 // average daily mood, zero when not rated
+	Updates []int     // number of status updates per day
+	TopTags []TagCount
+}
+
+// BuildMoodChart aggregates the entries by days in the given location
+func BuildMoodChart(entries []MoodEntry, days int, now time.Time, loc *time.Location, topN int) *MoodChart {
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	chart := &MoodChart{
+		Start:   today.AddDate(0, 0, 1-days),
+		Moods:   make([]float64, days),
+		Updates: make([]int, days),
+	}
+
+	rated := make([]int, days)
+	tagCounts := make(map[string]int)
+	for _, entry := range entries {
+		local := entry.CreatedAt.In(loc)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		idx := int(day.Sub(chart.Start) / (24 * time.Hour))
+		if idx < 0 || idx >= days {
+			continue
+		}
+
+		chart.Updates[idx]++
+		if entry.Mood >= MinMood {
+			chart.Moods[idx] += float64(entry.Mood)
+			rated[idx]++
+		}
+		for _, tag := range entry.Tags {
+			tagCounts[tag.Tag]++
+		}
+	}
+
+	for i := range chart.Moods {
+		if rated[i] > 0 {
+			chart.Moods[i] /= float64(rated[i])
+		}
+	}
+
+	for _, tag := range moodTags {
+		if tagCounts[tag.Key] > 0 {
+			chart.TopTags = append(chart.TopTags, TagCount{Tag: tag, Count: tagCounts[tag.Key]})
+		}
+	}
+	sort.SliceStable(chart.TopTags, func(i, j int) bool {
+		return chart.TopTags[i].Count > chart.TopTags[j].Count
+	})
+	if len(chart.TopTags) > topN {
+		chart.TopTags = chart.TopTags[:topN]
+	}
+
+	return chart
+}
+
+// HasMood reports whether at least one day has a mood rating
+func (mc *MoodChart) HasMood() bool {
+	for _, mood := range mc.Moods {
+		if mood > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Render draws the chart as a PNG image. The upper part shows the number of
+// status updates per day as bars and the mood as a line, the lower part shows
+// the most frequent tags numbered in the order of TopTags.
+func (mc *MoodChart) Render() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	mc.drawTimeline(img, image.Rect(chartMargin+20, chartMargin, chartWidth-chartMargin, chartMargin+chartTimelineH))
+	mc.drawTags(img, image.Rect(chartMargin+20, chartMargin+chartTimelineH+chartMargin, chartWidth-chartMargin, chartHeight-chartMargin/2))
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (mc *MoodChart) drawTimeline(img *image.RGBA, area image.Rectangle) {
+	days := len(mc.Moods)
+	if days == 0 {
+		return
+	}
+
+	// Horizontal grid lines with the mood scale
+	yOf := func(mood float64) int {
+		return area.Max.Y - int((mood-MinMood+0.5)/float64(MaxMood-MinMood+1)*float64(area.Dy()))
+	}
+	for mood := MinMood; mood <= MaxMood; mood++ {
+		y := yOf(float64(mood))
+		fillRect(img, image.Rect(area.Min.X, y, area.Max.X, y+1), chartGrid)
+		drawText(img, string(rune('0'+mood)), area.Min.X-chartMargin+4, y-chartGlyphH*chartFontScale/2, chartText)
+	}
+
+	// Update frequency bars
+	dayW := float64(area.Dx()) / float64(days)
+	maxUpdates := 1
+	for _, updates := range mc.Updates {
+		maxUpdates = max(maxUpdates, updates)
+	}
+	for i, updates := range mc.Updates {
+		if updates == 0 {
+			continue
+		}
+		x0 := area.Min.X + int(float64(i)*dayW) + 1
+		x1 := area.Min.X + int(float64(i+1)*dayW) - 1
+		h := updates * area.Dy() / (2 * maxUpdates)
+		fillRect(img, image.Rect(x0, area.Max.Y-h, max(x1, x0+1), area.Max.Y), chartUpdates)
+	}
+
+	// Week ticks
+	for i := 0; i < days; i++ {
+		if mc.Start.AddDate(0, 0, i).Weekday() == time.Monday {
+			x := area.Min.X + int(float64(i)*dayW)
+			fillRect(img, image.Rect(x, area.Max.Y, x+1, area.Max.Y+8), chartText)
+		}
+	}
+
+	// Mood line through the rated days
+	prevX, prevY := -1, -1
+	for i, mood := range mc.Moods {
+		if mood == 0 {
+			continue
+		}
+		x := area.Min.X + int((float64(i)+0.5)*dayW)
+		y := yOf(mood)
+		if prevX >= 0 {
+			drawLine(img, prevX, prevY, x, y, chartMood)
+		}
+		fillRect(img, image.Rect(x-3, y-3, x+4, y+4), chartMood)
+		prevX, prevY = x, y
+	}
+}
+
+func (mc *MoodChart) drawTags(img *image.RGBA, area image.Rectangle) {
+	if len(mc.TopTags) == 0 {
+		return
+	}
+
+	maxCount := mc.TopTags[0].Count
+	labelW := 3 * (chartGlyphW + 1) * chartFontScale
+	for i, tagCount := range mc.TopTags {
+		y := area.Min.Y + i*chartTagRowH
+		if y+chartTagRowH > area.Max.Y {
+			break
+		}
+
+		drawText(img, strconv.Itoa(i+1)+".", area.Min.X-chartMargin+4, y+4, chartText)
+		barW := tagCount.Count * (area.Dx() - labelW) / maxCount
+		fillRect(img, image.Rect(area.Min.X, y+2, area.Min.X+max(barW, 2), y+chartTagRowH-6), chartGroupColors[tagCount.Tag.Group])
+		drawText(img, strconv.Itoa(tagCount.Count), area.Min.X+barW+8, y+4, chartText)
+	}
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// drawLine draws a 3 pixels wide line using Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	e := dx + dy
+	for {
+		fillRect(img, image.Rect(x0-1, y0-1, x0+2, y0+2), c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e += dy
+			x0 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func drawText(img *image.RGBA, text string, x, y int, c color.RGBA) {
+	for _, r := range text {
+		glyph, ok := chartDigits[r]
+		if !ok {
+			continue
+		}
+		for row, line := range glyph {
+			for col, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				px, py := x+col*chartFontScale, y+row*chartFontScale
+				fillRect(img, image.Rect(px, py, px+chartFontScale, py+chartFontScale), c)
+			}
+		}
+		x += (chartGlyphW + 1) * chartFontScale
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
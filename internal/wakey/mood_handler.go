@@ -0,0 +1,224 @@
+package wakey
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	moodTrendWeeks = 8
+	moodTrendTopN  = 3
+	moodChartTopN  = 4
+)
+
+// moodChartPeriods are the chart lengths in days, the first one is the default
+var moodChartPeriods = []int{30, 90}
+
+type MoodHandler struct {
+	db        *DB
+	stateMan  *StateManager
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
+}
+
+func NewMoodHandler(db *DB, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *MoodHandler {
+	return &MoodHandler{
+		db:        db,
+		stateMan:  stateMan,
+		callbacks: callbacks,
+		log:       log,
+	}
+}
+
+func (mh *MoodHandler) Actions() []string {
+	return []string{btnShowMoodTrendID, btnMoodChartID}
+}
+
+func (mh *MoodHandler) HandleAction(c tele.Context, action string) error {
+	switch action {
+	case btnShowMoodTrendID:
+		return mh.HandleShowMoodTrend(c)
+	case btnMoodChartID:
+		days, err := getButtonID(c, "mood.invalid_period")
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		return mh.sendMoodChart(c, int(days))
+	default:
+		mh.log.Errorw("unexpected action for MoodHandler", "action", action)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+func (mh *MoodHandler) States() []UserState {
+	return []UserState{StateShowMoodChart}
+}
+
+func (mh *MoodHandler) HandleState(c tele.Context, state UserState) error {
+	switch state {
+	case StateShowMoodChart:
+		return mh.HandleMoodCommand(c)
+	default:
+		mh.log.Errorw("unexpected state for MoodHandler", "state", state)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+// HandleMoodCommand handles /mood with an optional period in days
+func (mh *MoodHandler) HandleMoodCommand(c tele.Context) error {
+	days := moodChartPeriods[0]
+	if args := c.Args(); len(args) > 0 {
+		var err error
+		days, err = strconv.Atoi(args[0])
+		if err != nil {
+			days = 0
+		}
+	}
+
+	return mh.sendMoodChart(c, days)
+}
+
+func (mh *MoodHandler) sendMoodChart(c tele.Context, days int) error {
+	if !slices.Contains(moodChartPeriods, days) {
+		periods := make([]string, len(moodChartPeriods))
+		for i, period := range moodChartPeriods {
+			periods[i] = strconv.Itoa(period)
+		}
+		return c.Send(tr(c, "mood.chart_periods",
+			strings.Join(periods, ", "), moodChartPeriods[len(moodChartPeriods)-1]))
+	}
+
+	userID := c.Sender().ID
+	user, err := mh.db.GetUserByID(userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Send(tr(c, "common.not_registered"))
+		}
+		mh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+	userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
+
+	now := time.Now()
+	entries, err := mh.db.GetMoodEntries(userID, now.AddDate(0, 0, -days-1))
+	if err != nil {
+		mh.log.Errorw("failed to get mood entries", "error", err, "userID", userID)
+		return c.Send(tr(c, "mood.load_error"))
+	}
+
+	if len(entries) == 0 {
+		return c.Send(trn(c, "mood.chart_empty", int64(days), days))
+	}
+
+	chart := BuildMoodChart(entries, days, now, userLoc, moodChartTopN)
+	data, err := chart.Render()
+	if err != nil {
+		mh.log.Errorw("failed to render mood chart", "error", err, "userID", userID)
+		return c.Send(tr(c, "mood.chart_error"))
+	}
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	var periods tele.Row
+	for _, period := range moodChartPeriods {
+		if period != days {
+			periods = append(periods, mh.callbacks.senderBtn(c, trn(c, btnMoodChartText, int64(period), period), btnMoodChartID, strconv.Itoa(period)))
+		}
+	}
+	inlineKeyboard.Inline(periods)
+
+	photo := &tele.Photo{
+		File:    tele.FromReader(bytes.NewReader(data)),
+		Caption: formatMoodChartCaption(ctxLang(c), chart, days),
+	}
+	return c.Send(photo, inlineKeyboard)
+}
+
+func formatMoodChartCaption(lang string, chart *MoodChart, days int) string {
+	var sb strings.Builder
+	sb.WriteString(TN(lang, "mood.chart_title", int64(days), days) + "\n\n")
+	if chart.HasMood() {
+		sb.WriteString(T(lang, "mood.chart_legend"))
+	} else {
+		sb.WriteString(T(lang, "mood.chart_legend_no_mood"))
+	}
+
+	if len(chart.TopTags) > 0 {
+		sb.WriteString("\n\n" + T(lang, "mood.chart_top_tags"))
+		for i, tagCount := range chart.TopTags {
+			fmt.Fprintf(&sb, "\n%d. %s — %d", i+1, tagCount.Tag.Label(lang), tagCount.Count)
+		}
+	}
+
+	return sb.String()
+}
+
+func (mh *MoodHandler) HandleShowMoodTrend(c tele.Context) error {
+	userID := c.Sender().ID
+
+	user, err := mh.db.GetUserByID(userID)
+	if err != nil {
+		mh.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+	userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
+
+	since := weekStart(time.Now(), userLoc).AddDate(0, 0, -7*(moodTrendWeeks-1))
+	entries, err := mh.db.GetMoodEntries(userID, since)
+	if err != nil {
+		mh.log.Errorw("failed to get mood entries", "error", err, "userID", userID)
+		return c.Send(tr(c, "mood.load_error"))
+	}
+
+	weeks := WeeklyMoodTrend(entries, userLoc, moodTrendTopN)
+	if len(weeks) == 0 {
+		return c.Send(tr(c, "mood.no_data"))
+	}
+
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnChart := mh.callbacks.senderBtn(c, trn(c, btnMoodChartText, int64(moodChartPeriods[0]), moodChartPeriods[0]), btnMoodChartID, strconv.Itoa(moodChartPeriods[0]))
+	inlineKeyboard.Inline(inlineKeyboard.Row(btnChart))
+
+	return c.Send(formatMoodTrend(ctxLang(c), weeks), inlineKeyboard)
+}
+
+func formatMoodTrend(lang string, weeks []MoodWeek) string {
+	var sb strings.Builder
+	sb.WriteString(T(lang, "mood.trend_title") + "\n")
+
+	for _, week := range weeks {
+		end := week.Start.AddDate(0, 0, 6)
+		fmt.Fprintf(&sb, "\n📅 %s–%s\n", week.Start.Format("02.01"), end.Format("02.01"))
+		if week.Rated > 0 {
+			sb.WriteString(TN(lang, "mood.week_rated", int64(week.Rated), week.AvgMood,
+				moodEmojis[int8(math.Round(week.AvgMood))], week.Rated) + "\n")
+		} else {
+			sb.WriteString(TN(lang, "mood.week_not_rated", int64(week.Entries), week.Entries) + "\n")
+		}
+		if len(week.TopFeelings) > 0 {
+			sb.WriteString(T(lang, "mood.feelings", moodTagLabels(lang, TagFeeling, week.TopFeelings)) + "\n")
+		}
+		if len(week.TopNeeds) > 0 {
+			sb.WriteString(T(lang, "mood.needs", moodTagLabels(lang, TagNeed, week.TopNeeds)) + "\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func moodTagLabels(lang string, kind TagKind, keys []string) string {
+	labels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if tag, ok := LookupMoodTag(kind, key); ok {
+			labels = append(labels, tag.Label(lang))
+		}
+	}
+	return strings.Join(labels, ", ")
+}
@@ -1,7 +1,9 @@
 package wakey
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,28 +11,78 @@ import (
 	tele "gopkg.in/telebot.v3"
 )
 
+const (
+	flowNotifySetup      FlowID = "notify_setup"
+	flowUpdateNotifyTime FlowID = "update_notify_time"
+	flowPlans            FlowID = "plans"
+	flowUpdatePlans      FlowID = "update_plans"
+	flowUpdateWakeTime   FlowID = "update_wake_time"
+)
+
 type PlanHandler struct {
 	api       BotAPI
 	db        *DB
+	flows     *FlowRunner
+	validator *Validator
 	stateMan  *StateManager
 	planSched Scheduler
 	wishSched Scheduler
+	callbacks *CallbackCodec
 	log       *zap.SugaredLogger
 }
 
-func NewPlanHandler(db *DB, api BotAPI, planSched, wishSched Scheduler, stateMan *StateManager, log *zap.SugaredLogger) *PlanHandler {
+func NewPlanHandler(db *DB, api BotAPI, planSched, wishSched Scheduler, flows *FlowRunner, validator *Validator, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *PlanHandler {
 	ph := &PlanHandler{
 		api:       api,
 		db:        db,
+		flows:     flows,
+		validator: validator,
 		stateMan:  stateMan,
 		planSched: planSched,
 		wishSched: wishSched,
+		callbacks: callbacks,
 		log:       log,
 	}
 
+	flows.Register(
+		&Flow{
+			ID:         flowNotifySetup,
+			Steps:      []FlowStep{{State: StateAwaitingNotificationTime, Prompt: "profile.ask_notify_time", PromptArgs: disableWordPromptArgs, Accept: ph.acceptNotifyTime}},
+			OnComplete: ph.completeNotifySetup,
+		},
+		&Flow{
+			ID:         flowUpdateNotifyTime,
+			Steps:      []FlowStep{{State: StateUpdatingNotificationTime, Prompt: "plan.enter_notify_time", PromptArgs: disableWordPromptArgs, Accept: ph.acceptNotifyTime}},
+			OnComplete: ph.completeNotifyTimeUpdate,
+		},
+		&Flow{
+			ID: flowPlans,
+			Steps: []FlowStep{
+				{State: StateAwaitingPlans, Ask: ph.askAboutPlans, Validate: validator.Field(FieldPlans), Accept: acceptPlans},
+				{State: StateAwaitingWakeTime, Prompt: "plan.ask_wake_time", Accept: ph.acceptWakeTime},
+			},
+			Back:       true,
+			OnComplete: ph.completePlans,
+		},
+		&Flow{
+			ID:         flowUpdatePlans,
+			Steps:      []FlowStep{{State: StateUpdatingPlans, Ask: ph.askAboutPlans, Validate: validator.Field(FieldPlans), Accept: acceptPlansUpdate}},
+			OnComplete: ph.completePlansUpdate,
+		},
+		&Flow{
+			ID:         flowUpdateWakeTime,
+			Steps:      []FlowStep{{State: StateUpdatingWakeTime, Prompt: "plan.enter_wake_time", Accept: ph.acceptWakeTime}},
+			OnComplete: ph.completeWakeTimeUpdate,
+		},
+	)
+
 	planSched.SetJobFunc(ph.notifyAboutPlansUpdate)
 	ph.ScheduleAllNotifications()
 
+	// Subscribe to user reachability updates
+	userCh, _ := db.SubscribeToReachability(100)
+	go ph.monitorReachability(userCh)
+
 	return ph
 }
 
@@ -42,6 +94,11 @@ func (ph *PlanHandler) Actions() []string {
 		btnKeepPlansID,
 		btnUpdatePlansID,
 		btnNoWishID,
+		btnMoodRateID,
+		btnMoodSkipID,
+		btnMoodTagID,
+		btnMoodNeedsID,
+		btnMoodDoneID,
 	}
 }
 
@@ -50,70 +107,52 @@ func (ph *PlanHandler) HandleAction(c tele.Context, action string) error {
 
 	switch action {
 	case btnChangePlansID:
-		ph.stateMan.SetState(userID, StateUpdatingPlans)
-		return ph.askAboutPlans(c)
+		return ph.flows.Start(c, flowUpdatePlans, nil)
 	case btnChangeWakeTimeID:
-		ph.stateMan.SetState(userID, StateUpdatingWakeTime)
-		return c.Send("Пожалуйста, введите новое время пробуждения в формате ЧЧ:ММ. Используйте команду /cancel для отмены.")
+		return ph.flows.Start(c, flowUpdateWakeTime, nil)
 	case btnChangeNotifyTimeID:
-		ph.stateMan.SetState(userID, StateUpdatingNotificationTime)
-		return c.Send("Пожалуйста, введите новое время уведомления в формате ЧЧ:ММ. " +
-			"Если вы хотите отключить уведомления, отправьте 'отключить'.\n" +
-			"Или используйте команду /cancel для отмены.")
+		return ph.flows.Start(c, flowUpdateNotifyTime, nil)
 	case btnKeepPlansID:
 		plan, err := ph.db.CopyPlanForNextDay(userID)
 		if err != nil {
 			ph.log.Errorw("failed to copy plan for next day", "error", err, "userID", userID)
-			return c.Send("Произошла ошибка при сохранении вашего статуса. Пожалуйста, попробуйте позже.")
+			return c.Send(tr(c, "plan.keep_error"))
 		}
 		ph.scheduleWishSend(plan)
-		err = c.Send("Хорошо, ваши статус и время пробуждения остаются без изменений.")
+		err = c.Send(tr(c, "plan.kept"))
 		if err != nil {
 			return err
 		}
 
 		return ph.askAboutWish(c)
 	case btnUpdatePlansID:
-		ph.stateMan.SetState(userID, StateAwaitingPlans)
-		return ph.askAboutPlans(c)
+		return ph.flows.Start(c, flowPlans, nil)
 	case btnNoWishID:
 		ph.stateMan.ClearState(userID)
-		return c.Send("Хорошо, завтра вы не получите сообщение от другого пользователя.")
+		return c.Send(tr(c, "plan.no_wish"))
+	case btnMoodRateID:
+		return ph.HandleMoodRate(c)
+	case btnMoodSkipID:
+		return ph.HandleMoodSkip(c)
+	case btnMoodTagID:
+		return ph.HandleMoodTag(c)
+	case btnMoodNeedsID:
+		return ph.HandleMoodNeeds(c)
+	case btnMoodDoneID:
+		return ph.HandleMoodDone(c)
 	default:
 		ph.log.Errorw("unexpected action for PlanHandler", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
 func (ph *PlanHandler) States() []UserState {
-	return []UserState{
-		StateAwaitingPlans,
-		StateAwaitingWakeTime,
-		StateAwaitingNotificationTime,
-		StateUpdatingPlans,
-		StateUpdatingWakeTime,
-		StateUpdatingNotificationTime,
-	}
+	return []UserState{}
 }
 
 func (ph *PlanHandler) HandleState(c tele.Context, state UserState) error {
-	switch state {
-	case StateAwaitingPlans:
-		return ph.HandlePlansInput(c)
-	case StateUpdatingPlans:
-		return ph.HandlePlansUpdate(c)
-	case StateAwaitingWakeTime:
-		return ph.HandleWakeTimeInput(c)
-	case StateUpdatingWakeTime:
-		return ph.HandleWakeTimeUpdate(c)
-	case StateAwaitingNotificationTime:
-		return ph.HandleNotificationTimeInput(c)
-	case StateUpdatingNotificationTime:
-		return ph.HandleNotificationTimeUpdate(c)
-	default:
-		ph.log.Errorw("unexpected state for PlanHandler", "state", state)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
-	}
+	ph.log.Errorw("unexpected state for PlanHandler", "state", state)
+	return c.Send(tr(c, "common.unknown_action"))
 }
 
 func (ph *PlanHandler) schedulePlanReminder(user *User) {
@@ -138,16 +177,35 @@ func (ph *PlanHandler) scheduleWishSend(plan *Plan) {
 	ph.log.Infow("scheduled wish", "userID", plan.UserID, "wakeAt", plan.WakeAt)
 }
 
-func (ph *PlanHandler) askAboutPlans(c tele.Context) error {
-	const caption = "Пожалуйста, расскажите кратко о своем состоянии в текущий момент. " +
-		"Можете написать о своих чувствах, свои мысли, о сегодняшнем дне, " +
-		"о планах на завтра — все, что вам сейчас важно. Форма свободная, " +
-		"главное — внимание на себя.\n\n" +
-		"Можно, хотя и не обязательно, использовать прикрепленные списки чувств и потребностей.\n" +
-		"Или отправьте команду /cancel для отмены."
+func (ph *PlanHandler) monitorReachability(ch <-chan *User) {
+	for user := range ch {
+		if user.IsUnreachable {
+			ph.planSched.Cancel(JobID(user.ID))
+			ph.wishSched.Cancel(JobID(user.ID))
+			ph.log.Infow("cancelled jobs for unreachable user", "userID", user.ID)
+			continue
+		}
+
+		if !user.IsBanned {
+			ph.schedulePlanReminder(user)
+		}
 
+		plan, err := ph.db.GetLatestPlan(user.ID)
+		if err != nil {
+			if err != ErrNotFound {
+				ph.log.Errorw("failed to get latest plan", "error", err, "userID", user.ID)
+			}
+			continue
+		}
+		if plan.WakeAt.After(time.Now().UTC()) {
+			ph.scheduleWishSend(plan)
+		}
+	}
+}
+
+func (ph *PlanHandler) askAboutPlans(c tele.Context, data *UserData) error {
 	album := tele.Album{
-		&tele.Photo{File: tele.FromDisk("./data/feelings.png"), Caption: caption},
+		&tele.Photo{File: tele.FromDisk("./data/feelings.png"), Caption: tr(c, "plan.ask_plans")},
 		&tele.Photo{File: tele.FromDisk("./data/needs.png")},
 	}
 
@@ -158,7 +216,7 @@ func (ph *PlanHandler) askAboutWish(c tele.Context) error {
 	userID := c.Sender().ID
 	userData, exists := ph.stateMan.GetUserData(userID)
 	if !exists || !userData.AskAboutWish {
-		ph.stateMan.SetState(userID, StateSuggestActions)
+		showMenu(c)
 		return nil
 	}
 
@@ -171,68 +229,323 @@ func (ph *PlanHandler) askAboutWish(c tele.Context) error {
 
 	// Ask if the user wants to send a wish
 	inlineKeyboard := &tele.ReplyMarkup{}
-	btnYes := inlineKeyboard.Data(btnSendWishYesText, btnSendWishYesID)
-	btnNo := inlineKeyboard.Data(btnSendWishNoText, btnSendWishNoID)
+	btnYes := ph.callbacks.senderBtn(c, tr(c, btnSendWishYesText), btnSendWishYesID)
+	btnNo := ph.callbacks.senderBtn(c, tr(c, btnSendWishNoText), btnSendWishNoID)
 	inlineKeyboard.Inline(
 		inlineKeyboard.Row(btnYes),
 		inlineKeyboard.Row(btnNo),
 	)
 
-	return c.Send("Хотите отправить сообщение другому пользователю?", inlineKeyboard)
+	return c.Send(tr(c, "plan.ask_wish"), inlineKeyboard)
 }
 
-func (ph *PlanHandler) HandlePlansInput(c tele.Context) error {
-	userID := c.Sender().ID
-	userData, _ := ph.stateMan.GetUserData(userID)
-	userData.Plans = c.Text()
-	userData.AskAboutWish = true
-	ph.stateMan.SetUserData(userID, userData)
-	ph.stateMan.SetState(userID, StateAwaitingWakeTime)
-	return c.Send("Отлично! Теперь скажите, во сколько вы планируете проснуться завтра? (Используйте формат ЧЧ:ММ)")
+// askAboutMood offers to rate the mood and tag the feelings and needs of the saved plan.
+// The step is optional and continues with askAboutWish either way.
+func (ph *PlanHandler) askAboutMood(c tele.Context, plan *Plan) error {
+	if err := ph.db.ResetPlanMood(plan.ID, plan.UserID); err != nil {
+		ph.log.Errorw("failed to reset plan mood", "error", err, "planID", plan.ID)
+		return ph.askAboutWish(c)
+	}
+
+	planID := strconv.FormatUint(uint64(plan.ID), 10)
+	inlineKeyboard := &tele.ReplyMarkup{}
+	rates := make(tele.Row, 0, MaxMood)
+	for mood := int8(MinMood); mood <= MaxMood; mood++ {
+		btnRate := ph.callbacks.senderBtn(c, fmt.Sprintf("%d %s", mood, moodEmojis[mood]), btnMoodRateID, planID, strconv.Itoa(int(mood)))
+		rates = append(rates, btnRate)
+	}
+	btnSkip := ph.callbacks.senderBtn(c, tr(c, btnMoodSkipText), btnMoodSkipID, planID)
+	inlineKeyboard.Inline(rates, inlineKeyboard.Row(btnSkip))
+
+	return c.Send(tr(c, "plan.ask_mood"), inlineKeyboard)
+}
+
+// getMoodPlan loads the plan from the callback data and checks that it belongs to the user
+func (ph *PlanHandler) getMoodPlan(c tele.Context, planID uint64) (*Plan, error) {
+	plan, err := ph.db.GetPlanByID(uint(planID))
+	if err != nil {
+		if err != ErrNotFound {
+			ph.log.Errorw("failed to get plan", "error", err, "planID", planID)
+		}
+		return nil, err
+	}
+	if plan.UserID != c.Sender().ID {
+		ph.log.Warnw("user tried to tag someone else's plan", "userID", c.Sender().ID, "planID", planID)
+		return nil, ErrNotFound
+	}
+	return plan, nil
+}
+
+func (ph *PlanHandler) moodTagsMarkup(c tele.Context, planID uint, kind TagKind, selected []PlanTag) *tele.ReplyMarkup {
+	isSelected := make(map[string]bool, len(selected))
+	for _, tag := range selected {
+		isSelected[tag.Tag] = true
+	}
+
+	id := strconv.FormatUint(uint64(planID), 10)
+	inlineKeyboard := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var row tele.Row
+	for i, tag := range moodTags {
+		if tag.Kind != kind {
+			continue
+		}
+
+		text := tag.Label(ctxLang(c))
+		if isSelected[tag.Key] {
+			text = tr(c, btnMoodTagSelectedText, text)
+		}
+		row = append(row, ph.callbacks.senderBtn(c, text, btnMoodTagID, id, strconv.Itoa(i)))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if kind == TagFeeling {
+		rows = append(rows, inlineKeyboard.Row(ph.callbacks.senderBtn(c, tr(c, btnMoodNeedsText), btnMoodNeedsID, id)))
+	} else {
+		rows = append(rows, inlineKeyboard.Row(ph.callbacks.senderBtn(c, tr(c, btnMoodDoneText), btnMoodDoneID, id)))
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return inlineKeyboard
 }
 
-func (ph *PlanHandler) HandleWakeTimeInput(c tele.Context) error {
+func formatMood(lang string, mood int8) string {
+	if mood < MinMood {
+		return T(lang, "mood.not_rated")
+	}
+	return fmt.Sprintf("%d %s", mood, moodEmojis[mood])
+}
+
+func formatMoodSummary(lang string, plan *Plan, tags []PlanTag) string {
+	var feelings, needs []string
+	for _, planTag := range tags {
+		tag, ok := LookupMoodTag(planTag.Kind, planTag.Tag)
+		if !ok {
+			continue
+		}
+		if tag.Kind == TagFeeling {
+			feelings = append(feelings, tag.Label(lang))
+		} else {
+			needs = append(needs, tag.Label(lang))
+		}
+	}
+
+	summary := T(lang, "mood.rating", formatMood(lang, plan.Mood))
+	if len(feelings) > 0 {
+		summary += "\n" + T(lang, "mood.feelings", strings.Join(feelings, ", "))
+	}
+	if len(needs) > 0 {
+		summary += "\n" + T(lang, "mood.needs", strings.Join(needs, ", "))
+	}
+	return summary
+}
+
+func (ph *PlanHandler) HandleMoodRate(c tele.Context) error {
+	args, err := getButtonArgs(c, 2, "plan.invalid_mood")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	planID, mood := args[0], int8(args[1])
+
+	err = ph.db.SetPlanMood(uint(planID), c.Sender().ID, mood)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Send(tr(c, "plan.not_found"))
+		}
+		ph.log.Errorw("failed to set plan mood", "error", err, "planID", planID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	tags, err := ph.db.GetPlanTags(uint(planID))
+	if err != nil {
+		ph.log.Errorw("failed to get plan tags", "error", err, "planID", planID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	message := tr(c, "mood.rating", formatMood(ctxLang(c), mood)) + "\n\n" + tr(c, "plan.ask_feelings")
+	return c.Edit(message, ph.moodTagsMarkup(c, uint(planID), TagFeeling, tags))
+}
+
+func (ph *PlanHandler) HandleMoodTag(c tele.Context) error {
+	args, err := getButtonArgs(c, 2, "plan.invalid_tag")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	planID, idx := args[0], args[1]
+	if idx >= uint64(len(moodTags)) {
+		return c.Send(tr(c, "plan.invalid_tag"))
+	}
+	tag := moodTags[idx]
+
+	_, err = ph.db.TogglePlanTag(uint(planID), c.Sender().ID, tag.Kind, tag.Key)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Send(tr(c, "plan.not_found"))
+		}
+		ph.log.Errorw("failed to toggle plan tag", "error", err, "planID", planID, "tag", tag.Key)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	tags, err := ph.db.GetPlanTags(uint(planID))
+	if err != nil {
+		ph.log.Errorw("failed to get plan tags", "error", err, "planID", planID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if err := c.Edit(c.Message().Text, ph.moodTagsMarkup(c, uint(planID), tag.Kind, tags)); err != nil {
+		ph.log.Warnw("failed to update mood tags message", "err", err)
+	}
+	return c.Respond()
+}
+
+func (ph *PlanHandler) HandleMoodNeeds(c tele.Context) error {
+	planID, err := getButtonID(c, "common.invalid_plan_id")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	plan, err := ph.getMoodPlan(c, planID)
+	if err != nil {
+		return c.Send(tr(c, "plan.not_found"))
+	}
+
+	tags, err := ph.db.GetPlanTags(plan.ID)
+	if err != nil {
+		ph.log.Errorw("failed to get plan tags", "error", err, "planID", planID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	message := formatMoodSummary(ctxLang(c), plan, tags) + "\n\n" + tr(c, "plan.ask_needs")
+	return c.Edit(message, ph.moodTagsMarkup(c, plan.ID, TagNeed, tags))
+}
+
+func (ph *PlanHandler) HandleMoodDone(c tele.Context) error {
+	planID, err := getButtonID(c, "common.invalid_plan_id")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	plan, err := ph.getMoodPlan(c, planID)
+	if err != nil {
+		return c.Send(tr(c, "plan.not_found"))
+	}
+
+	tags, err := ph.db.GetPlanTags(plan.ID)
+	if err != nil {
+		ph.log.Errorw("failed to get plan tags", "error", err, "planID", planID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if err := c.Edit(tr(c, "plan.mood_saved") + "\n\n" + formatMoodSummary(ctxLang(c), plan, tags)); err != nil {
+		ph.log.Warnw("failed to update mood tags message", "err", err)
+	}
+
+	return ph.askAboutWish(c)
+}
+
+func (ph *PlanHandler) HandleMoodSkip(c tele.Context) error {
+	planID, err := getButtonID(c, "common.invalid_plan_id")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	err = ph.db.ResetPlanMood(uint(planID), c.Sender().ID)
+	if err != nil && err != ErrNotFound {
+		ph.log.Errorw("failed to reset plan mood", "error", err, "planID", planID)
+	}
+
+	if err := c.Edit(tr(c, "plan.mood_skipped")); err != nil {
+		ph.log.Warnw("failed to update mood message", "err", err)
+	}
+
+	return ph.askAboutWish(c)
+}
+
+func disableWordPromptArgs(c tele.Context, data *UserData) []any {
+	return []any{tr(c, "time.disable_word")}
+}
+
+func acceptPlans(c tele.Context, data *UserData) error {
+	data.Plans = strings.TrimSpace(c.Text())
+	data.AskAboutWish = true
+	return nil
+}
+
+func acceptPlansUpdate(c tele.Context, data *UserData) error {
+	data.Plans = strings.TrimSpace(c.Text())
+	return nil
+}
+
+// parseUserTime parses the time entered by the user in their time zone.
+// The returned error is a message for the user.
+func (ph *PlanHandler) parseUserTime(c tele.Context) (time.Time, error) {
 	userID := c.Sender().ID
-	wakeTimeStr := c.Text()
 
-	// Load user to get timezone
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
-		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		ph.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return time.Time{}, errors.New(tr(c, "common.error"))
 	}
 
-	utcWakeTime, err := parseTime(wakeTimeStr, user.Tz)
+	utcTime, err := parseTime(c.Text(), user.Tz)
 	if err != nil {
-		return c.Send(err.Error())
+		return time.Time{}, errors.New(tr(c, "time.invalid_format"))
+	}
+	return utcTime, nil
+}
+
+func (ph *PlanHandler) acceptWakeTime(c tele.Context, data *UserData) error {
+	wakeAt, err := ph.parseUserTime(c)
+	if err != nil {
+		return err
+	}
+	data.WakeAt = wakeAt
+	return nil
+}
+
+func (ph *PlanHandler) acceptNotifyTime(c tele.Context, data *UserData) error {
+	if isDisableWord(c, c.Text()) {
+		data.NotifyAt = time.Time{} // Zero time means notifications are disabled
+		return nil
 	}
 
-	userData, _ := ph.stateMan.GetUserData(userID)
+	notifyAt, err := ph.parseUserTime(c)
+	if err != nil {
+		return err
+	}
+	data.NotifyAt = notifyAt
+	return nil
+}
 
-	// Save plan to database
+func (ph *PlanHandler) completePlans(c tele.Context, data *UserData) error {
 	plan := &Plan{
-		UserID:  userID,
-		Content: userData.Plans,
-		WakeAt:  utcWakeTime,
+		UserID:  c.Sender().ID,
+		Content: data.Plans,
+		WakeAt:  data.WakeAt,
 	}
 
 	if err := ph.db.SavePlan(plan); err != nil {
 		ph.log.Errorw("failed to save plan", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.save_error"))
 	}
 	ph.scheduleWishSend(plan)
 
-	err = c.Send("Ваше время пробуждения успешно обновлено.")
+	err := c.Send(tr(c, "plan.wake_time_updated"))
 	if err != nil {
 		return err
 	}
 
-	return ph.askAboutWish(c)
+	return ph.askAboutMood(c, plan)
 }
 
-func (ph *PlanHandler) HandlePlansUpdate(c tele.Context) error {
+func (ph *PlanHandler) completePlansUpdate(c tele.Context, data *UserData) error {
 	userID := c.Sender().ID
-	newPlans := c.Text()
 
 	now := time.Now().UTC()
 	plan, err := ph.db.CopyPlanForNextDay(userID)
@@ -245,10 +558,10 @@ func (ph *PlanHandler) HandlePlansUpdate(c tele.Context) error {
 			}
 		} else {
 			ph.log.Errorw("failed to get latest plan", "error", err)
-			return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+			return c.Send(tr(c, "common.error"))
 		}
 	}
-	plan.Content = newPlans
+	plan.Content = data.Plans
 
 	for plan.WakeAt.Before(now) {
 		plan.WakeAt = plan.WakeAt.Add(24 * time.Hour)
@@ -256,32 +569,20 @@ func (ph *PlanHandler) HandlePlansUpdate(c tele.Context) error {
 
 	if err := ph.db.SavePlan(plan); err != nil {
 		ph.log.Errorw("failed to save plan", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашего статуса. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "plan.save_error"))
 	}
 	ph.scheduleWishSend(plan)
 
-	err = c.Send("Ваш статус успешно обновлен.")
+	err = c.Send(tr(c, "plan.updated"))
 	if err != nil {
 		return err
 	}
 
-	return ph.askAboutWish(c)
+	return ph.askAboutMood(c, plan)
 }
 
-func (ph *PlanHandler) HandleWakeTimeUpdate(c tele.Context) error {
+func (ph *PlanHandler) completeWakeTimeUpdate(c tele.Context, data *UserData) error {
 	userID := c.Sender().ID
-	wakeTimeStr := c.Text()
-
-	user, err := ph.db.GetUserByID(userID)
-	if err != nil {
-		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
-	}
-
-	utcWakeTime, err := parseTime(wakeTimeStr, user.Tz)
-	if err != nil {
-		return c.Send(err.Error())
-	}
 
 	plan, err := ph.db.CopyPlanForNextDay(userID)
 	if err != nil {
@@ -289,22 +590,22 @@ func (ph *PlanHandler) HandleWakeTimeUpdate(c tele.Context) error {
 			// Create a new plan if no existing plan is found
 			plan = &Plan{
 				UserID:  userID,
-				Content: "Планы не указаны", // Default content
+				Content: tr(c, "plan.default_content"), // Default content
 			}
 		} else {
 			ph.log.Errorw("failed to get latest plan", "error", err)
-			return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+			return c.Send(tr(c, "common.error"))
 		}
 	}
-	plan.WakeAt = utcWakeTime
+	plan.WakeAt = data.WakeAt
 
 	if err := ph.db.SavePlan(plan); err != nil {
 		ph.log.Errorw("failed to save plan", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашего времени пробуждения. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "plan.wake_time_save_error"))
 	}
 
 	ph.scheduleWishSend(plan)
-	err = c.Send(fmt.Sprintf("Ваше время пробуждения успешно обновлено на %s.", wakeTimeStr))
+	err = c.Send(tr(c, "plan.wake_time_updated_to", c.Text()))
 	if err != nil {
 		return err
 	}
@@ -320,6 +621,11 @@ func (ph *PlanHandler) notifyAboutPlansUpdate(id JobID) {
 		return
 	}
 
+	if user.IsUnreachable {
+		ph.log.Infow("skipping notification for unreachable user", "userID", userID)
+		return
+	}
+
 	// Get the latest plan
 	plan, err := ph.db.GetLatestPlan(userID)
 	if err != nil && err != ErrNotFound {
@@ -328,34 +634,33 @@ func (ph *PlanHandler) notifyAboutPlansUpdate(id JobID) {
 	}
 
 	// Show previous plans first
-	previousPlansMsg := "Пора рассказать о вашем текущем состоянии!"
+	previousPlansMsg := T(user.Lang, "plan.reminder")
 	if err == ErrNotFound || plan == nil {
-		previousPlansMsg += "\n\nУ вас пока нет сохраненного статуса."
+		previousPlansMsg += "\n\n" + T(user.Lang, "plan.reminder_no_plan")
 	} else {
 		// Convert UTC wake time to user's timezone
 		userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
 		localWakeTime := plan.WakeAt.In(userLoc)
-		previousPlansMsg += fmt.Sprintf(
-			"\n\nВаш текущий статус: %s"+
-				"\n\nВремя пробуждения: %s",
-			plan.Content,
-			localWakeTime.Format("15:04"))
+		previousPlansMsg += "\n\n" + T(user.Lang, "plan.reminder_current", plan.Content, localWakeTime.Format("15:04"))
 	}
 
 	// Send previous plans message first
 	_, err = ph.api.Send(tele.ChatID(userID), previousPlansMsg)
 	if err != nil {
+		if markIfUnreachable(ph.db, ph.log, userID, err) {
+			return
+		}
 		ph.log.Errorw("failed to send previous plans", "error", err, "userID", userID)
 		return
 	}
 
 	// Create inline keyboard
 	inlineKeyboard := &tele.ReplyMarkup{}
-	btnKeep := inlineKeyboard.Data(btnKeepPlansText, btnKeepPlansID)
-	btnChangeAll := inlineKeyboard.Data(btnUpdatePlansText, btnUpdatePlansID)
-	btnChangePlans := inlineKeyboard.Data(btnChangePlansText, btnChangePlansID)
-	btnChangeTime := inlineKeyboard.Data(btnChangeWakeTimeText, btnChangeWakeTimeID)
-	btnNoWish := inlineKeyboard.Data(btnNoWishText, btnNoWishID)
+	btnKeep := ph.callbacks.btn(userID, T(user.Lang, btnKeepPlansText), btnKeepPlansID)
+	btnChangeAll := ph.callbacks.btn(userID, T(user.Lang, btnUpdatePlansText), btnUpdatePlansID)
+	btnChangePlans := ph.callbacks.btn(userID, T(user.Lang, btnChangePlansText), btnChangePlansID)
+	btnChangeTime := ph.callbacks.btn(userID, T(user.Lang, btnChangeWakeTimeText), btnChangeWakeTimeID)
+	btnNoWish := ph.callbacks.btn(userID, T(user.Lang, btnNoWishText), btnNoWishID)
 	inlineKeyboard.Inline(
 		inlineKeyboard.Row(btnKeep),
 		inlineKeyboard.Row(btnChangeAll),
@@ -364,8 +669,11 @@ func (ph *PlanHandler) notifyAboutPlansUpdate(id JobID) {
 		inlineKeyboard.Row(btnNoWish),
 	)
 
-	_, err = ph.api.Send(tele.ChatID(userID), "Что вы хотите сделать?", inlineKeyboard)
+	_, err = ph.api.Send(tele.ChatID(userID), T(user.Lang, "plan.reminder_actions"), inlineKeyboard)
 	if err != nil {
+		if markIfUnreachable(ph.db, ph.log, userID, err) {
+			return
+		}
 		ph.log.Errorw("failed to send plan reminder", "error", err, "userID", userID)
 	}
 
@@ -383,83 +691,61 @@ func (ph *PlanHandler) notifyAboutPlansUpdate(id JobID) {
 	ph.schedulePlanReminder(user)
 }
 
-func (ph *PlanHandler) HandleNotificationTimeInput(c tele.Context) error {
+// saveNotifyTime stores the accepted notification time and reschedules the reminder.
+// The returned error is a message for the user.
+func (ph *PlanHandler) saveNotifyTime(c tele.Context, data *UserData) (*User, error) {
 	userID := c.Sender().ID
-	notificationTimeStr := c.Text()
 
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
 		ph.log.Errorw("failed to load user", "error", err, "userID", userID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
-	}
-
-	if strings.ToLower(notificationTimeStr) == "отключить" {
-		user.NotifyAt = time.Time{} // Set to zero time to indicate notifications are disabled
-	} else {
-		notifyAtUTC, err := parseTime(notificationTimeStr, user.Tz)
-		if err != nil {
-			return c.Send(err.Error())
-		}
-		user.NotifyAt = notifyAtUTC
+		return nil, errors.New(tr(c, "common.error"))
 	}
 
+	user.NotifyAt = data.NotifyAt
 	if err := ph.db.SaveUser(user); err != nil {
 		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return nil, errors.New(tr(c, "common.save_error"))
 	}
 
 	ph.schedulePlanReminder(user)
-	ph.stateMan.ClearState(userID)
+	return user, nil
+}
+
+func (ph *PlanHandler) completeNotifySetup(c tele.Context, data *UserData) error {
+	user, err := ph.saveNotifyTime(c, data)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
 	// Inform user about notification settings
 	var notificationMsg string
 	if user.NotifyAt.IsZero() {
-		notificationMsg = "Уведомления о статусе отключены."
+		notificationMsg = tr(c, "plan.notifications_disabled")
 	} else {
-		notificationMsg = fmt.Sprintf("Я буду напоминать вам обновить статус каждый день в %s.", notificationTimeStr)
+		notificationMsg = tr(c, "plan.notifications_daily", c.Text())
 	}
 
-	err = c.Send(fmt.Sprintf("Отлично! Регистрация завершена. %s", notificationMsg))
+	err = c.Send(tr(c, "plan.registration_done", notificationMsg))
 	if err != nil {
 		return err
 	}
 
-	ph.stateMan.SetState(userID, StateAwaitingPlans)
-	return ph.askAboutPlans(c)
+	return ph.flows.Start(c, flowPlans, &UserData{})
 }
 
-func (ph *PlanHandler) HandleNotificationTimeUpdate(c tele.Context) error {
-	userID := c.Sender().ID
-	notificationTimeStr := c.Text()
-
-	user, err := ph.db.GetUserByID(userID)
+func (ph *PlanHandler) completeNotifyTimeUpdate(c tele.Context, data *UserData) error {
+	user, err := ph.saveNotifyTime(c, data)
 	if err != nil {
-		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
-	}
-
-	if strings.ToLower(notificationTimeStr) == "отключить" {
-		user.NotifyAt = time.Time{} // Set to zero time to indicate notifications are disabled
-	} else {
-		notifyAtUTC, err := parseTime(notificationTimeStr, user.Tz)
-		if err != nil {
-			return c.Send(err.Error())
-		}
-		user.NotifyAt = notifyAtUTC
-	}
-
-	if err := ph.db.SaveUser(user); err != nil {
-		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return c.Send(err.Error())
 	}
 
-	ph.schedulePlanReminder(user)
-	ph.stateMan.SetState(userID, StateSuggestActions)
-
+	showMenu(c)
 	if user.NotifyAt.IsZero() {
-		return c.Send("Уведомления о статусе отключены.")
+		return c.Send(tr(c, "plan.notifications_disabled"))
 	}
 
-	return c.Send(fmt.Sprintf("Ваше время уведомления успешно обновлено на %s.", notificationTimeStr))
+	return c.Send(tr(c, "plan.notify_time_updated_to", c.Text()))
 }
 
 func (ph *PlanHandler) ScheduleAllNotifications() {
@@ -470,7 +756,12 @@ func (ph *PlanHandler) ScheduleAllNotifications() {
 	}
 
 	cnt := 0
+	unreachable := make(map[int64]bool)
 	for _, user := range users {
+		if user.IsUnreachable {
+			unreachable[user.ID] = true
+			continue
+		}
 		if !user.IsBanned && !user.NotifyAt.IsZero() {
 			ph.schedulePlanReminder(user)
 			cnt++
@@ -486,6 +777,9 @@ func (ph *PlanHandler) ScheduleAllNotifications() {
 	}
 
 	for _, plan := range plans {
+		if unreachable[plan.UserID] {
+			continue
+		}
 		ph.scheduleWishSend(&plan)
 	}
 
@@ -4,24 +4,69 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 	tele "gopkg.in/telebot.v3"
 )
 
+const (
+	flowRegistration   FlowID = "registration"
+	flowUpdateName     FlowID = "update_name"
+	flowUpdateBio      FlowID = "update_bio"
+	flowUpdateTimezone FlowID = "update_timezone"
+)
+
 type ProfileHandler struct {
-	db       *DB
-	stateMan *StateManager
-	log      *zap.SugaredLogger
+	db        *DB
+	flows     *FlowRunner
+	validator *Validator
+	stateMan  *StateManager
+	callbacks *CallbackCodec
+	log       *zap.SugaredLogger
 }
 
-func NewProfileHandler(db *DB, stateMan *StateManager, log *zap.SugaredLogger) *ProfileHandler {
-	return &ProfileHandler{
-		db:       db,
-		stateMan: stateMan,
-		log:      log,
+func NewProfileHandler(db *DB, flows *FlowRunner, validator *Validator, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *ProfileHandler {
+	ph := &ProfileHandler{
+		db:        db,
+		flows:     flows,
+		validator: validator,
+		stateMan:  stateMan,
+		callbacks: callbacks,
+		log:       log,
 	}
+
+	flows.Register(
+		&Flow{
+			ID: flowRegistration,
+			Steps: []FlowStep{
+				{State: StateAwaitingName, Prompt: "profile.ask_name", Validate: validator.Field(FieldName), Accept: acceptName},
+				{State: StateAwaitingBio, Prompt: "profile.ask_bio", PromptArgs: namePromptArgs, Validate: validator.Field(FieldBio), Accept: acceptBio},
+				{State: StateAwaitingTime, Prompt: "profile.ask_time", Accept: acceptTimezone},
+			},
+			Back:       true,
+			OnComplete: ph.completeRegistration,
+		},
+		&Flow{
+			ID:         flowUpdateName,
+			Steps:      []FlowStep{{State: StateUpdatingName, Prompt: "profile.enter_name", Validate: validator.Field(FieldName), Accept: acceptName}},
+			OnComplete: ph.completeNameUpdate,
+		},
+		&Flow{
+			ID:         flowUpdateBio,
+			Steps:      []FlowStep{{State: StateUpdatingBio, Prompt: "profile.enter_bio", Validate: validator.Field(FieldBio), Accept: acceptBio}},
+			OnComplete: ph.completeBioUpdate,
+		},
+		&Flow{
+			ID:         flowUpdateTimezone,
+			Steps:      []FlowStep{{State: StateUpdatingTimezone, Prompt: "profile.enter_time", Accept: acceptTimezone}},
+			OnComplete: ph.completeTimezoneUpdate,
+		},
+	)
+
+	return ph
 }
 
 func (ph *ProfileHandler) Actions() []string {
@@ -30,73 +75,56 @@ func (ph *ProfileHandler) Actions() []string {
 		btnChangeNameID,
 		btnChangeBioID,
 		btnChangeTimezoneID,
+		btnToggleRedirectID,
+		btnShowBlocksID,
+		btnUnblockID,
+		btnChangeLanguageID,
+		btnSetLanguageID,
 	}
 }
 
 func (ph *ProfileHandler) HandleAction(c tele.Context, action string) error {
-	userID := c.Sender().ID
-
 	switch action {
 	case btnShowProfileID:
 		return ph.HandleShowProfile(c)
 	case btnChangeNameID:
-		ph.stateMan.SetState(userID, StateUpdatingName)
-		return c.Send("Пожалуйста, введите ваше новое имя. Используйте команду /cancel для отмены.")
+		return ph.flows.Start(c, flowUpdateName, nil)
 	case btnChangeBioID:
-		ph.stateMan.SetState(userID, StateUpdatingBio)
-		return c.Send("Пожалуйста, введите ваше новое био. Используйте команду /cancel для отмены.")
+		return ph.flows.Start(c, flowUpdateBio, nil)
 	case btnChangeTimezoneID:
-		ph.stateMan.SetState(userID, StateUpdatingTimezone)
-		return c.Send("Пожалуйста, введите текущее время в формате ЧЧ:ММ. Используйте команду /cancel для отмены.")
+		return ph.flows.Start(c, flowUpdateTimezone, nil)
+	case btnToggleRedirectID:
+		return ph.HandleToggleRedirect(c)
+	case btnShowBlocksID:
+		return ph.HandleShowBlocks(c)
+	case btnUnblockID:
+		return ph.HandleUnblock(c)
+	case btnChangeLanguageID:
+		return ph.HandleChangeLanguage(c)
+	case btnSetLanguageID:
+		return ph.HandleSetLanguage(c)
 	default:
 		ph.log.Errorw("unexpected action for ProfileHandler", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
 func (ph *ProfileHandler) States() []UserState {
-	return []UserState{
-		StateRegistrationStart,
-		StateAwaitingName,
-		StateAwaitingBio,
-		StateAwaitingTime,
-		StateUpdatingName,
-		StateUpdatingBio,
-		StateUpdatingTimezone,
-	}
+	return []UserState{StateRegistrationStart}
 }
 
 func (ph *ProfileHandler) HandleState(c tele.Context, state UserState) error {
 	switch state {
 	case StateRegistrationStart:
 		return ph.HandleStart(c)
-	case StateAwaitingName:
-		return ph.HandleNameInput(c)
-	case StateUpdatingName:
-		return ph.HandleNameUpdate(c)
-	case StateAwaitingBio:
-		return ph.HandleBioInput(c)
-	case StateUpdatingBio:
-		return ph.HandleBioUpdate(c)
-	case StateAwaitingTime:
-		return ph.HandleTimeInput(c)
-	case StateUpdatingTimezone:
-		return ph.HandleTimezoneUpdate(c)
 	default:
 		ph.log.Errorw("unexpected state for ProfileHandler", "state", state)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
 func (ph *ProfileHandler) HandleStart(c tele.Context) error {
-	const welcomeMessage = `Я бот, который поможет вам анализировать свое состояние и обмениваться пожеланиями с другими пользователями. Вот что я умею:
-
-1. Ежедневно сохранять ваш статус и время пробуждения.
-2. Напоминать вам о необходимости обновить статус каждый вечер.
-3. Отправлять ваши сообщения другим пользователям.
-4. Доставлять сообщения от других пользователей в момент вашего пробуждения.
-
-Надеюсь, мы отлично проведем время вместе!`
+	welcomeMessage := tr(c, "profile.welcome")
 
 	userID := c.Sender().ID
 
@@ -104,25 +132,24 @@ func (ph *ProfileHandler) HandleStart(c tele.Context) error {
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		ph.log.Errorw("failed to check user existence", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.error"))
 	}
 	if err != ErrNotFound {
 		ph.stateMan.ClearState(userID)
-		welcomeBack := fmt.Sprintf("С возвращением, %s! Вы уже зарегистрированы.", user.Name)
+		welcomeBack := tr(c, "profile.welcome_back", user.Name)
 		fullMessage := welcomeBack + "\n\n" + welcomeMessage
 		return c.Send(fullMessage)
 	}
 
 	// Start registration process
-	ph.stateMan.SetState(userID, StateAwaitingName)
-	fullMessage := "Добро пожаловать! Давайте зарегистрируем вас. Но сначала, позвольте рассказать о моих возможностях.\n\n"
-	fullMessage += welcomeMessage
+	fullMessage := tr(c, "profile.welcome_new") + "\n\n" + welcomeMessage
 	err = c.Send(fullMessage)
 	if err != nil {
 		return err
 	}
 
-	return c.Send("Теперь давайте начнем регистрацию. Как вас зовут? Можно указать настоящее имя или любое прозвище.")
+	link := parseDeepLink(c.Message().Payload)
+	return ph.flows.Start(c, flowRegistration, &UserData{ReferrerID: ph.referralOwner(link.referralCode)})
 }
 
 func (ph *ProfileHandler) HandleShowProfile(c tele.Context) error {
@@ -131,18 +158,28 @@ func (ph *ProfileHandler) HandleShowProfile(c tele.Context) error {
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
 		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка при загрузке вашего профиля. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "profile.load_error"))
 	}
 
 	plan, err := ph.db.GetLatestPlan(userID)
 	if err != nil && err != ErrNotFound {
 		ph.log.Errorw("failed to load latest plan", "error", err)
-		return c.Send("Извините, произошла ошибка при загрузке вашего статуса. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "profile.plan_load_error"))
 	}
 
 	userLoc := time.FixedZone("User Timezone", int(user.Tz)*60)
-	localWakeTime := "Не установлено"
-	localNotifyTime := "Отключено"
+	localWakeTime := tr(c, "profile.not_set")
+	localNotifyTime := tr(c, "profile.disabled")
+	redirect := tr(c, "profile.disabled")
+	digest := tr(c, "profile.disabled")
+
+	if user.AllowRedirect {
+		redirect = tr(c, "profile.enabled")
+	}
+
+	if user.DigestEnabled {
+		digest = tr(c, "profile.digest_on", tr(c, weekdayNames[user.DigestDay]))
+	}
 
 	if !user.NotifyAt.IsZero() {
 		localNotifyTime = user.NotifyAt.In(userLoc).Format("15:04")
@@ -152,85 +189,168 @@ func (ph *ProfileHandler) HandleShowProfile(c tele.Context) error {
 		localWakeTime = plan.WakeAt.In(userLoc).Format("15:04")
 	}
 
-	profileMsg := fmt.Sprintf("Ваш профиль:\n\n"+
-		"Имя: %s\n"+
-		"Био: %s\n"+
-		"Часовой пояс: UTC%+d\n"+
-		"Время уведомления: %s\n"+
-		"Время пробуждения: %s\n",
-		user.Name, user.Bio, user.Tz/60, localNotifyTime, localWakeTime)
-
+	currentPlan := tr(c, "profile.not_set")
 	if plan != nil {
-		profileMsg += fmt.Sprintf("Текущий статус: %s", plan.Content)
-	} else {
-		profileMsg += "Текущий статус: Не установлен"
+		currentPlan = plan.Content
 	}
 
-	ph.stateMan.SetState(userID, StateSuggestActions)
-	return c.Send(profileMsg)
-}
+	referrals, err := ph.db.CountReferrals(userID)
+	if err != nil {
+		ph.log.Errorw("failed to count referrals", "error", err)
+		return c.Send(tr(c, "profile.load_error"))
+	}
 
-func (ph *ProfileHandler) HandleNameInput(c tele.Context) error {
-	const msg = "Теперь, пожалуйста, расскажите немного о себе. " +
-		"Можете написать, кем работаете или на кого учитесь, " +
-		"как любите проводить свободное время, о чем мечтаете. " +
-		"Что угодно, что поможет другим лучше понять вас как человека и вашу жизнь."
+	profileMsg := tr(c, "profile.summary",
+		user.Name, user.Bio, user.Tz/60, localNotifyTime, localWakeTime, redirect, digest,
+		T(user.Lang, "lang.name"), referrals, currentPlan)
 
-	userID := c.Sender().ID
-	userData, _ := ph.stateMan.GetUserData(userID)
-	userData.Name = c.Text()
-	ph.stateMan.SetUserData(userID, userData)
-	ph.stateMan.SetState(userID, StateAwaitingBio)
-	return c.Send("Приятно познакомиться, " + userData.Name + "! " + msg)
+	showMenu(c)
+	return c.Send(profileMsg)
 }
 
-func (ph *ProfileHandler) HandleNameUpdate(c tele.Context) error {
+func (ph *ProfileHandler) HandleToggleRedirect(c tele.Context) error {
 	userID := c.Sender().ID
-	newName := c.Text()
 
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
 		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.error"))
 	}
 
-	user.Name = newName
+	user.AllowRedirect = !user.AllowRedirect
 	if err := ph.db.SaveUser(user); err != nil {
 		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.save_error"))
+	}
+
+	showMenu(c)
+	if user.AllowRedirect {
+		return c.Send(tr(c, "profile.redirect_on"))
 	}
 
-	ph.stateMan.SetState(userID, StateSuggestActions)
-	return c.Send(fmt.Sprintf("Ваше имя успешно обновлено на %s.", newName))
+	return c.Send(tr(c, "profile.redirect_off"))
 }
 
-func (ph *ProfileHandler) HandleBioInput(c tele.Context) error {
-	userID := c.Sender().ID
-	userData, _ := ph.stateMan.GetUserData(userID)
-	userData.Bio = c.Text()
-	ph.stateMan.SetUserData(userID, userData)
-	ph.stateMan.SetState(userID, StateAwaitingTime)
-	return c.Send("Отлично! Наконец, скажите, который сейчас у вас час? (Пожалуйста, используйте формат ЧЧ:ММ)")
+func (ph *ProfileHandler) HandleChangeLanguage(c tele.Context) error {
+	inlineKeyboard := &tele.ReplyMarkup{}
+	langs := Languages()
+	rows := make([]tele.Row, 0, len(langs))
+	for _, lang := range langs {
+		rows = append(rows, inlineKeyboard.Row(ph.callbacks.senderBtn(c, T(lang, "lang.name"), btnSetLanguageID, lang)))
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return c.Send(tr(c, "profile.choose_language"), inlineKeyboard)
 }
 
-func (ph *ProfileHandler) HandleBioUpdate(c tele.Context) error {
+func (ph *ProfileHandler) HandleSetLanguage(c tele.Context) error {
 	userID := c.Sender().ID
-	newBio := c.Text()
+
+	data := strings.Split(c.Data(), "|")
+	if len(data) != 2 || !slices.Contains(Languages(), data[1]) {
+		return c.Send(tr(c, "common.invalid_data"))
+	}
+	lang := data[1]
 
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
-		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		ph.log.Errorw("failed to load user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
 	}
 
-	user.Bio = newBio
+	user.Lang = lang
 	if err := ph.db.SaveUser(user); err != nil {
-		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		ph.log.Errorw("failed to save user", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.save_error"))
 	}
+	c.Set(langContextKey, lang)
 
-	ph.stateMan.SetState(userID, StateSuggestActions)
-	return c.Send("Ваше био успешно обновлено.")
+	showMenu(c)
+	return c.Edit(tr(c, "profile.language_changed"))
+}
+
+func (ph *ProfileHandler) blocksMarkup(c tele.Context, blocks []Block) *tele.ReplyMarkup {
+	inlineKeyboard := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(blocks))
+	for _, block := range blocks {
+		btnUnblock := ph.callbacks.senderBtn(c, tr(c, btnUnblockText, block.Label), btnUnblockID, fmt.Sprintf("%d", block.ID))
+		rows = append(rows, inlineKeyboard.Row(btnUnblock))
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return inlineKeyboard
+}
+
+func (ph *ProfileHandler) HandleShowBlocks(c tele.Context) error {
+	userID := c.Sender().ID
+
+	blocks, err := ph.db.GetBlocks(userID)
+	if err != nil {
+		ph.log.Errorw("failed to load blocks", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if len(blocks) == 0 {
+		showMenu(c)
+		return c.Send(tr(c, "profile.no_blocks"))
+	}
+
+	return c.Send(tr(c, "profile.blocks"), ph.blocksMarkup(c, blocks))
+}
+
+func (ph *ProfileHandler) HandleUnblock(c tele.Context) error {
+	userID := c.Sender().ID
+
+	blockID, err := getButtonID(c, "profile.invalid_block_id")
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	err = ph.db.UnblockUser(userID, uint(blockID))
+	if err != nil && err != ErrNotFound {
+		ph.log.Errorw("failed to unblock user", "error", err, "userID", userID, "blockID", blockID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	blocks, err := ph.db.GetBlocks(userID)
+	if err != nil {
+		ph.log.Errorw("failed to load blocks", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if len(blocks) == 0 {
+		err = c.Edit(tr(c, "profile.no_more_blocks"))
+	} else {
+		err = c.Edit(c.Message().Text, ph.blocksMarkup(c, blocks))
+	}
+	if err != nil {
+		ph.log.Warnw("failed to update blocks message", "err", err)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: tr(c, "profile.unblocked")})
+}
+
+func namePromptArgs(c tele.Context, data *UserData) []any {
+	return []any{data.Name}
+}
+
+func acceptName(c tele.Context, data *UserData) error {
+	data.Name = strings.TrimSpace(c.Text())
+	return nil
+}
+
+func acceptBio(c tele.Context, data *UserData) error {
+	data.Bio = strings.TrimSpace(c.Text())
+	return nil
+}
+
+func acceptTimezone(c tele.Context, data *UserData) error {
+	tzOffset, err := getTimeZoneOffset(c)
+	if err != nil {
+		return errors.New(tr(c, "time.invalid_format"))
+	}
+	data.Tz = tzOffset
+	return nil
 }
 
 func getTimeZoneOffset(c tele.Context) (int32, error) {
@@ -247,51 +367,103 @@ func getTimeZoneOffset(c tele.Context) (int32, error) {
 	return tzOffset, nil
 }
 
-func (ph *ProfileHandler) HandleTimeInput(c tele.Context) error {
-	userID := c.Sender().ID
-
-	tzOffset, err := getTimeZoneOffset(c)
-	if err != nil {
-		return c.Send(err.Error())
-	}
-	userData, _ := ph.stateMan.GetUserData(userID)
-
-	// Create new user in database
+func (ph *ProfileHandler) completeRegistration(c tele.Context, data *UserData) error {
 	user := User{
-		ID:   userID,
-		Name: userData.Name,
-		Bio:  userData.Bio,
-		Tz:   tzOffset,
+		ID:   c.Sender().ID,
+		Name: data.Name,
+		Bio:  data.Bio,
+		Tz:   data.Tz,
+		Lang: ctxLang(c),
+
+		ReferrerID: ph.referrer(data.ReferrerID, c.Sender().ID),
 	}
 	if err := ph.db.CreateUser(&user); err != nil {
 		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.save_error"))
 	}
 
-	ph.stateMan.SetState(userID, StateAwaitingNotificationTime)
-	return c.Send("Отлично! Теперь укажите, в какое время вы хотели бы получать напоминание обновить статус? (Используйте формат ЧЧ:ММ или отправьте 'отключить', чтобы отключить уведомления)")
+	return ph.flows.Start(c, flowNotifySetup, &UserData{})
 }
 
-func (ph *ProfileHandler) HandleTimezoneUpdate(c tele.Context) error {
-	userID := c.Sender().ID
+// referralOwner returns the user whose referral code the invite link carries,
+// or 0 if the code is unknown
+func (ph *ProfileHandler) referralOwner(code string) int64 {
+	if code == "" {
+		return 0
+	}
 
-	tzOffset, err := getTimeZoneOffset(c)
+	user, err := ph.db.GetUserByReferralCode(code)
 	if err != nil {
-		return c.Send(err.Error())
+		if err != ErrNotFound {
+			ph.log.Errorw("failed to get referral code owner", "error", err)
+		}
+		return 0
+	}
+
+	return user.ID
+}
+
+// referrer returns the user who shared the invite link the new user came with,
+// or 0 if the link doesn't point to another registered user
+func (ph *ProfileHandler) referrer(referrerID, userID int64) int64 {
+	if referrerID == 0 || referrerID == userID {
+		return 0
 	}
 
+	_, err := ph.db.GetUserByID(referrerID)
+	if err != nil {
+		if err != ErrNotFound {
+			ph.log.Errorw("failed to get referrer", "error", err, "referrerID", referrerID)
+		}
+		return 0
+	}
+
+	return referrerID
+}
+
+// updateUser applies the change to the user and shows the menu after saving it
+func (ph *ProfileHandler) updateUser(c tele.Context, update func(user *User)) error {
+	userID := c.Sender().ID
+
 	user, err := ph.db.GetUserByID(userID)
 	if err != nil {
 		ph.log.Errorw("failed to load user", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return errors.New(tr(c, "common.error"))
 	}
 
-	user.Tz = tzOffset
+	update(user)
 	if err := ph.db.SaveUser(user); err != nil {
 		ph.log.Errorw("failed to save user", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашей информации. Пожалуйста, попробуйте позже.")
+		return errors.New(tr(c, "common.save_error"))
+	}
+
+	showMenu(c)
+	return nil
+}
+
+func (ph *ProfileHandler) completeNameUpdate(c tele.Context, data *UserData) error {
+	err := ph.updateUser(c, func(user *User) { user.Name = data.Name })
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	return c.Send(tr(c, "profile.name_updated", data.Name))
+}
+
+func (ph *ProfileHandler) completeBioUpdate(c tele.Context, data *UserData) error {
+	err := ph.updateUser(c, func(user *User) { user.Bio = data.Bio })
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	return c.Send(tr(c, "profile.bio_updated"))
+}
+
+func (ph *ProfileHandler) completeTimezoneUpdate(c tele.Context, data *UserData) error {
+	err := ph.updateUser(c, func(user *User) { user.Tz = data.Tz })
+	if err != nil {
+		return c.Send(err.Error())
 	}
 
-	ph.stateMan.SetState(userID, StateSuggestActions)
-	return c.Send("Ваш часовой пояс успешно обновлен.")
+	return c.Send(tr(c, "profile.timezone_updated"))
 }
@@ -0,0 +1,197 @@
+package wakey
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type RateClass string
+
+const (
+	RateMessages    RateClass = "messages"
+	RateCallbacks   RateClass = "callbacks"
+	RateWishLookups RateClass = "wish_lookups"
+)
+
+// defaultRateLimits apply to the classes missing in the config
+var defaultRateLimits = map[RateClass]RateLimit{
+	RateMessages:    {Rate: 30, Burst: 20},
+	RateCallbacks:   {Rate: 60, Burst: 30},
+	RateWishLookups: {Rate: 6, Burst: 3},
+}
+
+const (
+	defaultReportAfter  = 20
+	defaultReportWindow = 10 * time.Minute
+	rateCleanupInterval = 10 * time.Minute
+)
+
+// RateDecision is the verdict of the rate limiter on an update
+type RateDecision struct {
+	Allowed bool
+	// RetryAfter is the time until the next update of the class is allowed
+	RetryAfter time.Duration
+	// Notify is set for the first throttled update in a row, so that the user is told once
+	Notify bool
+}
+
+// RateOffense is published when a user keeps hitting the limits
+type RateOffense struct {
+	UserID    int64
+	Class     RateClass // class of the update that crossed the report threshold
+	Throttled int
+	Window    time.Duration
+}
+
+type rateKey struct {
+	userID int64
+	class  RateClass
+}
+
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	notified bool
+}
+
+type offenseRecord struct {
+	since     time.Time
+	throttled int
+	reported  bool
+}
+
+// RateLimiter keeps a token bucket per user and class of updates. A bucket
+// holds up to Burst tokens and refills at Rate tokens per minute, every
+// update takes one.
+type RateLimiter struct {
+	mu          sync.Mutex
+	limits      map[RateClass]RateLimit
+	buckets     map[rateKey]*tokenBucket
+	offenses    map[int64]*offenseRecord
+	reportAfter int
+	window      time.Duration
+	cleanedAt   time.Time
+	offenseSubs *SubscriptionManager[RateOffense]
+}
+
+// NewRateLimiter creates the limiter. Classes and settings missing in the
+// config get the defaults.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	rl := &RateLimiter{
+		limits:      make(map[RateClass]RateLimit),
+		buckets:     make(map[rateKey]*tokenBucket),
+		offenses:    make(map[int64]*offenseRecord),
+		reportAfter: config.ReportAfter,
+		window:      time.Duration(config.ReportWindow) * time.Minute,
+		offenseSubs: NewSubscriptionManager[RateOffense]("rate offense", zap.L().Named("ratelimit").Sugar()),
+	}
+	if rl.reportAfter <= 0 {
+		rl.reportAfter = defaultReportAfter
+	}
+	if rl.window <= 0 {
+		rl.window = defaultReportWindow
+	}
+
+	configured := map[RateClass]RateLimit{
+		RateMessages:    config.Messages,
+		RateCallbacks:   config.Callbacks,
+		RateWishLookups: config.WishLookups,
+	}
+	for class, limit := range configured {
+		if limit.Rate <= 0 || limit.Burst <= 0 {
+			limit = defaultRateLimits[class]
+		}
+		rl.limits[class] = limit
+	}
+
+	return rl
+}
+
+// SubscribeToOffenses returns a channel for notifications about users who keep
+// hitting the limits and an unsubscribe function. A user is reported once per
+// report window.
+func (rl *RateLimiter) SubscribeToOffenses(bufSize int) (<-chan RateOffense, func()) {
+	return rl.offenseSubs.Subscribe(bufSize)
+}
+
+// Allow takes a token from the user's bucket of the class
+func (rl *RateLimiter) Allow(userID int64, class RateClass, now time.Time) RateDecision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.cleanup(now)
+
+	limit := rl.limits[class]
+	key := rateKey{userID: userID, class: class}
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		rl.buckets[key] = bucket
+	}
+
+	perSecond := limit.Rate / 60
+	bucket.tokens = refill(bucket, limit, now)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.notified = false
+		return RateDecision{Allowed: true}
+	}
+
+	decision := RateDecision{
+		RetryAfter: time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second)),
+		Notify:     !bucket.notified,
+	}
+	bucket.notified = true
+	rl.recordOffense(userID, class, now)
+
+	return decision
+}
+
+func refill(bucket *tokenBucket, limit RateLimit, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updated).Seconds()*limit.Rate/60
+	return min(tokens, float64(limit.Burst))
+}
+
+func (rl *RateLimiter) recordOffense(userID int64, class RateClass, now time.Time) {
+	offense, exists := rl.offenses[userID]
+	if !exists || now.Sub(offense.since) > rl.window {
+		offense = &offenseRecord{since: now}
+		rl.offenses[userID] = offense
+	}
+
+	offense.throttled++
+	if offense.throttled >= rl.reportAfter && !offense.reported {
+		offense.reported = true
+		rl.offenseSubs.Notify(RateOffense{
+			UserID:    userID,
+			Class:     class,
+			Throttled: offense.throttled,
+			Window:    rl.window,
+		})
+	}
+}
+
+// cleanup forgets full buckets and old offenses, so that the limiter doesn't
+// grow with every user who has ever written to the bot
+func (rl *RateLimiter) cleanup(now time.Time) {
+	if now.Sub(rl.cleanedAt) < rateCleanupInterval {
+		return
+	}
+	rl.cleanedAt = now
+
+	for key, bucket := range rl.buckets {
+		limit := rl.limits[key.class]
+		if refill(bucket, limit, now) >= float64(limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+	for userID, offense := range rl.offenses {
+		if now.Sub(offense.since) > rl.window {
+			delete(rl.offenses, userID)
+		}
+	}
+}
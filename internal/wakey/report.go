@@ -0,0 +1,485 @@
+package wakey
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReportDays = 7
+	defaultMaxReport  = 92
+	// retentionWindow is the longest retention day of the cohorts, the report
+	// data starts that many days before the period to measure the activity
+	retentionWindow = 30
+
+	defaultWeeklyTime  = "09:00"
+	defaultAnomalyDrop = 30
+	// anomalyMinBase is the smallest value of the previous week a change is
+	// judged against, a few plans more or less in a quiet week aren't anomalies
+	anomalyMinBase = 5
+)
+
+// retentionDays are the days after the signup the cohort retention is measured on
+var retentionDays = []int{1, 7, 30}
+
+// ActivityKind is what an active user did
+type ActivityKind string
+
+const (
+	ActivityPlan ActivityKind = "plan"
+	ActivityWish ActivityKind = "wish"
+)
+
+// Activity is a plan or a wish written by a user. Users who wrote any are active on that day.
+type Activity struct {
+	UserID int64
+	Kind   ActivityKind
+	At     time.Time
+}
+
+// Signup is a user registration
+type Signup struct {
+	UserID   int64
+	At       time.Time
+	Referred bool // came with an invite link
+}
+
+// WishEvent is a wish delivery or a reaction to a delivered wish
+type WishEvent struct {
+	WishID    uint
+	State     WishState
+	At        time.Time
+	WrittenAt time.Time
+}
+
+// ReportData is the raw data the report is built from
+type ReportData struct {
+	TotalUsers   int64
+	Signups      []Signup
+	Activity     []Activity
+	WishEvents   []WishEvent
+	TopReferrers []Referrer
+}
+
+// ReportDay is the breakdown of the report by UTC days
+type ReportDay struct {
+	Day         time.Time
+	ActiveUsers int
+	NewUsers    int
+	Plans       int
+	Wishes      int
+	Delivered   int
+	Liked       int
+	Disliked    int
+	Reported    int
+}
+
+// Retention is the share of the cohort users active on a day after the signup.
+// Users whose day hasn't come yet by the end of the period aren't eligible.
+type Retention struct {
+	Retained int
+	Eligible int
+}
+
+// Percent returns the retained share of the eligible users, or -1 if no user is eligible
+func (r Retention) Percent() float64 {
+	if r.Eligible == 0 {
+		return -1
+	}
+	return float64(r.Retained) * 100 / float64(r.Eligible)
+}
+
+// Cohort is the users who signed up in a week, keyed by the UTC Monday
+type Cohort struct {
+	Week      time.Time
+	Users     int
+	Retention []Retention // on retentionDays
+}
+
+// Report is the activity of the users in a period of UTC days
+type Report struct {
+	From time.Time // first day
+	To   time.Time // the midnight after the last day
+	Days []ReportDay
+
+	TotalUsers    int64
+	NewUsers      int
+	ReferredUsers int
+	ActiveUsers   int
+
+	DAU float64 // average over the days of the period
+	WAU int     // over the last 7 days of the period
+	MAU int     // over the last 30 days of the period
+
+	Plans               int
+	Wishes              int
+	WishesPerActiveUser float64
+
+	// Of the wishes delivered in the period
+	Delivered       int
+	LikedPercent    float64
+	DislikedPercent float64
+	ReportedPercent float64
+	// MedianDeliveryHours is from writing a wish to its delivery
+	MedianDeliveryHours float64
+
+	Cohorts      []Cohort // signup weeks since ReportDataSince
+	TopReferrers []Referrer
+}
+
+// ReportDataSince returns the start of the data needed for the report of the
+// period: the week of the earlier of the period start and the retention window
+// before its end. The cohorts are the signup weeks since then, so the longest
+// retention shows up even in a short report.
+func ReportDataSince(from, to time.Time) time.Time {
+	since := to.AddDate(0, 0, -retentionWindow)
+	if from.Before(since) {
+		since = from
+	}
+	return weekStart(since, time.UTC)
+}
+
+// BuildReport calculates the report of the period [from, to) between UTC
+// midnights from the data starting at ReportDataSince
+func BuildReport(data *ReportData, from, to time.Time) *Report {
+	report := &Report{
+		From:         from,
+		To:           to,
+		TotalUsers:   data.TotalUsers,
+		TopReferrers: data.TopReferrers,
+	}
+
+	days := make(map[time.Time]*ReportDay)
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		report.Days = append(report.Days, ReportDay{Day: day})
+	}
+	for i := range report.Days {
+		days[report.Days[i].Day] = &report.Days[i]
+	}
+	inPeriod := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(to)
+	}
+
+	// Users active on each day of the data
+	activeOn := make(map[time.Time]map[int64]bool)
+	for _, activity := range data.Activity {
+		day := dayStart(activity.At)
+		if activeOn[day] == nil {
+			activeOn[day] = make(map[int64]bool)
+		}
+		activeOn[day][activity.UserID] = true
+
+		if !inPeriod(activity.At) {
+			continue
+		}
+		switch activity.Kind {
+		case ActivityPlan:
+			days[day].Plans++
+			report.Plans++
+		case ActivityWish:
+			days[day].Wishes++
+			report.Wishes++
+		}
+	}
+
+	var totalDAU int
+	for i := range report.Days {
+		report.Days[i].ActiveUsers = len(activeOn[report.Days[i].Day])
+		totalDAU += report.Days[i].ActiveUsers
+	}
+	if len(report.Days) > 0 {
+		report.DAU = float64(totalDAU) / float64(len(report.Days))
+	}
+	report.ActiveUsers = activeUsers(activeOn, from, to)
+	report.WAU = activeUsers(activeOn, to.AddDate(0, 0, -7), to)
+	report.MAU = activeUsers(activeOn, to.AddDate(0, 0, -30), to)
+	if report.ActiveUsers > 0 {
+		report.WishesPerActiveUser = float64(report.Wishes) / float64(report.ActiveUsers)
+	}
+
+	report.addWishEvents(data.WishEvents, days, inPeriod)
+
+	cohortsSince := ReportDataSince(from, to)
+	var signups []Signup
+	for _, signup := range data.Signups {
+		if !signup.At.Before(cohortsSince) && signup.At.Before(to) {
+			signups = append(signups, signup)
+		}
+		if !inPeriod(signup.At) {
+			continue
+		}
+		days[dayStart(signup.At)].NewUsers++
+		report.NewUsers++
+		if signup.Referred {
+			report.ReferredUsers++
+		}
+	}
+	report.Cohorts = buildCohorts(signups, activeOn, to)
+
+	return report
+}
+
+// addWishEvents counts the deliveries and the reactions by days, the rates
+// are of the wishes delivered in the period whenever the reaction came
+func (report *Report) addWishEvents(events []WishEvent, days map[time.Time]*ReportDay, inPeriod func(time.Time) bool) {
+	delivered := make(map[uint]bool)
+	var deliveryTimes []time.Duration
+	for _, event := range events {
+		if event.State != WishStateSent || !inPeriod(event.At) {
+			continue
+		}
+		delivered[event.WishID] = true
+		deliveryTimes = append(deliveryTimes, event.At.Sub(event.WrittenAt))
+		days[dayStart(event.At)].Delivered++
+	}
+	report.Delivered = len(delivered)
+
+	var liked, disliked, reported int
+	for _, event := range events {
+		if !inPeriod(event.At) || !delivered[event.WishID] {
+			continue
+		}
+		day := days[dayStart(event.At)]
+		switch event.State {
+		case WishStateLiked:
+			day.Liked++
+			liked++
+		case WishStateDisliked:
+			day.Disliked++
+			disliked++
+		case WishStateReported:
+			day.Reported++
+			reported++
+		}
+	}
+
+	if report.Delivered > 0 {
+		report.LikedPercent = float64(liked) * 100 / float64(report.Delivered)
+		report.DislikedPercent = float64(disliked) * 100 / float64(report.Delivered)
+		report.ReportedPercent = float64(reported) * 100 / float64(report.Delivered)
+	}
+	report.MedianDeliveryHours = median(deliveryTimes).Hours()
+}
+
+func buildCohorts(signups []Signup, activeOn map[time.Time]map[int64]bool, to time.Time) []Cohort {
+	var cohorts []Cohort
+	byWeek := make(map[time.Time]int)
+	for _, signup := range signups {
+		week := weekStart(signup.At, time.UTC)
+		idx, exists := byWeek[week]
+		if !exists {
+			idx = len(cohorts)
+			byWeek[week] = idx
+			cohorts = append(cohorts, Cohort{Week: week, Retention: make([]Retention, len(retentionDays))})
+		}
+
+		cohort := &cohorts[idx]
+		cohort.Users++
+		for i, n := range retentionDays {
+			day := dayStart(signup.At).AddDate(0, 0, n)
+			if !day.Before(to) {
+				continue
+			}
+			cohort.Retention[i].Eligible++
+			if activeOn[day][signup.UserID] {
+				cohort.Retention[i].Retained++
+			}
+		}
+	}
+
+	slices.SortFunc(cohorts, func(a, b Cohort) int {
+		return a.Week.Compare(b.Week)
+	})
+	return cohorts
+}
+
+// activeUsers returns the number of users active in [from, to)
+func activeUsers(activeOn map[time.Time]map[int64]bool, from, to time.Time) int {
+	users := make(map[int64]bool)
+	for day, active := range activeOn {
+		if day.Before(from) || !day.Before(to) {
+			continue
+		}
+		for userID := range active {
+			users[userID] = true
+		}
+	}
+	return len(users)
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// dayStart returns the UTC midnight of the day
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// WriteDailyCSV writes the day by day breakdown as CSV with a header row
+func (report *Report) WriteDailyCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "active_users", "new_users", "plans", "wishes", "delivered", "liked", "disliked", "reported"})
+	for _, day := range report.Days {
+		cw.Write([]string{
+			day.Day.Format(time.DateOnly),
+			strconv.Itoa(day.ActiveUsers),
+			strconv.Itoa(day.NewUsers),
+			strconv.Itoa(day.Plans),
+			strconv.Itoa(day.Wishes),
+			strconv.Itoa(day.Delivered),
+			strconv.Itoa(day.Liked),
+			strconv.Itoa(day.Disliked),
+			strconv.Itoa(day.Reported),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCohortsCSV writes the retention of the signup weeks as CSV with a
+// header row. The percent is empty while no user of the cohort is eligible.
+func (report *Report) WriteCohortsCSV(w io.Writer) error {
+	header := []string{"week", "users"}
+	for _, n := range retentionDays {
+		header = append(header, fmt.Sprintf("d%d_retained", n), fmt.Sprintf("d%d_eligible", n), fmt.Sprintf("d%d_percent", n))
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, cohort := range report.Cohorts {
+		record := []string{cohort.Week.Format(time.DateOnly), strconv.Itoa(cohort.Users)}
+		for _, retention := range cohort.Retention {
+			percent := ""
+			if retention.Eligible > 0 {
+				percent = strconv.FormatFloat(retention.Percent(), 'f', 2, 64)
+			}
+			record = append(record, strconv.Itoa(retention.Retained), strconv.Itoa(retention.Eligible), percent)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var ErrInvalidReportPeriod = errors.New("invalid report period")
+
+// ParseReportPeriod parses the /stat arguments: nothing for the default
+// number of days, a number of days or the first and the last day as
+// YYYY-MM-DD. The periods end with today by default and can't be longer than
+// maxDays.
+func ParseReportPeriod(args string, now time.Time, defaultDays, maxDays int) (time.Time, time.Time, error) {
+	if defaultDays <= 0 {
+		defaultDays = defaultReportDays
+	}
+	if maxDays <= 0 {
+		maxDays = defaultMaxReport
+	}
+	to := dayStart(now).AddDate(0, 0, 1)
+
+	fields := strings.Fields(args)
+	var from time.Time
+	switch len(fields) {
+	case 0:
+		from = to.AddDate(0, 0, -defaultDays)
+	case 1:
+		days, err := strconv.Atoi(fields[0])
+		if err != nil || days <= 0 {
+			return time.Time{}, time.Time{}, ErrInvalidReportPeriod
+		}
+		from = to.AddDate(0, 0, -days)
+	case 2:
+		first, err := time.Parse(time.DateOnly, fields[0])
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidReportPeriod
+		}
+		last, err := time.Parse(time.DateOnly, fields[1])
+		if err != nil || last.Before(first) {
+			return time.Time{}, time.Time{}, ErrInvalidReportPeriod
+		}
+		from, to = first, last.AddDate(0, 0, 1)
+	default:
+		return time.Time{}, time.Time{}, ErrInvalidReportPeriod
+	}
+
+	if to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		return time.Time{}, time.Time{}, ErrInvalidReportPeriod
+	}
+	return from, to, nil
+}
+
+// MetricChange compares a metric of the week with the previous week
+type MetricChange struct {
+	Key           string // catalog key of the metric name
+	Current       float64
+	Previous      float64
+	Precision     int  // digits after the decimal point
+	LowerIsBetter bool // a growth is a change for the worse
+	Anomaly       bool // changed for the worse by at least the threshold
+}
+
+// DeltaPercent returns the change relative to the previous week
+// or false if there was nothing to compare with
+func (m MetricChange) DeltaPercent() (float64, bool) {
+	if m.Previous == 0 {
+		return 0, false
+	}
+	return (m.Current - m.Previous) / m.Previous * 100, true
+}
+
+// CompareReports compares the metrics of two reports. A metric that changed
+// for the worse by threshold percent or more is marked as an anomaly.
+func CompareReports(current, previous *Report, threshold float64) []MetricChange {
+	changes := []MetricChange{
+		{Key: "report.metric_new_users", Current: float64(current.NewUsers), Previous: float64(previous.NewUsers)},
+		{Key: "report.metric_active_users", Current: float64(current.ActiveUsers), Previous: float64(previous.ActiveUsers)},
+		{Key: "report.metric_dau", Current: current.DAU, Previous: previous.DAU, Precision: 1},
+		{Key: "report.metric_plans", Current: float64(current.Plans), Previous: float64(previous.Plans)},
+		{Key: "report.metric_wishes", Current: float64(current.Wishes), Previous: float64(previous.Wishes)},
+		{Key: "report.metric_wishes_per_user", Current: current.WishesPerActiveUser, Previous: previous.WishesPerActiveUser, Precision: 2},
+		{Key: "report.metric_delivered", Current: float64(current.Delivered), Previous: float64(previous.Delivered)},
+		{Key: "report.metric_liked", Current: current.LikedPercent, Previous: previous.LikedPercent, Precision: 1},
+		{Key: "report.metric_reported", Current: current.ReportedPercent, Previous: previous.ReportedPercent, Precision: 1, LowerIsBetter: true},
+		{Key: "report.metric_delivery_hours", Current: current.MedianDeliveryHours, Previous: previous.MedianDeliveryHours, Precision: 1, LowerIsBetter: true},
+	}
+
+	for i := range changes {
+		delta, ok := changes[i].DeltaPercent()
+		if !ok || changes[i].Previous < anomalyMinBase {
+			continue
+		}
+		if changes[i].LowerIsBetter {
+			delta = -delta
+		}
+		changes[i].Anomaly = delta <= -threshold
+	}
+	return changes
+}
+
+// NextWeeklyReport returns the next time the weekly report is sent at,
+// the time of day is in UTC
+func NextWeeklyReport(day time.Weekday, hour, minute int, now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, time.UTC)
+	next = next.AddDate(0, 0, (int(day)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
@@ -0,0 +1,339 @@
+package wakey
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// markdownEscaper escapes the user text put into Markdown messages
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// reportExportArg makes /stat send the report tables as CSV files
+const reportExportArg = "export"
+
+// ReportHandler shows the admin the reports on the bot usage
+// and sends them the weekly report
+type ReportHandler struct {
+	db          *DB
+	api         BotAPI
+	reportSched Scheduler
+	config      ReportConfig
+	adm         int64
+	log         *zap.SugaredLogger
+
+	weeklyDay    time.Weekday
+	weeklyHour   int
+	weeklyMinute int
+}
+
+func NewReportHandler(db *DB, api BotAPI, reportSched Scheduler, config ReportConfig, log *zap.SugaredLogger, adminID int64) *ReportHandler {
+	rh := &ReportHandler{
+		db:          db,
+		api:         api,
+		reportSched: reportSched,
+		config:      config,
+		adm:         adminID,
+		log:         log,
+	}
+
+	rh.parseWeeklySchedule()
+	reportSched.SetJobFunc(rh.SendWeeklyReport)
+	rh.scheduleWeeklyReports()
+
+	return rh
+}
+
+func (rh *ReportHandler) Actions() []string {
+	return nil
+}
+
+func (rh *ReportHandler) HandleAction(c tele.Context, action string) error {
+	rh.log.Errorw("unexpected action for ReportHandler", "action", action)
+	return c.Send(tr(c, "common.unknown_action"))
+}
+
+func (rh *ReportHandler) States() []UserState {
+	return []UserState{StatePrintStats}
+}
+
+func (rh *ReportHandler) HandleState(c tele.Context, state UserState) error {
+	if c.Sender().ID != rh.adm {
+		return nil
+	}
+
+	switch state {
+	case StatePrintStats:
+		return rh.printReport(c)
+	default:
+		rh.log.Errorw("unexpected state for ReportHandler", "state", state)
+		return c.Send(tr(c, "common.unknown_action"))
+	}
+}
+
+func (rh *ReportHandler) printReport(c tele.Context) error {
+	maxDays := rh.config.MaxDays
+	if maxDays <= 0 {
+		maxDays = defaultMaxReport
+	}
+
+	args := c.Message().Payload
+	fields := strings.Fields(args)
+	export := len(fields) > 0 && fields[0] == reportExportArg
+	if export {
+		args = strings.Join(fields[1:], " ")
+	}
+
+	from, to, err := ParseReportPeriod(args, time.Now(), rh.config.DefaultDays, maxDays)
+	if err != nil {
+		return c.Send(trn(c, "report.invalid_period", int64(maxDays), maxDays))
+	}
+
+	data, err := rh.db.GetReportData(ReportDataSince(from, to), to)
+	if err != nil {
+		rh.log.Errorw("failed to get report data", "error", err)
+		return c.Send(tr(c, "report.error"))
+	}
+	report := BuildReport(data, from, to)
+
+	if export {
+		return rh.exportReport(c, report)
+	}
+
+	if err := c.Send(reportSummary(c, report), tele.ModeMarkdown); err != nil {
+		return err
+	}
+	return c.Send(reportTables(c, report), tele.ModeMarkdown)
+}
+
+// exportReport sends the day by day breakdown and the cohorts as CSV files
+func (rh *ReportHandler) exportReport(c tele.Context, report *Report) error {
+	period := reportDate(report.From) + "_" + reportDate(report.To.AddDate(0, 0, -1))
+	exports := []struct {
+		name    string
+		caption string
+		write   func(w io.Writer) error
+	}{
+		{"daily", "report.export_daily", report.WriteDailyCSV},
+		{"cohorts", "report.export_cohorts", report.WriteCohortsCSV},
+	}
+
+	for _, export := range exports {
+		var buf bytes.Buffer
+		if err := export.write(&buf); err != nil {
+			rh.log.Errorw("failed to write report CSV", "error", err, "table", export.name)
+			return c.Send(tr(c, "report.error"))
+		}
+
+		document := &tele.Document{
+			File:     tele.FromReader(&buf),
+			FileName: fmt.Sprintf("wakey-%s-%s.csv", export.name, period),
+			MIME:     "text/csv",
+			Caption:  tr(c, export.caption, reportDate(report.From), reportDate(report.To.AddDate(0, 0, -1))),
+		}
+		if err := c.Send(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWeeklySchedule reads the weekday and the time of the weekly report
+// from the config and falls back to the defaults on invalid values
+func (rh *ReportHandler) parseWeeklySchedule() {
+	rh.weeklyDay = time.Monday
+	if rh.config.WeeklyDay != "" {
+		day, ok := parseWeekday(rh.config.WeeklyDay)
+		if ok {
+			rh.weeklyDay = day
+		} else {
+			rh.log.Errorw("invalid weekly report day, using Monday", "day", rh.config.WeeklyDay)
+		}
+	}
+
+	weeklyTime := rh.config.WeeklyTime
+	if weeklyTime == "" {
+		weeklyTime = defaultWeeklyTime
+	}
+	at, err := time.Parse("15:04", weeklyTime)
+	if err != nil {
+		rh.log.Errorw("invalid weekly report time, using "+defaultWeeklyTime, "time", weeklyTime)
+		at, _ = time.Parse("15:04", defaultWeeklyTime)
+	}
+	rh.weeklyHour, rh.weeklyMinute = at.Hour(), at.Minute()
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for _, day := range weekdayOrder {
+		if strings.EqualFold(day.String(), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// scheduleWeeklyReports schedules the weekly report for every admin
+func (rh *ReportHandler) scheduleWeeklyReports() {
+	if rh.config.DisableWeekly {
+		return
+	}
+	for _, adminID := range rh.admins() {
+		rh.scheduleWeeklyReport(adminID)
+	}
+}
+
+func (rh *ReportHandler) scheduleWeeklyReport(adminID int64) {
+	next := NextWeeklyReport(rh.weeklyDay, rh.weeklyHour, rh.weeklyMinute, time.Now())
+	rh.reportSched.Schedule(next, JobID(adminID))
+	rh.log.Infow("scheduled weekly report", "adminID", adminID, "reportAt", next)
+}
+
+func (rh *ReportHandler) admins() []int64 {
+	if rh.adm == 0 {
+		return nil
+	}
+	return []int64{rh.adm}
+}
+
+// SendWeeklyReport sends the admin the last week compared with the week before
+// and schedules the next report
+func (rh *ReportHandler) SendWeeklyReport(id JobID) {
+	adminID := int64(id)
+	defer rh.scheduleWeeklyReport(adminID)
+
+	to := dayStart(time.Now())
+	from := to.AddDate(0, 0, -7)
+	prevFrom := from.AddDate(0, 0, -7)
+
+	data, err := rh.db.GetReportData(ReportDataSince(prevFrom, from), to)
+	if err != nil {
+		rh.log.Errorw("failed to get weekly report data", "error", err, "adminID", adminID)
+		return
+	}
+
+	threshold := rh.config.AnomalyDrop
+	if threshold <= 0 {
+		threshold = defaultAnomalyDrop
+	}
+	current := BuildReport(data, from, to)
+	previous := BuildReport(data, prevFrom, from)
+	changes := CompareReports(current, previous, threshold)
+
+	message := formatWeeklyReport(rh.db.GetUserLang(adminID), current, previous, changes)
+	if _, err := rh.api.Send(tele.ChatID(adminID), message, tele.ModeMarkdown); err != nil {
+		rh.log.Errorw("failed to send weekly report", "error", err, "adminID", adminID)
+	}
+}
+
+func formatWeeklyReport(lang string, current, previous *Report, changes []MetricChange) string {
+	message := T(lang, "report.weekly_title", reportDate(current.From), reportDate(current.To.AddDate(0, 0, -1))) + "\n" +
+		T(lang, "report.weekly_previous", reportDate(previous.From), reportDate(previous.To.AddDate(0, 0, -1)))
+
+	var anomalies []string
+	for _, change := range changes {
+		if change.Anomaly {
+			anomalies = append(anomalies, T(lang, change.Key)+" "+formatDelta(lang, change))
+		}
+	}
+	if len(anomalies) > 0 {
+		message += "\n\n" + T(lang, "report.weekly_anomalies", strings.Join(anomalies, ", "))
+	}
+
+	message += "\n"
+	for _, change := range changes {
+		key := "report.weekly_metric"
+		if change.Anomaly {
+			key = "report.weekly_anomaly"
+		}
+		message += "\n" + T(lang, key,
+			T(lang, change.Key),
+			strconv.FormatFloat(change.Previous, 'f', change.Precision, 64),
+			strconv.FormatFloat(change.Current, 'f', change.Precision, 64),
+			formatDelta(lang, change),
+		)
+	}
+
+	return message
+}
+
+// formatDelta returns the signed change in percent or a note if it can't be computed
+func formatDelta(lang string, change MetricChange) string {
+	delta, ok := change.DeltaPercent()
+	switch {
+	case ok:
+		return fmt.Sprintf("%+.1f%%", delta)
+	case change.Current == 0:
+		return T(lang, "report.weekly_unchanged")
+	default:
+		return T(lang, "report.weekly_new")
+	}
+}
+
+func reportSummary(c tele.Context, report *Report) string {
+	days := int64(len(report.Days))
+	message := trn(c, "report.title", days, reportDate(report.From), reportDate(report.To.AddDate(0, 0, -1)), days)
+	message += "\n\n" + tr(c, "report.summary",
+		report.TotalUsers,
+		report.NewUsers,
+		report.ReferredUsers,
+		report.ActiveUsers,
+		report.DAU,
+		report.WAU,
+		report.MAU,
+		report.Plans,
+		report.Wishes,
+		report.WishesPerActiveUser,
+		report.Delivered,
+		report.LikedPercent,
+		report.DislikedPercent,
+		report.ReportedPercent,
+		report.MedianDeliveryHours,
+	)
+
+	if len(report.TopReferrers) > 0 {
+		message += "\n\n" + tr(c, "report.top_referrers")
+		for i, referrer := range report.TopReferrers {
+			message += "\n" + tr(c, "report.top_referrer", i+1, markdownEscaper.Replace(referrer.Name), referrer.UserID, referrer.Referred)
+		}
+	}
+
+	return message
+}
+
+// reportTables returns the day by day breakdown and the cohorts as monospace tables
+func reportTables(c tele.Context, report *Report) string {
+	var daily strings.Builder
+	for _, day := range report.Days {
+		fmt.Fprintf(&daily, "%s %4d %4d %4d %4d %4d %4d\n",
+			day.Day.Format("01-02"), day.ActiveUsers, day.NewUsers, day.Plans, day.Wishes, day.Delivered, day.Liked)
+	}
+	message := tr(c, "report.daily") + "\n```\n" + daily.String() + "```"
+
+	if len(report.Cohorts) == 0 {
+		return message + "\n" + tr(c, "report.no_cohorts")
+	}
+
+	var cohorts strings.Builder
+	for _, cohort := range report.Cohorts {
+		fmt.Fprintf(&cohorts, "%s %5d", cohort.Week.Format("01-02"), cohort.Users)
+		for _, retention := range cohort.Retention {
+			if retention.Eligible == 0 {
+				cohorts.WriteString("     -")
+				continue
+			}
+			fmt.Fprintf(&cohorts, " %4.0f%%", retention.Percent())
+		}
+		cohorts.WriteString("\n")
+	}
+	return message + "\n" + tr(c, "report.cohorts") + "\n```\n" + cohorts.String() + "```"
+}
+
+func reportDate(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
@@ -30,6 +30,9 @@ const (
 	StatePrintStats
 	StateNotifyAll
 	StateWaitingForNotification
+	StateEditingWish
+	StateSearchingJournal
+	StateShowMoodChart
 )
 
 type UserData struct {
@@ -37,8 +40,14 @@ type UserData struct {
 	Name         string
 	Bio          string
 	Plans        string
+	Tz           int32
+	WakeAt       time.Time
+	NotifyAt     time.Time
 	TargetPlanID uint
+	OfferedAt    time.Time // when the target plan was offered, the offer is the user's while the plan keeps it
+	TargetWishID uint
 	AskAboutWish bool
+	ReferrerID   int64
 	LastUpdated  time.Time
 }
 
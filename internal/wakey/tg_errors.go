@@ -0,0 +1,48 @@
+package wakey
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// isUnreachableError reports whether the Telegram error means that messages
+// can't be delivered to the user until they contact the bot again
+func isUnreachableError(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) ||
+		errors.Is(err, tele.ErrNotStartedByUser) ||
+		errors.Is(err, tele.ErrChatNotFound)
+}
+
+// markIfUnreachable marks the user as unreachable if the send error says so.
+// It returns true if the user has been marked.
+func markIfUnreachable(db *DB, log *zap.SugaredLogger, userID int64, err error) bool {
+	if !isUnreachableError(err) {
+		return false
+	}
+
+	log.Infow("user is unreachable", "userID", userID, "error", err)
+
+	if err := db.SetUserUnreachable(userID, true); err != nil {
+		log.Errorw("failed to mark user as unreachable", "error", err, "userID", userID)
+	}
+
+	return true
+}
+
+// reactivateUser marks the unreachable user who contacted the bot again as reachable
+func reactivateUser(db *DB, log *zap.SugaredLogger, user *User) {
+	if !user.IsUnreachable {
+		return
+	}
+
+	log.Infow("user is reachable again", "userID", user.ID)
+
+	if err := db.SetUserUnreachable(user.ID, false); err != nil {
+		log.Errorw("failed to reactivate user", "error", err, "userID", user.ID)
+		return
+	}
+	user.IsUnreachable = false
+}
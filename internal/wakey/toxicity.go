@@ -87,15 +87,58 @@ func (tc *ToxicityChecker) checkWishToxicity(wish *Wish) {
 
 	tc.log.Debugf("Checking toxicity for wish %d", wish.ID)
 
-	score, err := tc.moder.CheckMessage(ctx, wish.Content)
+	// Voice notes, video notes and photos can't be checked by the language model
+	if needsHumanReview(wish) && !wish.NeedsReview {
+		err := tc.db.MarkWishForReview(wish.ID, wish.ContentVersion)
+		if err == ErrStaleContent {
+			tc.log.Debugf("Wish %d was edited during the check", wish.ID)
+			return
+		}
+		if err != nil {
+			tc.log.Errorf("Failed to mark wish %d for review: %v", wish.ID, err)
+		}
+	}
+
+	// Media without a caption has no text to check
+	if wish.Content == "" {
+		err := tc.db.UpdateWishToxicity(wish.ID, wish.ContentVersion, 0)
+		if err != nil && err != ErrStaleContent {
+			tc.log.Errorf("Failed to update toxicity score for wish %d: %v", wish.ID, err)
+		}
+		return
+	}
+
+	result, err := tc.moder.CheckMessage(ctx, wish.Content)
 	if err != nil {
 		tc.log.Errorf("Failed to check toxicity for wish %d: %v", wish.ID, err)
 		return
 	}
 
-	toxicityScore := int16(score * 100)
+	// Keep the detected language and the English translation for delivery
+	if result.Lang != "" {
+		err = tc.db.SetWishLanguage(wish.ID, wish.ContentVersion, result.Lang)
+		if err == ErrStaleContent {
+			tc.log.Debugf("Wish %d was edited during the check", wish.ID)
+			return
+		}
+		if err != nil {
+			tc.log.Errorf("Failed to update language of wish %d: %v", wish.ID, err)
+		}
+		if result.Lang != "en" && result.Translation != "" {
+			err = tc.db.SaveWishTranslation(wish.ID, wish.ContentVersion, "en", result.Translation)
+			if err != nil {
+				tc.log.Errorf("Failed to save translation of wish %d: %v", wish.ID, err)
+			}
+		}
+	}
+
+	toxicityScore := int16(result.Score * 100)
 
-	err = tc.db.UpdateWishToxicity(wish.ID, int(toxicityScore))
+	err = tc.db.UpdateWishToxicity(wish.ID, wish.ContentVersion, int(toxicityScore))
+	if err == ErrStaleContent {
+		tc.log.Debugf("Wish %d was edited during the check", wish.ID)
+		return
+	}
 	if err != nil {
 		tc.log.Errorf("Failed to update toxicity score for wish %d: %v", wish.ID, err)
 		return
@@ -106,3 +149,12 @@ func (tc *ToxicityChecker) checkWishToxicity(wish *Wish) {
 	// Add a small delay between requests to avoid overwhelming the API
 	time.Sleep(100 * time.Millisecond)
 }
+
+func needsHumanReview(wish *Wish) bool {
+	switch wish.MediaType {
+	case MediaVoice, MediaVideoNote, MediaPhoto:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package wakey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+type TranslatorName string
+
+const (
+	TranslatorNone TranslatorName = "none"
+	TranslatorLLM  TranslatorName = "llm"
+	TranslatorStub TranslatorName = "stub"
+)
+
+// Translator translates wishes into the language of the recipient
+type Translator interface {
+	// Translate returns the text translated into the language with the ISO 639-1 code
+	Translate(ctx context.Context, text, lang string) (string, error)
+}
+
+// NewTranslator creates the configured translator. It returns nil if translation is disabled.
+// The LLM translator uses the same model as the moderation.
+func NewTranslator(config TranslationConfig, llmConfig LLMConfig) (Translator, error) {
+	switch config.Provider {
+	case "", TranslatorNone:
+		return nil, nil
+	case TranslatorStub:
+		return StubTranslator{}, nil
+	case TranslatorLLM:
+		return NewLLMTranslator(config, llmConfig)
+	default:
+		return nil, fmt.Errorf("unsupported translator: %s", config.Provider)
+	}
+}
+
+// StubTranslator marks the text with the target language instead of translating it.
+// It lets the delivery flow be tried out locally without a language model.
+type StubTranslator struct{}
+
+func (StubTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	return fmt.Sprintf("[%s] %s", lang, text), nil
+}
+
+type LLMTranslator struct {
+	config     TranslationConfig
+	llm        llms.Model
+	maxRetries int
+}
+
+func NewLLMTranslator(config TranslationConfig, llmConfig LLMConfig) (*LLMTranslator, error) {
+	if config.Prompt == "" {
+		config.Prompt = defaultTranslationPrompt
+	}
+	if err := checkTranslationPrompt(config.Prompt); err != nil {
+		return nil, err
+	}
+
+	llm, err := newLLM(llmConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LLMTranslator{
+		config:     config,
+		llm:        llm,
+		maxRetries: llmConfig.MaxRetries,
+	}, nil
+}
+
+func (lt *LLMTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	if lt.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(lt.config.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	prompt := fmt.Sprintf(lt.config.Prompt, lang)
+	translation, err := generateWithRetries(ctx, lt.llm, lt.maxRetries, prompt, text,
+		llms.WithTemperature(lt.config.Temp),
+		llms.WithMaxTokens(lt.config.MaxTok),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	translation = strings.TrimSpace(translation)
+	if translation == "" {
+		return "", fmt.Errorf("empty translation")
+	}
+
+	return translation, nil
+}
+
+// checkTranslationPrompt makes sure the prompt formats with the language code
+// alone, so Translate never sends "%!(EXTRA ...)" or "%!s(MISSING)" to the model
+func checkTranslationPrompt(prompt string) error {
+	const probe = "\x00lang\x00"
+	formatted := fmt.Sprintf(prompt, probe)
+	if strings.Contains(formatted, "%!") || strings.Count(formatted, probe) != 1 {
+		return errors.New("translation prompt must have a single %s for the language code, write %% for a percent sign")
+	}
+	return nil
+}
+
+// defaultTranslationPrompt is formatted with the ISO 639-1 code of the target language
+const defaultTranslationPrompt = `You are a translator of short personal messages. Translate the message from the user into the language with the ISO 639-1 code "%s".
+
+Keep the tone, the meaning and the emoji of the original. Do not add explanations, notes or quotes. Reply with the translation only.`
@@ -0,0 +1,216 @@
+package wakey
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+type InputField string
+
+const (
+	FieldName  InputField = "name"
+	FieldBio   InputField = "bio"
+	FieldPlans InputField = "plans"
+	FieldWish  InputField = "wish"
+)
+
+// defaultFieldRules apply to the limits missing in the config
+var defaultFieldRules = map[InputField]FieldRules{
+	FieldName:  {MinLen: 1, MaxLen: 64, MinLetters: 1},
+	FieldBio:   {MinLen: 1, MaxLen: 500, MinLetters: 1},
+	FieldPlans: {MinLen: 1, MaxLen: 1000, MinLetters: 1},
+	FieldWish:  {MinLen: 1, MaxLen: 1000, MinLetters: 5},
+}
+
+const (
+	defaultRepeatLimit  = 3
+	defaultRepeatWindow = 24 // hours
+)
+
+// ValidationError explains why the input was rejected
+type ValidationError struct {
+	Field InputField
+	// Reason is the message key of the explanation
+	Reason string
+	// Limit is the number the input didn't fit into, 0 if the reason has none
+	Limit int
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// Message returns the explanation in the language
+func (e *ValidationError) Message(lang string) string {
+	if e.Limit == 0 {
+		return T(lang, e.Reason)
+	}
+	return TN(lang, e.Reason, int64(e.Limit), e.Limit)
+}
+
+type fieldValidator struct {
+	rules      FieldRules
+	disallowed []*regexp.Regexp
+}
+
+// Validator checks the text users enter before it is stored
+type Validator struct {
+	db           *DB
+	fields       map[InputField]fieldValidator
+	repeatLimit  int
+	repeatWindow time.Duration
+}
+
+// NewValidator compiles the validation rules. Limits missing in the config
+// get the default values, the configured ones are kept.
+func NewValidator(config ValidationConfig, db *DB) (*Validator, error) {
+	v := &Validator{
+		db:           db,
+		fields:       make(map[InputField]fieldValidator),
+		repeatLimit:  config.RepeatLimit,
+		repeatWindow: time.Duration(config.RepeatWindow) * time.Hour,
+	}
+	if v.repeatLimit == 0 {
+		v.repeatLimit = defaultRepeatLimit
+	}
+	if v.repeatWindow == 0 {
+		v.repeatWindow = defaultRepeatWindow * time.Hour
+	}
+
+	configured := map[InputField]FieldRules{
+		FieldName:  config.Name,
+		FieldBio:   config.Bio,
+		FieldPlans: config.Plans,
+		FieldWish:  config.Wish,
+	}
+	for field, rules := range configured {
+		defaults := defaultFieldRules[field]
+		if rules.MinLen == 0 {
+			rules.MinLen = defaults.MinLen
+		}
+		if rules.MaxLen == 0 {
+			rules.MaxLen = defaults.MaxLen
+		}
+		if rules.MinLetters == 0 {
+			rules.MinLetters = defaults.MinLetters
+		}
+		if rules.MinLen > rules.MaxLen {
+			return nil, fmt.Errorf("%s: min_len %d is greater than max_len %d", field, rules.MinLen, rules.MaxLen)
+		}
+
+		fv := fieldValidator{rules: rules}
+		for _, pattern := range rules.Disallowed {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid disallowed pattern %q: %w", field, pattern, err)
+			}
+			fv.disallowed = append(fv.disallowed, re)
+		}
+		v.fields[field] = fv
+	}
+
+	return v, nil
+}
+
+// Validate checks the text of the field. Captions of media messages may be
+// empty, so only the maximum length and the disallowed patterns apply to them.
+func (v *Validator) Validate(field InputField, text string, media bool) *ValidationError {
+	fv := v.fields[field]
+	text = strings.TrimSpace(text)
+	reject := func(reason string, limit int) *ValidationError {
+		return &ValidationError{Field: field, Reason: reason, Limit: limit}
+	}
+
+	if media && text == "" {
+		return nil
+	}
+	if !media {
+		if text == "" {
+			return reject("validation.empty", 0)
+		}
+		// Known commands never get here, so this is a typo like /cancle
+		if strings.HasPrefix(text, "/") {
+			return reject("validation.command", 0)
+		}
+	}
+
+	length := utf8.RuneCountInString(text)
+	if length > fv.rules.MaxLen {
+		return reject("validation.too_long", fv.rules.MaxLen)
+	}
+
+	if !media {
+		if length < fv.rules.MinLen {
+			return reject("validation.too_short", fv.rules.MinLen)
+		}
+		if countLetters(text) < fv.rules.MinLetters {
+			return reject("validation.too_few_letters", fv.rules.MinLetters)
+		}
+	}
+
+	for _, re := range fv.disallowed {
+		if re.MatchString(text) {
+			return reject("validation.disallowed", 0)
+		}
+	}
+
+	return nil
+}
+
+// Field returns the flow step validator of the field. Text wishes are also
+// checked against the wishes the user has sent recently.
+func (v *Validator) Field(field InputField) func(c tele.Context) error {
+	return func(c tele.Context) error {
+		mediaType, _ := messageMedia(c.Message())
+		media := mediaType != MediaNone
+
+		if verr := v.Validate(field, c.Text(), media); verr != nil {
+			return errors.New(verr.Message(ctxLang(c)))
+		}
+
+		if field != FieldWish || media {
+			return nil
+		}
+
+		verr, err := v.CheckRepeated(c.Sender().ID, c.Text())
+		if err != nil {
+			return errors.New(tr(c, "common.error"))
+		}
+		if verr != nil {
+			return errors.New(verr.Message(ctxLang(c)))
+		}
+
+		return nil
+	}
+}
+
+// CheckRepeated rejects a wish if the user has already sent the same text
+// too many times recently
+func (v *Validator) CheckRepeated(userID int64, text string) (*ValidationError, error) {
+	count, err := v.db.CountRecentWishes(userID, text, time.Now().Add(-v.repeatWindow))
+	if err != nil {
+		return nil, err
+	}
+	if count >= int64(v.repeatLimit) {
+		return &ValidationError{Field: FieldWish, Reason: "validation.repeated"}, nil
+	}
+	return nil, nil
+}
+
+// countLetters returns the number of letters and digits in the text
+func countLetters(text string) int {
+	count := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,230 @@
+package wakey
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+type TransportMode string
+
+const (
+	TransportPolling TransportMode = "polling"
+	TransportWebhook TransportMode = "webhook"
+)
+
+const (
+	defaultPollTimeout     = 30 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxUpdateSize     = 1 << 20
+)
+
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// NewPoller creates the poller receiving updates in the configured mode
+func NewPoller(config TransportConfig) (tele.Poller, error) {
+	switch config.Mode {
+	case "", TransportPolling:
+		timeout := time.Duration(config.PollTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultPollTimeout
+		}
+		return &tele.LongPoller{Timeout: timeout}, nil
+	case TransportWebhook:
+		return NewWebhookPoller(config.Webhook, shutdownTimeout(config))
+	default:
+		return nil, fmt.Errorf("unsupported transport mode: %s", config.Mode)
+	}
+}
+
+// SetupTransport tells Telegram how the bot receives updates. The webhook
+// poller sets the webhook, the long poller removes the one left by a previous
+// run as getUpdates doesn't work while a webhook is set.
+func SetupTransport(api *tele.Bot, poller tele.Poller) error {
+	if wp, ok := poller.(*WebhookPoller); ok {
+		return wp.Register(api)
+	}
+	return api.RemoveWebhook()
+}
+
+func shutdownTimeout(config TransportConfig) time.Duration {
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return timeout
+}
+
+// WebhookPoller receives updates that Telegram POSTs to the listener.
+// Unlike tele.Webhook, it rejects invalid requests with an error status and
+// finishes the accepted updates when the bot stops.
+type WebhookPoller struct {
+	config   WebhookConfig
+	timeout  time.Duration
+	listener net.Listener
+	server   *http.Server
+	dest     chan<- tele.Update
+	stopping chan struct{}
+	log      *zap.SugaredLogger
+}
+
+// NewWebhookPoller checks the config and opens the listener, so a busy port
+// or a broken certificate fails the start instead of leaving the bot deaf
+func NewWebhookPoller(config WebhookConfig, shutdownTimeout time.Duration) (*WebhookPoller, error) {
+	if config.Listen == "" {
+		return nil, errors.New("webhook listen address is required")
+	}
+	// Without the token anyone who finds the listener could post updates
+	// on behalf of any user, the admin included
+	if config.SecretToken == "" {
+		return nil, errors.New("webhook secret token is required")
+	}
+	if !secretTokenRe.MatchString(config.SecretToken) {
+		return nil, errors.New("webhook secret token must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if config.PublicURL != "" {
+		u, err := url.Parse(config.PublicURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("webhook public url must be an https url: %s", config.PublicURL)
+		}
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("webhook cert_file and key_file must be set together")
+	}
+	if config.SelfSigned && config.CertFile == "" {
+		return nil, errors.New("self-signed webhook requires cert_file")
+	}
+
+	wp := &WebhookPoller{
+		config:   config,
+		timeout:  shutdownTimeout,
+		stopping: make(chan struct{}),
+		log:      zap.L().Named("webhook").Sugar(),
+	}
+	wp.server = &http.Server{
+		Handler:           wp,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load webhook certificate: %w", err)
+		}
+		wp.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	wp.listener = listener
+
+	return wp, nil
+}
+
+// Addr returns the address the poller listens on
+func (wp *WebhookPoller) Addr() net.Addr {
+	return wp.listener.Addr()
+}
+
+// Register sets the webhook. Without a public url nothing is registered,
+// which allows POSTing updates to the listener locally.
+func (wp *WebhookPoller) Register(api *tele.Bot) error {
+	if wp.config.PublicURL == "" {
+		wp.log.Warnw("no public url, the webhook isn't registered", "address", wp.Addr())
+		return nil
+	}
+
+	webhook := &tele.Webhook{
+		MaxConnections: wp.config.MaxConnections,
+		SecretToken:    wp.config.SecretToken,
+		Endpoint:       &tele.WebhookEndpoint{PublicURL: wp.config.PublicURL},
+	}
+	if wp.config.SelfSigned {
+		webhook.Endpoint.Cert = wp.config.CertFile
+	}
+
+	return api.SetWebhook(webhook)
+}
+
+// Poll serves the webhook until the bot stops. Then it stops accepting
+// requests, waits for the ones being read and processes the updates the bot
+// hasn't picked up yet.
+func (wp *WebhookPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	wp.dest = dest
+
+	go func() {
+		var err error
+		if wp.server.TLSConfig != nil {
+			err = wp.server.ServeTLS(wp.listener, "", "")
+		} else {
+			err = wp.server.Serve(wp.listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			wp.log.Errorw("webhook server failed", "error", err)
+		}
+	}()
+	wp.log.Infow("listening for updates", "address", wp.Addr())
+
+	<-stop
+	close(wp.stopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), wp.timeout)
+	defer cancel()
+	if err := wp.server.Shutdown(ctx); err != nil {
+		wp.log.Errorw("failed to shut down webhook server", "error", err)
+	}
+
+	for {
+		select {
+		case update := <-dest:
+			b.ProcessUpdate(update)
+		default:
+			return
+		}
+	}
+}
+
+func (wp *WebhookPoller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(wp.config.SecretToken)) != 1 {
+		wp.log.Warnw("invalid secret token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	var update tele.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+		wp.log.Warnw("invalid update", "error", err)
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	// Telegram delivers the update again if it isn't accepted
+	select {
+	case wp.dest <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-wp.stopping:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
@@ -1,8 +1,10 @@
 package wakey
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -11,21 +13,49 @@ import (
 	tele "gopkg.in/telebot.v3"
 )
 
+const (
+	flowWish     FlowID = "wish"
+	flowEditWish FlowID = "edit_wish"
+)
+
 type WishHandler struct {
-	db       *DB
-	api      BotAPI
-	stateMan *StateManager
-	log      *zap.SugaredLogger
+	db         *DB
+	api        BotAPI
+	translator Translator
+	flows      *FlowRunner
+	validator  *Validator
+	stateMan   *StateManager
+	callbacks  *CallbackCodec
+	log        *zap.SugaredLogger
 }
 
-func NewWishHandler(db *DB, api BotAPI, wishSched Scheduler, stateMan *StateManager, log *zap.SugaredLogger) *WishHandler {
+// NewWishHandler creates the wish handler. Wishes are delivered untranslated if translator is nil.
+func NewWishHandler(db *DB, api BotAPI, wishSched Scheduler, translator Translator, flows *FlowRunner, validator *Validator, stateMan *StateManager, callbacks *CallbackCodec, log *zap.SugaredLogger) *WishHandler {
 	wh := &WishHandler{
-		db:       db,
-		api:      api,
-		stateMan: stateMan,
-		log:      log,
+		db:         db,
+		api:        api,
+		translator: translator,
+		flows:      flows,
+		validator:  validator,
+		stateMan:   stateMan,
+		callbacks:  callbacks,
+		log:        log,
 	}
 
+	flows.Register(
+		&Flow{
+			ID:         flowWish,
+			Steps:      []FlowStep{{State: StateAwaitingWish, Ask: wh.askForWish, Media: true, Validate: validator.Field(FieldWish)}},
+			OnComplete: wh.completeWish,
+		},
+		&Flow{
+			ID:         flowEditWish,
+			Steps:      []FlowStep{{State: StateEditingWish, Prompt: "wish.edit_prompt", Media: true, Validate: validator.Field(FieldWish)}},
+			OnComplete: wh.completeWishEdit,
+			OnCancel:   wh.cancelWishEdit,
+		},
+	)
+
 	wishSched.SetJobFunc(wh.SendWishes)
 
 	return wh
@@ -38,19 +68,78 @@ func (wh *WishHandler) Actions() []string {
 		btnWishReportID,
 		btnSendWishYesID,
 		btnSendWishNoID,
+		btnBlockSenderID,
+		btnBlockRecipientID,
+		btnEditWishID,
+		btnRetractWishID,
+		btnSendDraftID,
+		btnShowOriginalID,
 	}
 }
 
-func getButtonWishID(c tele.Context) (uint64, error) {
+func getButtonID(c tele.Context, invalidIDKey string) (uint64, error) {
+	args, err := getButtonArgs(c, 1, invalidIDKey)
+	if err != nil {
+		return 0, err
+	}
+	return args[0], nil
+}
+
+// getButtonArgs parses the numeric arguments of the callback data.
+// The returned error is a message for the user.
+func getButtonArgs(c tele.Context, n int, invalidArgKey string) ([]uint64, error) {
 	data := strings.Split(c.Data(), "|")
-	if len(data) != 2 {
-		return 0, errors.New("Неверный формат данных.")
+	if len(data) != n+1 {
+		return nil, errors.New(tr(c, "common.invalid_data"))
 	}
-	wishID, err := strconv.ParseUint(data[1], 10, 64)
-	if err != nil {
-		return 0, errors.New("Неверный ID сообщения.")
+
+	args := make([]uint64, n)
+	for i, arg := range data[1:] {
+		value, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, errors.New(tr(c, invalidArgKey))
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+func getButtonWishID(c tele.Context) (uint64, error) {
+	return getButtonID(c, "common.invalid_wish_id")
+}
+
+// messageMedia returns the type and the Telegram file ID of the media attached to the message
+func messageMedia(msg *tele.Message) (MediaType, string) {
+	switch {
+	case msg.Voice != nil:
+		return MediaVoice, msg.Voice.FileID
+	case msg.Photo != nil:
+		return MediaPhoto, msg.Photo.FileID
+	case msg.Sticker != nil:
+		return MediaSticker, msg.Sticker.FileID
+	case msg.VideoNote != nil:
+		return MediaVideoNote, msg.VideoNote.FileID
+	default:
+		return MediaNone, ""
+	}
+}
+
+// wishSendable returns what should be sent to deliver the wish
+func wishSendable(wish *Wish) interface{} {
+	file := tele.File{FileID: wish.FileID}
+
+	switch wish.MediaType {
+	case MediaVoice:
+		return &tele.Voice{File: file, Caption: wish.Content}
+	case MediaPhoto:
+		return &tele.Photo{File: file, Caption: wish.Content}
+	case MediaSticker:
+		return &tele.Sticker{File: file}
+	case MediaVideoNote:
+		return &tele.VideoNote{File: file}
+	default:
+		return wish.Content
 	}
-	return wishID, nil
 }
 
 func (wh *WishHandler) HandleAction(c tele.Context, action string) error {
@@ -61,100 +150,182 @@ func (wh *WishHandler) HandleAction(c tele.Context, action string) error {
 		return wh.HandleSendWishNo(c)
 	case btnWishDislikeID:
 		return wh.HandleWishDislike(c)
-	case btnWishLikeID, btnWishReportID:
-		wishID, err := getButtonWishID(c)
-		if err != nil {
-			return c.Send(err.Error())
-		}
-		wish, err := wh.db.GetWishByID(uint(wishID))
-		if err != nil {
-			return c.Send("Не удалось найти сообщение.")
-		}
-		if action == btnWishLikeID {
-			return wh.HandleWishLike(c, wish)
-		} else {
-			return wh.HandleWishReport(c, wish)
-		}
+	case btnBlockSenderID:
+		return wh.HandleBlockSender(c)
+	case btnBlockRecipientID:
+		return wh.HandleBlockRecipient(c)
+	case btnEditWishID:
+		return wh.HandleEditWish(c)
+	case btnRetractWishID:
+		return wh.HandleRetractWish(c)
+	case btnSendDraftID:
+		return wh.HandleSendDraft(c)
+	case btnShowOriginalID:
+		return wh.HandleShowOriginal(c)
+	case btnWishLikeID:
+		return wh.HandleWishLike(c)
+	case btnWishReportID:
+		return wh.HandleWishReport(c)
 	default:
 		wh.log.Errorw("unexpected action for WishHandler", "action", action)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
+		return c.Send(tr(c, "common.unknown_action"))
 	}
 }
 
 func (wh *WishHandler) States() []UserState {
-	return []UserState{
-		StateAwaitingWish,
-	}
+	return []UserState{}
 }
 
 func (wh *WishHandler) HandleState(c tele.Context, state UserState) error {
-	switch state {
-	case StateAwaitingWish:
-		return wh.HandleWishInput(c)
-	default:
-		wh.log.Errorw("unexpected state for WishHandler", "state", state)
-		return c.Send("Неизвестное действие. Пожалуйста, попробуйте еще раз.")
-	}
+	wh.log.Errorw("unexpected state for WishHandler", "state", state)
+	return c.Send(tr(c, "common.unknown_action"))
 }
 
-func (wh *WishHandler) HandleWishLike(c tele.Context, wish *Wish) error {
-	plan, err := wh.db.GetPlanByID(wish.PlanID)
+// reactToWish applies the reaction of the recipient to the wish on the pressed
+// button. The returned error is a message for the user.
+func (wh *WishHandler) reactToWish(c tele.Context, state WishState) (*Wish, error) {
+	userID := c.Sender().ID
+
+	wishID, err := getButtonWishID(c)
 	if err != nil {
-		wh.log.Errorw("failed to get plan", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return nil, err
 	}
 
-	user, err := wh.db.GetUserByID(plan.UserID)
+	wish, err := wh.db.ReactToWish(uint(wishID), userID, state)
+	var transitionErr *WishTransitionError
+	switch {
+	case err == nil:
+		return wish, nil
+	case errors.Is(err, ErrNotFound):
+		return nil, errors.New(tr(c, "wish.not_found"))
+	case errors.Is(err, ErrNotWishRecipient):
+		wh.log.Warnw("user tried to react to foreign wish", "userID", userID, "wishID", wishID)
+		return nil, errors.New(tr(c, "wish.not_found"))
+	case errors.As(err, &transitionErr):
+		wh.log.Infow("wish reaction rejected", "userID", userID, "wishID", wishID, "from", transitionErr.From, "to", transitionErr.To)
+		return nil, errors.New(tr(c, "wish.already_rated"))
+	default:
+		wh.log.Errorw("failed to update wish state", "error", err, "wishID", wishID)
+		return nil, errors.New(tr(c, "common.error"))
+	}
+}
+
+func (wh *WishHandler) HandleWishLike(c tele.Context) error {
+	user, err := wh.db.GetUserByID(c.Sender().ID)
 	if err != nil {
-		wh.log.Errorw("failed to get user", "error", err, "userID", plan.UserID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		wh.log.Errorw("failed to get user", "error", err, "userID", c.Sender().ID)
+		return c.Send(tr(c, "common.error"))
 	}
 
-	// Update wish state
-	err = wh.db.UpdateWishState(wish.ID, WishStateLiked)
+	wish, err := wh.reactToWish(c, WishStateLiked)
 	if err != nil {
-		wh.log.Errorw("failed to update wish state", "error", err, "wishID", wish.ID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(err.Error())
 	}
 
 	// Send message to the wish author
-	thanksMsg := fmt.Sprintf("Пользователю %s понравилось ваше сообщение.", user.Name)
+	thanksMsg := T(wh.db.GetUserLang(wish.FromID), "wish.liked_notice", user.Name)
 	_, err = wh.api.Send(tele.ChatID(wish.FromID), thanksMsg)
-	if err != nil {
+	if err != nil && !markIfUnreachable(wh.db, wh.log, wish.FromID, err) {
 		wh.log.Errorw("failed to send thanks message", "error", err, "userID", wish.FromID)
 	}
 
-	return c.Send("Благодарность за сообщение отправлена.")
+	return c.Send(tr(c, "wish.like_sent"))
 }
 
 func (wh *WishHandler) HandleWishDislike(c tele.Context) error {
+	if _, err := wh.reactToWish(c, WishStateDisliked); err != nil {
+		return c.Send(err.Error())
+	}
+
+	return c.Send(tr(c, "wish.dislike_sent"))
+}
+
+func (wh *WishHandler) HandleWishReport(c tele.Context) error {
+	if _, err := wh.reactToWish(c, WishStateReported); err != nil {
+		return c.Send(err.Error())
+	}
+
+	return c.Send(tr(c, "wish.report_sent"))
+}
+
+func (wh *WishHandler) HandleBlockSender(c tele.Context) error {
+	userID := c.Sender().ID
+
 	wishID, err := getButtonWishID(c)
 	if err != nil {
 		return c.Send(err.Error())
 	}
 
-	// Update wish state
-	err = wh.db.UpdateWishState(uint(wishID), WishStateDisliked)
+	wish, err := wh.db.GetWishByID(uint(wishID))
 	if err != nil {
-		wh.log.Errorw("failed to update wish state", "error", err, "wishID", wishID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "wish.not_found"))
+	}
+
+	plan, err := wh.db.GetPlanByID(wish.PlanID)
+	if err != nil {
+		wh.log.Errorw("failed to get plan", "error", err)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if plan.UserID != userID {
+		wh.log.Warnw("user tried to block sender of foreign wish", "userID", userID, "wishID", wish.ID)
+		return c.Send(tr(c, "wish.not_found"))
 	}
 
-	return c.Send("Спасибо за ваш ответ.")
+	label := tr(c, "wish.block_label", truncateText(wish.Content, 30))
+	if err := wh.db.BlockUser(userID, wish.FromID, label); err != nil {
+		wh.log.Errorw("failed to block user", "error", err, "userID", userID, "blockedID", wish.FromID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	return c.Send(tr(c, "wish.sender_blocked"))
 }
 
-func (wh *WishHandler) HandleWishReport(c tele.Context, wish *Wish) error {
-	err := wh.db.UpdateWishState(wish.ID, WishStateReported)
+func (wh *WishHandler) HandleBlockRecipient(c tele.Context) error {
+	userID := c.Sender().ID
+
+	planID, err := getButtonID(c, "common.invalid_plan_id")
 	if err != nil {
-		wh.log.Errorw("failed to update wish state", "error", err, "wishID", wish.ID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(err.Error())
+	}
+
+	userData, exists := wh.stateMan.GetUserData(userID)
+	if !exists || userData.State != StateAwaitingWish || userData.TargetPlanID != uint(planID) {
+		return c.Send(tr(c, "wish.offer_outdated"))
+	}
+
+	plan, err := wh.db.GetPlanByID(uint(planID))
+	if err != nil {
+		wh.log.Errorw("failed to get plan", "error", err)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	user, err := wh.db.GetUserByID(plan.UserID)
+	if err != nil {
+		wh.log.Errorw("failed to get user", "error", err, "userID", plan.UserID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if err := wh.db.BlockUser(userID, user.ID, user.Name); err != nil {
+		wh.log.Errorw("failed to block user", "error", err, "userID", userID, "blockedID", user.ID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if err := wh.db.ReleasePlanOffer(plan.ID, userData.OfferedAt); err != nil {
+		wh.log.Errorw("failed to release plan offer", "error", err, "planID", plan.ID)
 	}
+	wh.stateMan.ClearState(userID)
 
-	return c.Send("Жалоба на сообщение отправлена.")
+	err = c.Send(tr(c, "wish.recipient_blocked"))
+	if err != nil {
+		return err
+	}
+
+	return wh.FindUserForWish(c)
 }
 
 func (wh *WishHandler) HandleSendWishResponse(c tele.Context) error {
-	err := c.Send("Хорошо, давайте отправим сообщение!")
+	err := c.Send(tr(c, "wish.send_yes"))
 	if err != nil {
 		return err
 	}
@@ -163,8 +334,8 @@ func (wh *WishHandler) HandleSendWishResponse(c tele.Context) error {
 }
 
 func (wh *WishHandler) HandleSendWishNo(c tele.Context) error {
-	wh.stateMan.SetState(c.Sender().ID, StateSuggestActions)
-	return c.Send("Хорошо, может быть в следующий раз!")
+	showMenu(c)
+	return c.Send(tr(c, "wish.send_no"))
 }
 
 func (wh *WishHandler) FindUserForWish(c tele.Context) error {
@@ -173,71 +344,267 @@ func (wh *WishHandler) FindUserForWish(c tele.Context) error {
 	plan, err := wh.db.FindPlanForWish(senderID)
 	if err != nil {
 		if err == ErrNotFound {
-			wh.stateMan.SetState(senderID, StateSuggestActions)
-			return c.Send("К сожалению, сейчас нет пользователей, которым можно отправить сообщение.")
+			wh.stateMan.ClearState(senderID)
+			showMenu(c)
+			return c.Send(tr(c, "wish.no_recipients"))
 		}
 		wh.log.Errorw("failed to find user for wish", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.error"))
+	}
+
+	return wh.flows.Start(c, flowWish, &UserData{TargetPlanID: plan.ID, OfferedAt: plan.OfferedAt})
+}
+
+// askForWish shows the offered recipient to the sender
+func (wh *WishHandler) askForWish(c tele.Context, data *UserData) error {
+	senderID := c.Sender().ID
+
+	plan, err := wh.db.GetPlanByID(data.TargetPlanID)
+	if err != nil {
+		wh.log.Errorw("failed to get plan", "error", err)
+		return c.Send(tr(c, "common.error"))
 	}
 
 	user, err := wh.db.GetUserByID(plan.UserID)
 	if err != nil {
 		wh.log.Errorw("failed to get user", "error", err, "userID", plan.UserID)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.error"))
 	}
 
-	// Set user state and data
-	userData := &UserData{
-		State:        StateAwaitingWish,
-		TargetPlanID: plan.ID,
+	_, err = wh.db.GetDraft(senderID)
+	if err != nil && err != ErrNotFound {
+		wh.log.Errorw("failed to get draft", "error", err, "userID", senderID)
 	}
-	wh.stateMan.SetUserData(senderID, userData)
+	hasDraft := err == nil
 
-	const msg = "Напишите сообщение этому пользователю.\n\n" +
-		"Можете поддержать, пожелать что-нибудь доброе, поделиться мыслями. " +
-		"Уважайте друг друга. " +
-		"Постарайтесь не давать советов и оценок, если об этом явно не попросили.\n\n" +
-		"Если совсем не хочется ничего писать, используйте команду /cancel."
+	msg := tr(c, "wish.compose_prompt")
+	if hasDraft {
+		msg += tr(c, "wish.compose_draft")
+	}
+	msg += tr(c, "wish.compose_cancel")
 	err = c.Send(msg)
 	if err != nil {
 		return err
 	}
 
-	return c.Send(fmt.Sprintf("%s\n\n%s\n\n%s", user.Name, user.Bio, plan.Content))
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnBlock := wh.callbacks.senderBtn(c, tr(c, btnBlockRecipientText), btnBlockRecipientID, fmt.Sprintf("%d", plan.ID))
+	rows := []tele.Row{inlineKeyboard.Row(btnBlock)}
+	if hasDraft {
+		btnSendDraft := wh.callbacks.senderBtn(c, tr(c, btnSendDraftText), btnSendDraftID, fmt.Sprintf("%d", plan.ID))
+		rows = append([]tele.Row{inlineKeyboard.Row(btnSendDraft)}, rows...)
+	}
+	inlineKeyboard.Inline(rows...)
+
+	return c.Send(fmt.Sprintf("%s\n\n%s\n\n%s", user.Name, user.Bio, plan.Content), inlineKeyboard)
+}
+
+// isOfferExpired reports whether the plan is no longer reserved for the sender,
+// either the hour has passed or the plan has been offered to someone else since
+func isOfferExpired(plan *Plan, data *UserData) bool {
+	return time.Now().UTC().Sub(plan.OfferedAt) > time.Hour || !plan.OfferedAt.Equal(data.OfferedAt)
+}
+
+func (wh *WishHandler) completeWish(c tele.Context, data *UserData) error {
+	userID := c.Sender().ID
+	wishText := strings.TrimSpace(c.Text())
+	mediaType, fileID := messageMedia(c.Message())
+
+	plan, err := wh.db.GetPlanByID(data.TargetPlanID)
+	if err != nil {
+		wh.log.Errorw("failed to get plan", "error", err)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	if isOfferExpired(plan, data) {
+		draft := &Draft{
+			UserID:    userID,
+			Content:   wishText,
+			MediaType: mediaType,
+			FileID:    fileID,
+		}
+		if err := wh.db.SaveDraft(draft); err != nil {
+			wh.log.Errorw("failed to save draft", "error", err, "userID", userID)
+			wh.stateMan.ClearState(userID)
+			return c.Send(tr(c, "wish.offer_expired"))
+		}
+
+		wh.stateMan.ClearState(userID)
+		err = c.Send(tr(c, "wish.offer_expired_draft"))
+		if err != nil {
+			return err
+		}
+
+		return wh.FindUserForWish(c)
+	}
+
+	wish := &Wish{
+		FromID:    userID,
+		PlanID:    data.TargetPlanID,
+		Content:   wishText,
+		MediaType: mediaType,
+		FileID:    fileID,
+	}
+
+	return wh.saveWish(c, wish)
 }
 
-func (wh *WishHandler) HandleWishInput(c tele.Context) error {
+func (wh *WishHandler) HandleSendDraft(c tele.Context) error {
 	userID := c.Sender().ID
-	wishText := c.Text()
-	userData, _ := wh.stateMan.GetUserData(userID)
-	if userData == nil {
-		return c.Send("Извините, произошла ошибка. Пожалуйста, начните процесс заново.")
+
+	planID, err := getButtonID(c, "common.invalid_plan_id")
+	if err != nil {
+		return c.Send(err.Error())
 	}
 
-	plan, err := wh.db.GetPlanByID(userData.TargetPlanID)
+	userData, exists := wh.stateMan.GetUserData(userID)
+	if !exists || userData.State != StateAwaitingWish || userData.TargetPlanID != uint(planID) {
+		return c.Send(tr(c, "wish.offer_outdated"))
+	}
+
+	draft, err := wh.db.GetDraft(userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Send(tr(c, "wish.draft_not_found"))
+		}
+		wh.log.Errorw("failed to get draft", "error", err, "userID", userID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	plan, err := wh.db.GetPlanByID(uint(planID))
 	if err != nil {
 		wh.log.Errorw("failed to get plan", "error", err)
-		return c.Send("Извините, произошла ошибка. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "common.error"))
 	}
 
-	if time.Now().UTC().Sub(plan.OfferedAt) > time.Hour {
+	if isOfferExpired(plan, userData) {
 		wh.stateMan.ClearState(userID)
-		return c.Send("Извините, время для отправки сообщения этому пользователю истекло. Пожалуйста, попробуйте отправить новое сообщение.")
+		err = c.Send(tr(c, "wish.offer_expired_retry"))
+		if err != nil {
+			return err
+		}
+
+		return wh.FindUserForWish(c)
 	}
 
 	wish := &Wish{
-		FromID:  userID,
-		PlanID:  userData.TargetPlanID,
-		Content: wishText,
+		FromID:    userID,
+		PlanID:    plan.ID,
+		Content:   draft.Content,
+		MediaType: draft.MediaType,
+		FileID:    draft.FileID,
 	}
 
+	return wh.saveWish(c, wish)
+}
+
+func (wh *WishHandler) saveWish(c tele.Context, wish *Wish) error {
+	userID := c.Sender().ID
+
+	// The moderator detects the language later, until then assume the sender's one
+	wish.Lang = ctxLang(c)
 	if err := wh.db.SaveWish(wish); err != nil {
 		wh.log.Errorw("failed to save wish", "error", err)
-		return c.Send("Извините, произошла ошибка при сохранении вашего сообщения. Пожалуйста, попробуйте позже.")
+		return c.Send(tr(c, "wish.save_error"))
+	}
+
+	if err := wh.db.DeleteDraft(userID); err != nil {
+		wh.log.Errorw("failed to delete draft", "error", err, "userID", userID)
+	}
+
+	wh.stateMan.ClearState(userID)
+	showMenu(c)
+	return c.Send(tr(c, "wish.saved"), wh.wishControlsMarkup(c, wish.ID))
+}
+
+func (wh *WishHandler) wishControlsMarkup(c tele.Context, wishID uint) *tele.ReplyMarkup {
+	inlineKeyboard := &tele.ReplyMarkup{}
+	btnEdit := wh.callbacks.senderBtn(c, tr(c, btnEditWishText), btnEditWishID, fmt.Sprintf("%d", wishID))
+	btnRetract := wh.callbacks.senderBtn(c, tr(c, btnRetractWishText), btnRetractWishID, fmt.Sprintf("%d", wishID))
+	inlineKeyboard.Inline(
+		inlineKeyboard.Row(btnEdit, btnRetract),
+	)
+
+	return inlineKeyboard
+}
+
+// getPendingWish returns the sender's wish if it hasn't been delivered yet
+func (wh *WishHandler) getPendingWish(c tele.Context, wishID uint) (*Wish, error) {
+	wish, err := wh.db.GetWishByID(wishID)
+	if err != nil {
+		if err != ErrNotFound {
+			wh.log.Errorw("failed to get wish", "error", err, "wishID", wishID)
+		}
+		return nil, errors.New(tr(c, "wish.not_found"))
+	}
+
+	if wish.FromID != c.Sender().ID {
+		wh.log.Warnw("user tried to change foreign wish", "userID", c.Sender().ID, "wishID", wishID)
+		return nil, errors.New(tr(c, "wish.not_found"))
+	}
+
+	if wish.State != WishStateNew {
+		return nil, errors.New(tr(c, "wish.not_pending"))
+	}
+
+	return wish, nil
+}
+
+func (wh *WishHandler) HandleEditWish(c tele.Context) error {
+	wishID, err := getButtonWishID(c)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	wish, err := wh.getPendingWish(c, uint(wishID))
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	return wh.flows.Start(c, flowEditWish, &UserData{TargetWishID: wish.ID})
+}
+
+func (wh *WishHandler) completeWishEdit(c tele.Context, data *UserData) error {
+	wish, err := wh.getPendingWish(c, data.TargetWishID)
+	if err != nil {
+		showMenu(c)
+		return c.Send(err.Error())
 	}
 
-	wh.stateMan.SetState(userID, StateSuggestActions)
-	return c.Send("Спасибо! Ваше сообщение отправлено и будет доставлено пользователю в запланированное время.")
+	mediaType, fileID := messageMedia(c.Message())
+	err = wh.db.UpdateWishContent(wish.ID, strings.TrimSpace(c.Text()), mediaType, fileID)
+	if err != nil {
+		wh.log.Errorw("failed to update wish", "error", err, "wishID", wish.ID)
+		return c.Send(tr(c, "wish.save_error"))
+	}
+
+	showMenu(c)
+	return c.Send(tr(c, "wish.edited"), wh.wishControlsMarkup(c, wish.ID))
+}
+
+// cancelWishEdit keeps the controls at hand, the wish can still be edited or retracted
+func (wh *WishHandler) cancelWishEdit(c tele.Context, data *UserData) error {
+	return c.Send(tr(c, "wish.edit_cancelled"), wh.wishControlsMarkup(c, data.TargetWishID))
+}
+
+func (wh *WishHandler) HandleRetractWish(c tele.Context) error {
+	wishID, err := getButtonWishID(c)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	wish, err := wh.getPendingWish(c, uint(wishID))
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	err = wh.db.UpdateWishState(wish.ID, WishStateRetracted, c.Sender().ID)
+	if err != nil {
+		wh.log.Errorw("failed to update wish state", "error", err, "wishID", wish.ID)
+		return c.Send(tr(c, "common.error"))
+	}
+
+	return c.Send(tr(c, "wish.retracted"))
 }
 
 func (wh *WishHandler) SendWishes(id JobID) {
@@ -256,6 +623,12 @@ func (wh *WishHandler) SendWishes(id JobID) {
 		return
 	}
 
+	// Skip if user can't receive messages
+	if user.IsUnreachable {
+		wh.log.Infow("skipping wishes for unreachable user", "userID", userID)
+		return
+	}
+
 	// Get all new wishes for user's plans
 	wishes, err := wh.db.GetNewWishesByUserID(userID)
 	if err != nil {
@@ -263,14 +636,39 @@ func (wh *WishHandler) SendWishes(id JobID) {
 		return
 	}
 
+	// Skip wishes between blocked users, they are left for the sweeper
+	wishes = slices.DeleteFunc(wishes, func(wish Wish) bool {
+		blocked, err := wh.db.IsBlocked(userID, wish.FromID)
+		if err != nil {
+			wh.log.Errorw("failed to check block", "error", err, "userID", userID, "fromID", wish.FromID)
+			return true
+		}
+		if blocked {
+			wh.log.Infow("skipping wish from blocked user", "userID", userID, "wishID", wish.ID)
+		}
+		return blocked
+	})
+
+	// Edited wishes wait for the verdict on the new content
+	wishes = slices.DeleteFunc(wishes, func(wish Wish) bool {
+		pending := wish.ContentVersion > 0 && !wish.Toxicity.Valid
+		if pending {
+			wh.log.Infow("skipping edited wish awaiting moderation", "userID", userID, "wishID", wish.ID)
+		}
+		return pending
+	})
+
 	if len(wishes) == 0 {
 		wh.log.Infow("no new wishes found for user", "userID", userID)
 		return
 	}
 
 	// Send greeting
-	_, err = wh.api.Send(tele.ChatID(userID), "Доброе утро! Вот, что вам написали:")
+	_, err = wh.api.Send(tele.ChatID(userID), T(user.Lang, "wish.greeting"))
 	if err != nil {
+		if markIfUnreachable(wh.db, wh.log, userID, err) {
+			return
+		}
 		wh.log.Errorw("failed to send greeting", "error", err, "userID", userID)
 	}
 
@@ -278,26 +676,92 @@ func (wh *WishHandler) SendWishes(id JobID) {
 	for _, wish := range wishes {
 		// Create inline keyboard
 		inlineKeyboard := &tele.ReplyMarkup{}
-		btnLike := inlineKeyboard.Data(btnWishLikeText, btnWishLikeID, fmt.Sprintf("%d", wish.ID))
-		btnDislike := inlineKeyboard.Data(btnWishDislikeText, btnWishDislikeID, fmt.Sprintf("%d", wish.ID))
-		btnReport := inlineKeyboard.Data(btnWishReportText, btnWishReportID, fmt.Sprintf("%d", wish.ID))
-		inlineKeyboard.Inline(
+		btnLike := wh.callbacks.btn(userID, T(user.Lang, btnWishLikeText), btnWishLikeID, fmt.Sprintf("%d", wish.ID))
+		btnDislike := wh.callbacks.btn(userID, T(user.Lang, btnWishDislikeText), btnWishDislikeID, fmt.Sprintf("%d", wish.ID))
+		btnReport := wh.callbacks.btn(userID, T(user.Lang, btnWishReportText), btnWishReportID, fmt.Sprintf("%d", wish.ID))
+		btnBlock := wh.callbacks.btn(userID, T(user.Lang, btnBlockSenderText), btnBlockSenderID, fmt.Sprintf("%d", wish.ID))
+		rows := []tele.Row{
 			inlineKeyboard.Row(btnLike),
 			inlineKeyboard.Row(btnDislike),
 			inlineKeyboard.Row(btnReport),
-		)
+			inlineKeyboard.Row(btnBlock),
+		}
+
+		// Deliver the translation if the wish is written in another language
+		sendable := wishSendable(&wish)
+		if translation := wh.translateWish(&wish, user.Lang); translation != "" {
+			translated := wish
+			translated.Content = T(user.Lang, "wish.translated", translation)
+			sendable = wishSendable(&translated)
+			btnOriginal := wh.callbacks.btn(userID, T(user.Lang, btnShowOriginalText), btnShowOriginalID, fmt.Sprintf("%d", wish.ID))
+			rows = append(rows, inlineKeyboard.Row(btnOriginal))
+		}
+		inlineKeyboard.Inline(rows...)
 
 		// Send message with inline keyboard
-		_, err = wh.api.Send(tele.ChatID(userID), wish.Content, inlineKeyboard)
+		_, err = wh.api.Send(tele.ChatID(userID), sendable, inlineKeyboard)
 		if err != nil {
+			if markIfUnreachable(wh.db, wh.log, userID, err) {
+				return
+			}
 			wh.log.Errorw("failed to send wish", "error", err, "userID", userID, "wishID", wish.ID)
 			continue
 		}
 
 		// Update wish state to sent
-		err = wh.db.UpdateWishState(wish.ID, WishStateSent)
+		err = wh.db.UpdateWishState(wish.ID, WishStateSent, BotActorID)
 		if err != nil {
 			wh.log.Errorw("failed to update wish state", "error", err, "wishID", wish.ID)
 		}
 	}
 }
+
+// translateWish returns the wish content translated into the language or an empty
+// string if the wish doesn't need or can't get a translation
+func (wh *WishHandler) translateWish(wish *Wish, lang string) string {
+	if wh.translator == nil || wish.Content == "" || wish.Lang == "" || wish.Lang == lang {
+		return ""
+	}
+	if wish.TranslationLang == lang && wish.Translation != "" {
+		return wish.Translation
+	}
+
+	translation, err := wh.translator.Translate(context.Background(), wish.Content, lang)
+	if err != nil {
+		wh.log.Errorw("failed to translate wish", "error", err, "wishID", wish.ID, "lang", lang)
+		return ""
+	}
+
+	if err := wh.db.SaveWishTranslation(wish.ID, wish.ContentVersion, lang, translation); err != nil {
+		wh.log.Errorw("failed to save wish translation", "error", err, "wishID", wish.ID)
+	}
+
+	return translation
+}
+
+// HandleShowOriginal sends the original of a translated wish to its recipient
+func (wh *WishHandler) HandleShowOriginal(c tele.Context) error {
+	wishID, err := getButtonWishID(c)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+
+	wish, err := wh.db.GetWishByID(uint(wishID))
+	if err != nil {
+		if err != ErrNotFound {
+			wh.log.Errorw("failed to get wish", "error", err, "wishID", wishID)
+		}
+		return c.Send(tr(c, "wish.not_found"))
+	}
+
+	plan, err := wh.db.GetPlanByID(wish.PlanID)
+	if err != nil || plan.UserID != c.Sender().ID {
+		return c.Send(tr(c, "wish.not_found"))
+	}
+
+	if err := c.Respond(); err != nil {
+		wh.log.Warnw("failed to respond to callback", "err", err)
+	}
+
+	return c.Send(wishSendable(wish), &tele.SendOptions{ReplyTo: c.Message()})
+}
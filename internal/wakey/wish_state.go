@@ -0,0 +1,83 @@
+package wakey
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type WishState string
+
+const (
+	WishStateNew         WishState = "N"
+	WishStateHeld        WishState = "H" // waits for a human review before the delivery
+	WishStateSent        WishState = "S"
+	WishStateRead        WishState = "O" // the recipient has come back to the bot since the delivery
+	WishStateLiked       WishState = "L"
+	WishStateDisliked    WishState = "D"
+	WishStateReported    WishState = "R"
+	WishStateBanned      WishState = "B"
+	WishStateUndelivered WishState = "U"
+	WishStateRetracted   WishState = "X"
+)
+
+// BotActorID is the actor of the state changes the bot makes on its own
+const BotActorID int64 = 0
+
+// WishTransition is a recorded change of the wish state
+type WishTransition struct {
+	ID        uint      `gorm:"primarykey"`
+	WishID    uint      `gorm:"index"`
+	FromState WishState `gorm:"type:char(1)"`
+	ToState   WishState `gorm:"type:char(1);index"`
+	ActorID   int64     // user who changed the state or BotActorID
+	CreatedAt time.Time
+}
+
+// ErrNotWishRecipient is returned when a user acts on a wish delivered to someone else
+var ErrNotWishRecipient = errors.New("wish was delivered to another user")
+
+// ErrWishNotPending is returned when a wish no longer waits for the delivery
+var ErrWishNotPending = errors.New("wish is no longer pending delivery")
+
+// ErrStaleContent is returned for a result computed for the wish content
+// that has been edited since
+var ErrStaleContent = errors.New("wish content has changed")
+
+// WishTransitionError is returned for a state change the wish can't make
+type WishTransitionError struct {
+	WishID uint
+	From   WishState
+	To     WishState
+}
+
+func (e *WishTransitionError) Error() string {
+	return fmt.Sprintf("wish %d can't change state from %s to %s", e.WishID, e.From, e.To)
+}
+
+// wishTransitions are the states a wish can move to from each state. A wish
+// waits for the delivery as new or held, and a delivered wish can be rated
+// only once. The states missing here are final.
+var wishTransitions = map[WishState][]WishState{
+	WishStateNew:  {WishStateHeld, WishStateSent, WishStateBanned, WishStateRetracted, WishStateUndelivered},
+	WishStateHeld: {WishStateNew, WishStateBanned, WishStateRetracted, WishStateUndelivered},
+	WishStateSent: {WishStateRead, WishStateLiked, WishStateDisliked, WishStateReported},
+	WishStateRead: {WishStateLiked, WishStateDisliked, WishStateReported},
+}
+
+// pendingWishStates are the states of a wish waiting for the delivery
+var pendingWishStates = []WishState{WishStateNew, WishStateHeld}
+
+// wishReactions are the states the recipient moves a delivered wish to
+var wishReactions = []WishState{WishStateLiked, WishStateDisliked, WishStateReported}
+
+// CanTransition reports whether a wish can move from one state to the other
+func CanTransition(from, to WishState) bool {
+	return slices.Contains(wishTransitions[from], to)
+}
+
+// canReact reports whether the recipient can move the wish from one state to the other
+func canReact(from, to WishState) bool {
+	return slices.Contains(wishReactions, to) && CanTransition(from, to)
+}
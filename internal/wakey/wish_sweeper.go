@@ -0,0 +1,174 @@
+package wakey
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	defaultGracePeriod   = 6 * time.Hour
+	defaultSweepInterval = 30 * time.Minute
+)
+
+// WishSweeper periodically looks for wishes that were not delivered in time
+// and either redirects them to another recipient or tells the sender about it
+type WishSweeper struct {
+	db       *DB
+	api      BotAPI
+	log      *zap.SugaredLogger
+	grace    time.Duration
+	interval time.Duration
+	quit     chan struct{}
+}
+
+func NewWishSweeper(db *DB, api BotAPI, cfg DeliveryConfig) *WishSweeper {
+	grace := time.Duration(cfg.GracePeriod) * time.Hour
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+
+	interval := time.Duration(cfg.SweepInterval) * time.Minute
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	return &WishSweeper{
+		db:       db,
+		api:      api,
+		log:      zap.L().Named("sweeper").Sugar(),
+		grace:    grace,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+func (ws *WishSweeper) Start() {
+	go ws.run()
+}
+
+func (ws *WishSweeper) Stop() {
+	close(ws.quit)
+}
+
+func (ws *WishSweeper) run() {
+	ticker := time.NewTicker(ws.interval)
+	defer ticker.Stop()
+
+	ws.Sweep()
+
+	for {
+		select {
+		case <-ws.quit:
+			ws.log.Info("Stopping wish sweeper")
+			return
+		case <-ticker.C:
+			ws.Sweep()
+		}
+	}
+}
+
+// Sweep processes all wishes stuck undelivered past the grace period
+func (ws *WishSweeper) Sweep() {
+	deadline := time.Now().UTC().Add(-ws.grace)
+	wishes, err := ws.db.GetUndeliveredWishes(deadline)
+	if err != nil {
+		ws.log.Errorw("failed to get undelivered wishes", "error", err)
+		return
+	}
+
+	if len(wishes) == 0 {
+		return
+	}
+
+	ws.log.Infow("found undelivered wishes", "count", len(wishes))
+
+	for _, wish := range wishes {
+		ws.processWish(&wish)
+	}
+}
+
+func (ws *WishSweeper) processWish(wish *Wish) {
+	sender, err := ws.db.GetUserByID(wish.FromID)
+	if err != nil {
+		ws.log.Errorw("failed to get sender", "error", err, "userID", wish.FromID)
+		return
+	}
+
+	if sender.AllowRedirect {
+		err = ws.redirectWish(wish)
+		if err == nil {
+			ws.notifySender(wish, "sweeper.redirected")
+			return
+		}
+		if err == ErrWishNotPending {
+			ws.log.Infow("wish left the delivery queue before the redirect", "wishID", wish.ID)
+			return
+		}
+	}
+
+	err = ws.db.UpdateWishState(wish.ID, WishStateUndelivered, BotActorID)
+	if err != nil {
+		ws.log.Errorw("failed to update wish state", "error", err, "wishID", wish.ID)
+		return
+	}
+
+	ws.notifySender(wish, "sweeper.undelivered")
+}
+
+// redirectWish moves the wish to the plan of another recipient. It returns
+// ErrNotFound if there is no one to redirect the wish to and ErrWishNotPending
+// if the wish has changed its state since the sweep began.
+func (ws *WishSweeper) redirectWish(wish *Wish) error {
+	plan, err := ws.db.FindPlanForWish(wish.FromID)
+	if err != nil {
+		if err != ErrNotFound {
+			ws.log.Errorw("failed to find plan for wish", "error", err, "wishID", wish.ID)
+		}
+		return err
+	}
+
+	// The redirect takes no answer from the sender, so the plan is released
+	// for the other senders right away
+	defer func() {
+		if err := ws.db.ReleasePlanOffer(plan.ID, plan.OfferedAt); err != nil {
+			ws.log.Errorw("failed to release plan offer", "error", err, "planID", plan.ID)
+		}
+	}()
+
+	err = ws.db.ReassignWish(wish.ID, plan.ID)
+	if err != nil {
+		if err != ErrWishNotPending {
+			ws.log.Errorw("failed to reassign wish", "error", err, "wishID", wish.ID, "planID", plan.ID)
+		}
+		return err
+	}
+
+	ws.log.Infow("redirected wish", "wishID", wish.ID, "fromPlanID", wish.PlanID, "toPlanID", plan.ID)
+	wish.PlanID = plan.ID
+
+	return nil
+}
+
+func (ws *WishSweeper) notifySender(wish *Wish, key string) {
+	lang := ws.db.GetUserLang(wish.FromID)
+	_, err := ws.api.Send(tele.ChatID(wish.FromID), T(lang, key, wishPreview(lang, wish)))
+	if err != nil && !markIfUnreachable(ws.db, ws.log, wish.FromID, err) {
+		ws.log.Errorw("failed to notify sender", "error", err, "userID", wish.FromID, "wishID", wish.ID)
+	}
+}
+
+// wishPreview returns the text that stands for the wish in the notices. Media
+// is named by its type since the notice can't quote it.
+func wishPreview(lang string, wish *Wish) string {
+	if wish.MediaType == MediaNone {
+		return wish.Content
+	}
+
+	label := T(lang, "sweeper.media."+string(wish.MediaType))
+	if wish.Content == "" {
+		return label
+	}
+	return label + "\n" + wish.Content
+}
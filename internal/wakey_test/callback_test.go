@@ -0,0 +1,116 @@
+package wakey_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// newTestCallbacks creates the codec signing the buttons of the test bot
+func newTestCallbacks(t *testing.T) *wakey.CallbackCodec {
+	cc, err := wakey.NewCallbackCodec(wakey.CallbackConfig{}, fakeBotToken)
+	require.NoError(t, err)
+	return cc
+}
+
+func TestCallbackCodec(t *testing.T) {
+	cc, err := wakey.NewCallbackCodec(wakey.CallbackConfig{Secret: "0123456789abcdef", TTL: 1}, "")
+	require.NoError(t, err)
+
+	now := time.Now()
+	data := cc.Encode(42, "wish_like", []string{"123"}, now)
+	require.True(t, strings.HasPrefix(data, "\fwish_like|123|"), data)
+
+	decoded, err := cc.Decode(42, data, now)
+	require.NoError(t, err)
+	require.Equal(t, "\fwish_like|123", decoded)
+
+	noArgs := cc.Encode(42, "show_profile", nil, now)
+	decoded, err = cc.Decode(42, noArgs, now)
+	require.NoError(t, err)
+	require.Equal(t, "\fshow_profile", decoded)
+
+	// The longest buttons fit into the 64 bytes Telegram allows
+	longest := cc.Encode(-1001234567890, "mood_tag", []string{"4294967295", "99"}, now)
+	require.LessOrEqual(t, len(longest), 64, longest)
+
+	sigAt := strings.LastIndex(data, "|") + 1
+	otherVersion := data[:sigAt] + "2" + data[sigAt+1:]
+
+	cases := []struct {
+		name string
+		user int64
+		data string
+		at   time.Time
+		err  error
+	}{
+		{"another user", 43, data, now, wakey.ErrCallbackInvalid},
+		{"forged wish id", 42, strings.Replace(data, "|123|", "|124|", 1), now, wakey.ErrCallbackInvalid},
+		{"unsigned", 42, "\fwish_like|123", now, wakey.ErrCallbackInvalid},
+		{"no separator", 42, "\fshow_profile", now, wakey.ErrCallbackInvalid},
+		{"other version", 42, otherVersion, now, wakey.ErrCallbackInvalid},
+		{"expired", 42, data, now.Add(25 * time.Hour), wakey.ErrCallbackExpired},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := cc.Decode(tc.user, tc.data, tc.at)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	other, err := wakey.NewCallbackCodec(wakey.CallbackConfig{Secret: "fedcba9876543210"}, "")
+	require.NoError(t, err)
+	_, err = other.Decode(42, data, now)
+	require.ErrorIs(t, err, wakey.ErrCallbackInvalid)
+}
+
+func TestNewCallbackCodec(t *testing.T) {
+	_, err := wakey.NewCallbackCodec(wakey.CallbackConfig{}, "")
+	require.Error(t, err)
+
+	_, err = wakey.NewCallbackCodec(wakey.CallbackConfig{Secret: "short"}, "123:token")
+	require.Error(t, err)
+
+	// The key derived from the token survives restarts
+	before, err := wakey.NewCallbackCodec(wakey.CallbackConfig{}, "123:token")
+	require.NoError(t, err)
+	after, err := wakey.NewCallbackCodec(wakey.CallbackConfig{}, "123:token")
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = after.Decode(7, before.Encode(7, "show_blocks", nil, now), now)
+	require.NoError(t, err)
+}
+
+func TestEndToEndForgedCallback(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 3001, FirstName: "Alice", LanguageCode: "en"}
+	mallory := &tele.User{ID: 3002, FirstName: "Mallory", LanguageCode: "en"}
+	app.register(t, alice, "Alice", "Painting")
+
+	prompt := tg.lastTo(alice.ID)
+	btn, ok := findButton(&prompt, "send_wish_no")
+	require.True(t, ok)
+	stale := wakey.T("en", "bot.stale_button")
+
+	// Buttons only work for the user they were sent to
+	tg.pressData(mallory, prompt, btn.Data)
+	require.Equal(t, stale, tg.lastAnswer().Text)
+	require.True(t, tg.lastAnswer().ShowAlert)
+
+	// Unsigned and tampered buttons are rejected
+	tg.pressData(alice, prompt, "\fsend_wish_no")
+	require.Equal(t, stale, tg.lastAnswer().Text)
+	tg.pressData(alice, prompt, strings.Replace(btn.Data, "send_wish_no", "send_wish_yes", 1))
+	require.Equal(t, stale, tg.lastAnswer().Text)
+	require.Equal(t, prompt, tg.lastTo(alice.ID), "rejected presses change nothing")
+
+	tg.press(alice, prompt, "send_wish_no")
+	require.Equal(t, wakey.T("en", "general.menu"), tg.lastTo(alice.ID).Text)
+}
@@ -0,0 +1,85 @@
+package wakey_test
+
+import (
+	"testing"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func commandNames(cmds []tele.Command) []string {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Text
+	}
+	return names
+}
+
+func TestEndToEndCommandMenu(t *testing.T) {
+	app := newTestApp(t, wakey.Config{AdminID: 4001})
+	tg := app.tg
+
+	private := tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}
+	adminChat := tele.CommandScope{Type: tele.CommandScopeChat, ChatID: 4001}
+
+	// Users don't see the admin commands
+	for _, lang := range append(wakey.Languages(), "") {
+		require.Equal(t, []string{"start", "mood", "cancel"}, commandNames(tg.commandsFor(private, lang)), "lang %q", lang)
+		require.Equal(t, []string{"start", "mood", "cancel", "stat", "notify"}, commandNames(tg.commandsFor(adminChat, lang)), "lang %q", lang)
+	}
+
+	// The menus are localized, other languages get the default one
+	require.Equal(t, wakey.T("en", "command.start"), tg.commandsFor(private, "en")[0].Description)
+	require.Equal(t, wakey.T("ru", "command.start"), tg.commandsFor(private, "ru")[0].Description)
+	require.Equal(t, wakey.T(wakey.DefaultLang, "command.notify"), tg.commandsFor(adminChat, "")[4].Description)
+}
+
+func TestEndToEndCommandMenuWithoutAdmin(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+
+	require.NotEmpty(t, app.tg.commandsFor(tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}, "en"))
+	require.Empty(t, app.tg.commandsFor(tele.CommandScope{Type: tele.CommandScopeChat}, "en"))
+}
+
+func TestEndToEndDeepLinks(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 4101, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 4102, FirstName: "Bob", LanguageCode: "en"}
+	carol := &tele.User{ID: 4103, FirstName: "Carol", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Watering the garden")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+
+	// New users register first whatever the link
+	tg.sendText(bob, "/start send_wish")
+	require.Equal(t, wakey.T("en", "profile.ask_name"), tg.lastTo(bob.ID).Text)
+	tg.sendText(bob, "/cancel")
+
+	registered, err := app.db.GetUserByID(alice.ID)
+	require.NoError(t, err)
+	tg.sendText(carol, "/start ref_"+registered.ReferralCode.String)
+	require.Equal(t, wakey.T("en", "profile.ask_name"), tg.lastTo(carol.ID).Text)
+
+	// A registered user jumps straight to writing a wish
+	app.register(t, bob, "Bob", "Baking bread")
+	tg.press(bob, tg.lastTo(bob.ID), "send_wish_no")
+	tg.sendText(bob, "/start send_wish")
+	require.Contains(t, tg.textsTo(bob.ID), wakey.T("en", "wish.send_yes"))
+	offer := tg.lastWithButton(bob.ID, "block_recipient")
+	require.Contains(t, offer.Text, "Watering the garden")
+
+	// Unknown links start the bot as usual
+	tg.sendText(alice, "/start something_else")
+	require.Equal(t, wakey.T("en", "profile.welcome_back", "Alice")+"\n\n"+wakey.T("en", "profile.welcome"), tg.lastTo(alice.ID).Text)
+
+	// A user who blocked the bot comes back through the link
+	tg.sendText(bob, "/cancel")
+	require.NoError(t, app.db.SetUserUnreachable(bob.ID, true))
+	tg.sendText(bob, "/start send_wish")
+	user, err := app.db.GetUserByID(bob.ID)
+	require.NoError(t, err)
+	require.False(t, user.IsUnreachable, "the deep link reactivates the user")
+}
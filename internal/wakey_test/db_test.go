@@ -182,7 +182,7 @@ func TestBanUser(t *testing.T) {
 	}()
 
 	// Ban the user
-	err = db.BanUser(user.ID)
+	err = db.BanUser(user.ID, 1)
 	require.NoError(t, err)
 
 	// Wait for notifications
@@ -210,11 +210,79 @@ func TestBanUser(t *testing.T) {
 	require.Equal(t, int64(102), wishesAfterBan[0].FromID)
 
 	// Test banning non-existent user
-	err = db.BanUser(999)
+	err = db.BanUser(999, 1)
 	require.Error(t, err)
 	require.Equal(t, wakey.ErrNotFound, err)
 }
 
+func TestUserReachability(t *testing.T) {
+	db := setupTestDB(t)
+
+	sender := &wakey.User{ID: 110, Name: "Sender"}
+	recipient := &wakey.User{ID: 111, Name: "Recipient"}
+	require.NoError(t, db.CreateUser(sender))
+	require.NoError(t, db.CreateUser(recipient))
+
+	plan := &wakey.Plan{
+		UserID:  recipient.ID,
+		Content: "Reachability Plan",
+		WakeAt:  time.Now().Add(24 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(plan))
+
+	userCh, unsub := db.SubscribeToReachability(10)
+	defer unsub()
+
+	// Mark the recipient as unreachable
+	err := db.SetUserUnreachable(recipient.ID, true)
+	require.NoError(t, err)
+
+	select {
+	case user := <-userCh:
+		require.Equal(t, recipient.ID, user.ID)
+		require.True(t, user.IsUnreachable)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for reachability notification")
+	}
+
+	fetchedUser, err := db.GetUserByID(recipient.ID)
+	require.NoError(t, err)
+	require.True(t, fetchedUser.IsUnreachable)
+
+	// Plans of unreachable users must not be offered
+	_, err = db.FindPlanForWish(sender.ID)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// Setting the same value again should not notify
+	err = db.SetUserUnreachable(recipient.ID, true)
+	require.NoError(t, err)
+
+	select {
+	case <-userCh:
+		t.Fatal("Unexpected reachability notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Reactivate the recipient
+	err = db.SetUserUnreachable(recipient.ID, false)
+	require.NoError(t, err)
+
+	select {
+	case user := <-userCh:
+		require.False(t, user.IsUnreachable)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for reachability notification")
+	}
+
+	foundPlan, err := db.FindPlanForWish(sender.ID)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, foundPlan.ID)
+
+	// Test non-existent user
+	err = db.SetUserUnreachable(999, true)
+	require.Equal(t, wakey.ErrNotFound, err)
+}
+
 func TestPlanOperations(t *testing.T) {
 	db := setupTestDB(t)
 
@@ -446,7 +514,7 @@ func TestToxicitySubscriptions(t *testing.T) {
 	}()
 
 	// Update toxicity
-	err = db.UpdateWishToxicity(wish.ID, 75)
+	err = db.UpdateWishToxicity(wish.ID, 0, 75)
 	require.NoError(t, err)
 
 	// Wait for notifications
@@ -465,7 +533,7 @@ func TestToxicitySubscriptions(t *testing.T) {
 	unsub1()
 
 	// Create another toxicity update
-	err = db.UpdateWishToxicity(wish.ID, 50)
+	err = db.UpdateWishToxicity(wish.ID, 0, 50)
 	require.NoError(t, err)
 
 	// Check that ch1 is closed
@@ -538,7 +606,7 @@ func TestWishStateSubscriptions(t *testing.T) {
 	}()
 
 	// Update wish state
-	err = db.UpdateWishState(wish.ID, wakey.WishStateLiked)
+	err = db.UpdateWishState(wish.ID, wakey.WishStateSent, wakey.BotActorID)
 	require.NoError(t, err)
 
 	// Wait for notifications
@@ -550,14 +618,14 @@ func TestWishStateSubscriptions(t *testing.T) {
 
 	require.Equal(t, wish.ID, received1.ID)
 	require.Equal(t, wish.ID, received2.ID)
-	require.Equal(t, wakey.WishStateLiked, received1.State)
-	require.Equal(t, wakey.WishStateLiked, received2.State)
+	require.Equal(t, wakey.WishStateSent, received1.State)
+	require.Equal(t, wakey.WishStateSent, received2.State)
 
 	// Test unsubscribe
 	unsub1()
 
 	// Update state again
-	err = db.UpdateWishState(wish.ID, wakey.WishStateDisliked)
+	err = db.UpdateWishState(wish.ID, wakey.WishStateDisliked, user.ID)
 	require.NoError(t, err)
 
 	// Check that ch1 is closed
@@ -654,16 +722,15 @@ func TestWishStateOperations(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, wakey.WishStateNew, fetchedWish.State)
 
-	// Test updating state
+	// Test updating state along the lifecycle
 	states := []wakey.WishState{
-		wakey.WishStateSent,     // "S"
-		wakey.WishStateLiked,    // "L"
-		wakey.WishStateDisliked, // "D"
-		wakey.WishStateReported, // "R"
+		wakey.WishStateSent,  // "S"
+		wakey.WishStateRead,  // "O"
+		wakey.WishStateLiked, // "L"
 	}
 
 	for _, state := range states {
-		err = db.UpdateWishState(wish.ID, state)
+		err = db.UpdateWishState(wish.ID, state, 40)
 		require.NoError(t, err)
 
 		fetchedWish, err = db.GetWishByID(wish.ID)
@@ -671,12 +738,89 @@ func TestWishStateOperations(t *testing.T) {
 		require.Equal(t, state, fetchedWish.State)
 	}
 
+	// A rated wish keeps its state
+	err = db.UpdateWishState(wish.ID, wakey.WishStateDisliked, 40)
+	var transitionErr *wakey.WishTransitionError
+	require.ErrorAs(t, err, &transitionErr)
+
 	// Test updating non-existent wish
-	err = db.UpdateWishState(999, wakey.WishStateLiked)
+	err = db.UpdateWishState(999, wakey.WishStateLiked, 40)
 	require.Error(t, err)
 	require.Equal(t, wakey.ErrNotFound, err)
 }
 
+func TestReactToWish(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 50, Name: "Recipient"}))
+	plan := &wakey.Plan{UserID: 50, Content: "Reaction Plan", WakeAt: time.Now()}
+	require.NoError(t, db.SavePlan(plan))
+
+	newWish := func(state wakey.WishState) *wakey.Wish {
+		wish := &wakey.Wish{FromID: 51, PlanID: plan.ID, Content: "Reaction Wish"}
+		require.NoError(t, db.SaveWish(wish))
+		if state != wakey.WishStateNew {
+			require.NoError(t, db.UpdateWishState(wish.ID, state, wakey.BotActorID))
+		}
+		return wish
+	}
+
+	reactions := []wakey.WishState{wakey.WishStateLiked, wakey.WishStateDisliked, wakey.WishStateReported}
+
+	// A delivered wish takes any reaction, once
+	for _, reaction := range reactions {
+		wish := newWish(wakey.WishStateSent)
+		reacted, err := db.ReactToWish(wish.ID, 50, reaction)
+		require.NoError(t, err)
+		require.Equal(t, reaction, reacted.State)
+
+		for _, again := range reactions {
+			_, err := db.ReactToWish(wish.ID, 50, again)
+			var transitionErr *wakey.WishTransitionError
+			require.ErrorAs(t, err, &transitionErr, "%s after %s", again, reaction)
+			require.Equal(t, wakey.WishTransitionError{WishID: wish.ID, From: reaction, To: again}, *transitionErr)
+		}
+
+		fetched, err := db.GetWishByID(wish.ID)
+		require.NoError(t, err)
+		require.Equal(t, reaction, fetched.State)
+	}
+
+	// Wishes that weren't delivered or are gone can't be rated
+	illegal := []wakey.WishState{
+		wakey.WishStateNew,
+		wakey.WishStateBanned,
+		wakey.WishStateUndelivered,
+		wakey.WishStateRetracted,
+	}
+	for _, from := range illegal {
+		wish := newWish(from)
+		for _, reaction := range reactions {
+			_, err := db.ReactToWish(wish.ID, 50, reaction)
+			var transitionErr *wakey.WishTransitionError
+			require.ErrorAs(t, err, &transitionErr, "%s from %s", reaction, from)
+		}
+	}
+
+	// Reactions aren't states a wish can be sent back to
+	wish := newWish(wakey.WishStateSent)
+	for _, to := range []wakey.WishState{wakey.WishStateNew, wakey.WishStateSent, wakey.WishStateRetracted} {
+		_, err := db.ReactToWish(wish.ID, 50, to)
+		var transitionErr *wakey.WishTransitionError
+		require.ErrorAs(t, err, &transitionErr, to)
+	}
+
+	// Only the recipient can react
+	_, err := db.ReactToWish(wish.ID, 51, wakey.WishStateLiked)
+	require.ErrorIs(t, err, wakey.ErrNotWishRecipient)
+	fetched, err := db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateSent, fetched.State)
+
+	_, err = db.ReactToWish(999, 50, wakey.WishStateLiked)
+	require.ErrorIs(t, err, wakey.ErrNotFound)
+}
+
 func TestFindPlanForWish(t *testing.T) {
 	db := setupTestDB(t)
 
@@ -975,7 +1119,7 @@ func TestWishToxicityOperations(t *testing.T) {
 	require.False(t, unratedContents["Zero Toxicity Wish"])
 
 	// Test UpdateWishToxicity
-	err = db.UpdateWishToxicity(unratedWishes[0].ID, 50)
+	err = db.UpdateWishToxicity(unratedWishes[0].ID, 0, 50)
 	require.NoError(t, err)
 
 	// Verify the update
@@ -985,7 +1129,7 @@ func TestWishToxicityOperations(t *testing.T) {
 	require.Equal(t, int16(50), updatedWish.Toxicity.Int16)
 
 	// Test updating non-existent wish
-	err = db.UpdateWishToxicity(999, 50)
+	err = db.UpdateWishToxicity(999, 0, 50)
 	require.Error(t, err)
 	require.Equal(t, wakey.ErrNotFound, err)
 
@@ -996,7 +1140,7 @@ func TestWishToxicityOperations(t *testing.T) {
 	require.Equal(t, "Unrated Wish 2", unratedWishes[0].Content)
 
 	// Test setting toxicity to zero
-	err = db.UpdateWishToxicity(unratedWishes[0].ID, 0)
+	err = db.UpdateWishToxicity(unratedWishes[0].ID, 0, 0)
 	require.NoError(t, err)
 
 	// Verify zero toxicity is different from unrated
@@ -1005,14 +1149,14 @@ func TestWishToxicityOperations(t *testing.T) {
 	require.Len(t, unratedWishes, 0)
 }
 
-func TestGetStats(t *testing.T) {
+func TestGetReportData(t *testing.T) {
 	db := setupTestDB(t)
 
 	// Create test users
 	users := []*wakey.User{
 		{ID: 30, Name: "Stats User 1"},
 		{ID: 31, Name: "Stats User 2"},
-		{ID: 32, Name: "Stats User 3"},
+		{ID: 32, Name: "Stats User 3", ReferrerID: 30},
 	}
 	for _, user := range users {
 		err := db.CreateUser(user)
@@ -1037,7 +1181,7 @@ func TestGetStats(t *testing.T) {
 		require.NoError(t, err)
 	}
 
-	// Create some wishes
+	// Create some wishes, one of them is delivered and liked
 	wishes := []*wakey.Wish{
 		{
 			FromID:  32,
@@ -1054,91 +1198,48 @@ func TestGetStats(t *testing.T) {
 		err := db.SaveWish(wish)
 		require.NoError(t, err)
 	}
-
-	// Get stats
-	stats, err := db.GetStats()
+	require.NoError(t, db.UpdateWishState(wishes[0].ID, wakey.WishStateSent, wakey.BotActorID))
+	_, err := db.ReactToWish(wishes[0].ID, 30, wakey.WishStateLiked)
 	require.NoError(t, err)
-	require.NotNil(t, stats)
-
-	// Verify counts
-	require.Equal(t, int64(3), stats.TotalUsers)
-	require.Equal(t, int64(2), stats.TotalPlans)
-	require.Equal(t, int64(2), stats.TotalWishes)
-
-	// All users are new and active in last 7 days
-	require.Equal(t, int64(3), stats.NewUsersLast7Days)
-	require.Equal(t, int64(3), stats.ActiveUsersLast7Days)
 
-	// Average plans and wishes per day
-	require.InDelta(t, 2.0/7.0, stats.AvgPlansLast7Days, 0.001)
-	require.InDelta(t, 2.0/7.0, stats.AvgWishesLast7Days, 0.001)
-}
-
-func TestGetStatsWithLikedWishes(t *testing.T) {
-	db := setupTestDB(t)
+	since := time.Now().Add(-time.Hour)
+	data, err := db.GetReportData(since, time.Now().Add(time.Hour))
+	require.NoError(t, err)
 
-	// Create test users
-	users := []*wakey.User{
-		{ID: 30, Name: "Stats User 1"},
-		{ID: 31, Name: "Stats User 2"},
-	}
-	for _, user := range users {
-		err := db.CreateUser(user)
-		require.NoError(t, err)
+	require.Equal(t, int64(3), data.TotalUsers)
+	require.Len(t, data.Signups, 3)
+	referred := 0
+	for _, signup := range data.Signups {
+		if signup.Referred {
+			referred++
+		}
 	}
+	require.Equal(t, 1, referred)
 
-	// Create a plan
-	plan := &wakey.Plan{
-		UserID:  30,
-		Content: "Stats Plan 1",
-		WakeAt:  time.Now().Add(24 * time.Hour),
+	kinds := make(map[wakey.ActivityKind]int)
+	for _, activity := range data.Activity {
+		kinds[activity.Kind]++
 	}
-	err := db.SavePlan(plan)
-	require.NoError(t, err)
+	require.Equal(t, map[wakey.ActivityKind]int{wakey.ActivityPlan: 2, wakey.ActivityWish: 2}, kinds)
 
-	// Create wishes with different states
-	wishes := []*wakey.Wish{
-		{
-			FromID:  31,
-			PlanID:  plan.ID,
-			Content: "Liked Wish 1",
-			State:   wakey.WishStateLiked,
-		},
-		{
-			FromID:  31,
-			PlanID:  plan.ID,
-			Content: "New Wish",
-			State:   wakey.WishStateNew,
-		},
-		{
-			FromID:  31,
-			PlanID:  plan.ID,
-			Content: "Liked Wish 2",
-			State:   wakey.WishStateLiked,
-		},
-		{
-			FromID:  31,
-			PlanID:  plan.ID,
-			Content: "Disliked Wish",
-			State:   wakey.WishStateDisliked,
-		},
+	require.Len(t, data.WishEvents, 2)
+	require.Equal(t, wakey.WishStateSent, data.WishEvents[0].State)
+	require.Equal(t, wakey.WishStateLiked, data.WishEvents[1].State)
+	for _, event := range data.WishEvents {
+		require.Equal(t, wishes[0].ID, event.WishID)
+		require.WithinDuration(t, wishes[0].CreatedAt, event.WrittenAt, time.Second)
+		require.False(t, event.At.Before(event.WrittenAt))
 	}
 
-	for _, wish := range wishes {
-		err := db.SaveWish(wish)
-		require.NoError(t, err)
-	}
+	require.Equal(t, []wakey.Referrer{{UserID: 30, Name: "Stats User 1", Referred: 1}}, data.TopReferrers)
 
-	// Get stats
-	stats, err := db.GetStats()
+	// Nothing happened before the period
+	data, err = db.GetReportData(since.Add(-time.Hour), since)
 	require.NoError(t, err)
-	require.NotNil(t, stats)
-
-	// Verify liked wishes stats
-	require.Equal(t, int64(2), stats.TotalLikedWishes)
-	require.InDelta(t, 50.0, stats.LikedWishesPercent, 0.1) // 2 liked out of 4 total = 50%
-	require.Equal(t, int64(2), stats.LikedWishesLast7Days)
-	require.InDelta(t, 50.0, stats.LikedWishesLast7DaysPercent, 0.1)
+	require.Equal(t, int64(3), data.TotalUsers)
+	require.Empty(t, data.Signups)
+	require.Empty(t, data.Activity)
+	require.Empty(t, data.WishEvents)
 }
 
 func TestDBStates(t *testing.T) {
@@ -1234,3 +1335,610 @@ func TestDBStop(t *testing.T) {
 	require.True(t, checkChannelClosed(toxCh1), "Toxicity channel 1 should be closed")
 	require.True(t, checkChannelClosed(toxCh2), "Toxicity channel 2 should be closed")
 }
+
+func TestBlocks(t *testing.T) {
+	db := setupTestDB(t)
+
+	users := []*wakey.User{
+		{ID: 400, Name: "Sender"},
+		{ID: 401, Name: "Recipient"},
+		{ID: 402, Name: "Other"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	plan := &wakey.Plan{
+		UserID:  401,
+		Content: "Block Plan",
+		WakeAt:  time.Now().Add(24 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(plan))
+
+	// The recipient blocks the sender
+	err := db.BlockUser(401, 400, "Sender")
+	require.NoError(t, err)
+
+	// Blocking twice is not an error
+	err = db.BlockUser(401, 400, "Sender")
+	require.NoError(t, err)
+
+	blocks, err := db.GetBlocks(401)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, int64(400), blocks[0].BlockedID)
+	require.Equal(t, "Sender", blocks[0].Label)
+
+	// Blocks work in both directions
+	blocked, err := db.IsBlocked(400, 401)
+	require.NoError(t, err)
+	require.True(t, blocked)
+	blocked, err = db.IsBlocked(401, 400)
+	require.NoError(t, err)
+	require.True(t, blocked)
+	blocked, err = db.IsBlocked(400, 402)
+	require.NoError(t, err)
+	require.False(t, blocked)
+
+	// The blocked sender is not offered the recipient's plan
+	_, err = db.FindPlanForWish(400)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// Other users can still get it
+	foundPlan, err := db.FindPlanForWish(402)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, foundPlan.ID)
+	require.NoError(t, db.ReleasePlanOffer(plan.ID, foundPlan.OfferedAt))
+
+	// Only the user who created the block can remove it
+	err = db.UnblockUser(400, blocks[0].ID)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	err = db.UnblockUser(401, blocks[0].ID)
+	require.NoError(t, err)
+
+	blocks, err = db.GetBlocks(401)
+	require.NoError(t, err)
+	require.Empty(t, blocks)
+
+	foundPlan, err = db.FindPlanForWish(400)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, foundPlan.ID)
+}
+
+func TestMediaWishes(t *testing.T) {
+	db := setupTestDB(t)
+
+	plan := &wakey.Plan{
+		UserID:  500,
+		Content: "Media Plan",
+		WakeAt:  time.Now().Add(24 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(plan))
+
+	wish := &wakey.Wish{
+		FromID:    501,
+		PlanID:    plan.ID,
+		Content:   "Caption",
+		MediaType: wakey.MediaVoice,
+		FileID:    "voice-file-id",
+	}
+	require.NoError(t, db.SaveWish(wish))
+
+	fetchedWish, err := db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.MediaVoice, fetchedWish.MediaType)
+	require.Equal(t, "voice-file-id", fetchedWish.FileID)
+	require.False(t, fetchedWish.NeedsReview)
+
+	reviewCh, unsub := db.SubscribeToReviews(10)
+	defer unsub()
+
+	err = db.MarkWishForReview(wish.ID, 0)
+	require.NoError(t, err)
+
+	select {
+	case notification := <-reviewCh:
+		require.Equal(t, wish.ID, notification.ID)
+		require.True(t, notification.NeedsReview)
+		require.Equal(t, wakey.MediaVoice, notification.MediaType)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for review notification")
+	}
+
+	fetchedWish, err = db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.True(t, fetchedWish.NeedsReview)
+
+	err = db.MarkWishForReview(999, 0)
+	require.Equal(t, wakey.ErrNotFound, err)
+}
+
+func TestEditAndRetractWish(t *testing.T) {
+	db := setupTestDB(t)
+
+	users := []*wakey.User{
+		{ID: 600, Name: "Sender"},
+		{ID: 601, Name: "Recipient"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	plan := &wakey.Plan{
+		UserID:  601,
+		Content: "Edit Plan",
+		WakeAt:  time.Now().Add(24 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(plan))
+
+	wish := &wakey.Wish{
+		FromID:   600,
+		PlanID:   plan.ID,
+		Content:  "Original",
+		Toxicity: sql.NullInt16{Int16: 10, Valid: true},
+	}
+	require.NoError(t, db.SaveWish(wish))
+
+	wishCh, unsub := db.SubscribeToWishes(10)
+	defer unsub()
+
+	// Editing resets moderation and notifies the toxicity checker
+	err := db.UpdateWishContent(wish.ID, "Edited", wakey.MediaNone, "")
+	require.NoError(t, err)
+
+	select {
+	case notification := <-wishCh:
+		require.Equal(t, wish.ID, notification.ID)
+		require.Equal(t, "Edited", notification.Content)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for wish notification")
+	}
+
+	fetchedWish, err := db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Edited", fetchedWish.Content)
+	require.False(t, fetchedWish.Toxicity.Valid)
+	require.Equal(t, uint(1), fetchedWish.ContentVersion)
+
+	// A verdict on the original content is dropped
+	require.Equal(t, wakey.ErrStaleContent, db.UpdateWishToxicity(wish.ID, 0, 90))
+	require.Equal(t, wakey.ErrStaleContent, db.MarkWishForReview(wish.ID, 0))
+	require.Equal(t, wakey.ErrStaleContent, db.SetWishLanguage(wish.ID, 0, "de"))
+
+	fetchedWish, err = db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.False(t, fetchedWish.Toxicity.Valid)
+	require.False(t, fetchedWish.NeedsReview)
+	require.Equal(t, wakey.WishStateNew, fetchedWish.State)
+	require.Empty(t, fetchedWish.Lang)
+
+	require.NoError(t, db.UpdateWishToxicity(wish.ID, 1, 5))
+
+	// Retracted wishes are not delivered
+	err = db.UpdateWishState(wish.ID, wakey.WishStateRetracted, 600)
+	require.NoError(t, err)
+
+	newWishes, err := db.GetNewWishesByUserID(601)
+	require.NoError(t, err)
+	require.Empty(t, newWishes)
+
+	// Retracted wishes can't be edited
+	err = db.UpdateWishContent(wish.ID, "Too late", wakey.MediaNone, "")
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// The plan can receive another wish
+	foundPlan, err := db.FindPlanForWish(602)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, foundPlan.ID)
+}
+
+func TestDrafts(t *testing.T) {
+	db := setupTestDB(t)
+
+	_, err := db.GetDraft(700)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	draft := &wakey.Draft{
+		UserID:  700,
+		Content: "First Draft",
+	}
+	require.NoError(t, db.SaveDraft(draft))
+
+	// Saving again replaces the previous draft
+	draft = &wakey.Draft{
+		UserID:    700,
+		Content:   "Second Draft",
+		MediaType: wakey.MediaPhoto,
+		FileID:    "photo-file-id",
+	}
+	require.NoError(t, db.SaveDraft(draft))
+
+	fetchedDraft, err := db.GetDraft(700)
+	require.NoError(t, err)
+	require.Equal(t, "Second Draft", fetchedDraft.Content)
+	require.Equal(t, wakey.MediaPhoto, fetchedDraft.MediaType)
+	require.Equal(t, "photo-file-id", fetchedDraft.FileID)
+
+	require.NoError(t, db.DeleteDraft(700))
+	_, err = db.GetDraft(700)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// Deleting a missing draft is not an error
+	require.NoError(t, db.DeleteDraft(700))
+}
+
+func TestReleasePlanOffer(t *testing.T) {
+	db := setupTestDB(t)
+
+	user := &wakey.User{ID: 710, Name: "Offer User"}
+	require.NoError(t, db.CreateUser(user))
+
+	plan := &wakey.Plan{
+		UserID:  710,
+		Content: "Offer Plan",
+		WakeAt:  time.Now().Add(24 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(plan))
+
+	offer, err := db.FindPlanForWish(711)
+	require.NoError(t, err)
+
+	// The plan is locked for other senders
+	_, err = db.FindPlanForWish(712)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// Releasing the offer makes it available right away
+	require.NoError(t, db.ReleasePlanOffer(plan.ID, offer.OfferedAt))
+	foundPlan, err := db.FindPlanForWish(712)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, foundPlan.ID)
+
+	// Once the offer expires the plan goes to another sender,
+	// the first one can't release their lock any more
+	expired := foundPlan.OfferedAt
+	plan.OfferedAt = time.Now().UTC().Add(-2 * time.Hour)
+	require.NoError(t, db.SavePlan(plan))
+	offer, err = db.FindPlanForWish(713)
+	require.NoError(t, err)
+	require.Equal(t, plan.ID, offer.ID)
+
+	require.NoError(t, db.ReleasePlanOffer(plan.ID, expired))
+	_, err = db.FindPlanForWish(714)
+	require.Equal(t, wakey.ErrNotFound, err, "the lock of the current sender is kept")
+
+	require.NoError(t, db.ReleasePlanOffer(plan.ID, offer.OfferedAt))
+	_, err = db.FindPlanForWish(714)
+	require.NoError(t, err)
+}
+
+func TestSenderDigest(t *testing.T) {
+	db := setupTestDB(t)
+
+	users := []*wakey.User{
+		{ID: 800, Name: "Sender"},
+		{ID: 801, Name: "Recipient 1"},
+		{ID: 802, Name: "Recipient 2"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	plans := []*wakey.Plan{
+		{UserID: 801, Content: "Plan 1", WakeAt: time.Now().Add(24 * time.Hour)},
+		{UserID: 802, Content: "Plan 2", WakeAt: time.Now().Add(24 * time.Hour)},
+	}
+	for _, plan := range plans {
+		require.NoError(t, db.SavePlan(plan))
+	}
+
+	now := time.Now().UTC()
+	wishes := []struct {
+		wish *wakey.Wish
+		age  time.Duration
+	}{
+		{&wakey.Wish{FromID: 800, PlanID: plans[0].ID, Content: "New", State: wakey.WishStateNew}, time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[0].ID, Content: "Sent", State: wakey.WishStateSent}, 2 * time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[1].ID, Content: "Liked", State: wakey.WishStateLiked}, 3 * 24 * time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[1].ID, Content: "Disliked", State: wakey.WishStateDisliked}, 5 * 24 * time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[0].ID, Content: "Retracted", State: wakey.WishStateRetracted}, time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[0].ID, Content: "Last week", State: wakey.WishStateLiked}, 10 * 24 * time.Hour},
+		{&wakey.Wish{FromID: 800, PlanID: plans[0].ID, Content: "Month ago", State: wakey.WishStateLiked}, 30 * 24 * time.Hour},
+		{&wakey.Wish{FromID: 801, PlanID: plans[1].ID, Content: "Other sender", State: wakey.WishStateLiked}, time.Hour},
+	}
+	for _, w := range wishes {
+		w.wish.CreatedAt = now.Add(-w.age)
+		require.NoError(t, db.SaveWish(w.wish))
+	}
+
+	digest, err := db.GetSenderDigest(800, now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), digest.Sent)
+	require.Equal(t, int64(3), digest.Delivered)
+	require.Equal(t, int64(2), digest.Read)
+	require.Equal(t, int64(1), digest.Liked)
+	require.Equal(t, int64(2), digest.SupportedUsers)
+	require.Equal(t, 2, digest.WeekStreak)
+
+	// A user without wishes gets an empty digest
+	digest, err = db.GetSenderDigest(802, now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), digest.Sent)
+	require.Equal(t, 0, digest.WeekStreak)
+}
+
+func TestJournal(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 900, Name: "Writer"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 901, Name: "Other"}))
+
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entries := []struct {
+		content string
+		age     time.Duration
+	}{
+		{"Сегодня хорошее настроение", 2 * time.Hour},
+		{"Вчера было 100% спокойно", day},
+		{"Вчера вечером тоже", day - time.Hour},
+		{"Третий день подряд", 2 * day},
+		{"Давно, начало длинной серии", 10 * day},
+		{"Длинная серия", 9 * day},
+		{"Длинная серия продолжается", 8 * day},
+		{"Конец длинной серии", 7 * day},
+	}
+	for _, entry := range entries {
+		plan := &wakey.Plan{UserID: 900, Content: entry.content, WakeAt: now}
+		plan.CreatedAt = now.Add(-entry.age)
+		require.NoError(t, db.SavePlan(plan))
+	}
+	require.NoError(t, db.SavePlan(&wakey.Plan{UserID: 901, Content: "Длинная серия чужая", WakeAt: now}))
+
+	// Paging goes from the newest to the oldest
+	plans, total, err := db.GetPlansPage(900, 0, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), total)
+	require.Len(t, plans, 3)
+	require.Equal(t, "Сегодня хорошее настроение", plans[0].Content)
+	require.Equal(t, "Вчера вечером тоже", plans[1].Content)
+
+	plans, _, err = db.GetPlansPage(900, 6, 3)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	require.Equal(t, "Давно, начало длинной серии", plans[1].Content)
+
+	// Search is limited to the user's own plans
+	plans, err = db.SearchPlans(900, "длинн", 10)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	for _, plan := range plans {
+		require.Equal(t, int64(900), plan.UserID)
+	}
+
+	// Wildcards are matched literally
+	plans, err = db.SearchPlans(900, "100%", 10)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+
+	plans, err = db.SearchPlans(900, "%", 10)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+
+	plans, err = db.SearchPlans(900, "_", 10)
+	require.NoError(t, err)
+	require.Empty(t, plans)
+
+	current, longest, err := db.GetPlanStreaks(900, time.UTC, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, current)
+	require.Equal(t, 4, longest)
+
+	// The streak is kept until the end of the next day
+	current, _, err = db.GetPlanStreaks(900, time.UTC, now.Add(day))
+	require.NoError(t, err)
+	require.Equal(t, 3, current)
+
+	current, longest, err = db.GetPlanStreaks(900, time.UTC, now.Add(2*day))
+	require.NoError(t, err)
+	require.Equal(t, 0, current)
+	require.Equal(t, 4, longest)
+
+	// Days are counted in the user's timezone
+	current, _, err = db.GetPlanStreaks(900, time.FixedZone("UTC-11", -11*60*60), now)
+	require.NoError(t, err)
+	require.Equal(t, 2, current)
+
+	current, longest, err = db.GetPlanStreaks(902, time.UTC, now)
+	require.NoError(t, err)
+	require.Zero(t, current)
+	require.Zero(t, longest)
+}
+
+func TestPlanMood(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 1000, Name: "Owner"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 1001, Name: "Stranger"}))
+
+	plan := &wakey.Plan{UserID: 1000, Content: "Mood plan", WakeAt: time.Now().Add(24 * time.Hour)}
+	require.NoError(t, db.SavePlan(plan))
+
+	// Mood rating
+	require.NoError(t, db.SetPlanMood(plan.ID, 1000, 4))
+	require.Error(t, db.SetPlanMood(plan.ID, 1000, 0))
+	require.Error(t, db.SetPlanMood(plan.ID, 1000, 6))
+	require.Equal(t, wakey.ErrNotFound, db.SetPlanMood(plan.ID, 1001, 2))
+
+	fetchedPlan, err := db.GetPlanByID(plan.ID)
+	require.NoError(t, err)
+	require.Equal(t, int8(4), fetchedPlan.Mood)
+
+	// Tags are toggled
+	selected, err := db.TogglePlanTag(plan.ID, 1000, wakey.TagFeeling, "calm")
+	require.NoError(t, err)
+	require.True(t, selected)
+
+	selected, err = db.TogglePlanTag(plan.ID, 1000, wakey.TagNeed, "rest")
+	require.NoError(t, err)
+	require.True(t, selected)
+
+	selected, err = db.TogglePlanTag(plan.ID, 1000, wakey.TagFeeling, "anxiety")
+	require.NoError(t, err)
+	require.True(t, selected)
+
+	selected, err = db.TogglePlanTag(plan.ID, 1000, wakey.TagFeeling, "anxiety")
+	require.NoError(t, err)
+	require.False(t, selected)
+
+	// Unknown tags and other users' plans are rejected
+	_, err = db.TogglePlanTag(plan.ID, 1000, wakey.TagFeeling, "rest")
+	require.Error(t, err)
+	_, err = db.TogglePlanTag(plan.ID, 1001, wakey.TagFeeling, "calm")
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	tags, err := db.GetPlanTags(plan.ID)
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+	require.Equal(t, "calm", tags[0].Tag)
+	require.Equal(t, wakey.TagNeed, tags[1].Kind)
+
+	entries, err := db.GetMoodEntries(1000, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, int8(4), entries[0].Mood)
+	require.Len(t, entries[0].Tags, 2)
+
+	// Reset clears both the rating and the tags
+	require.Equal(t, wakey.ErrNotFound, db.ResetPlanMood(plan.ID, 1001))
+	require.NoError(t, db.ResetPlanMood(plan.ID, 1000))
+
+	entries, err = db.GetMoodEntries(1000, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Zero(t, entries[0].Mood)
+	require.Empty(t, entries[0].Tags)
+
+	entries, err = db.GetMoodEntries(1001, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to wakey.WishState
+		allowed  bool
+	}{
+		{wakey.WishStateNew, wakey.WishStateSent, true},
+		{wakey.WishStateNew, wakey.WishStateHeld, true},
+		{wakey.WishStateHeld, wakey.WishStateNew, true},
+		{wakey.WishStateHeld, wakey.WishStateBanned, true},
+		{wakey.WishStateSent, wakey.WishStateRead, true},
+		{wakey.WishStateRead, wakey.WishStateReported, true},
+		{wakey.WishStateNew, wakey.WishStateLiked, false},
+		{wakey.WishStateHeld, wakey.WishStateSent, false},
+		{wakey.WishStateSent, wakey.WishStateRetracted, false},
+		{wakey.WishStateRead, wakey.WishStateSent, false},
+		{wakey.WishStateLiked, wakey.WishStateDisliked, false},
+		{wakey.WishStateBanned, wakey.WishStateNew, false},
+		{wakey.WishStateRetracted, wakey.WishStateNew, false},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.allowed, wakey.CanTransition(tc.from, tc.to), "%s -> %s", tc.from, tc.to)
+	}
+}
+
+func TestWishHistory(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 60, Name: "History Recipient"}))
+	plan := &wakey.Plan{UserID: 60, Content: "History Plan", WakeAt: time.Now()}
+	require.NoError(t, db.SavePlan(plan))
+
+	wish := &wakey.Wish{FromID: 61, PlanID: plan.ID, Content: "History Wish"}
+	require.NoError(t, db.SaveWish(wish))
+
+	// A wish under review is held back from the delivery until it's released
+	require.NoError(t, db.MarkWishForReview(wish.ID, 0))
+	fetched, err := db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateHeld, fetched.State)
+
+	err = db.UpdateWishState(wish.ID, wakey.WishStateSent, wakey.BotActorID)
+	var transitionErr *wakey.WishTransitionError
+	require.ErrorAs(t, err, &transitionErr)
+
+	released, err := db.ReleaseHeldWishes(61, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, released)
+
+	require.NoError(t, db.UpdateWishState(wish.ID, wakey.WishStateSent, wakey.BotActorID))
+	require.NoError(t, db.MarkWishesRead(60))
+	_, err = db.ReactToWish(wish.ID, 60, wakey.WishStateLiked)
+	require.NoError(t, err)
+
+	history, err := db.GetWishHistory(wish.ID)
+	require.NoError(t, err)
+
+	type step struct {
+		from, to wakey.WishState
+		actor    int64
+	}
+	expected := []step{
+		{wakey.WishStateNew, wakey.WishStateHeld, wakey.BotActorID},
+		{wakey.WishStateHeld, wakey.WishStateNew, 1},
+		{wakey.WishStateNew, wakey.WishStateSent, wakey.BotActorID},
+		{wakey.WishStateSent, wakey.WishStateRead, 60},
+		{wakey.WishStateRead, wakey.WishStateLiked, 60},
+	}
+	require.Len(t, history, len(expected))
+	for i, transition := range history {
+		require.Equal(t, expected[i], step{transition.FromState, transition.ToState, transition.ActorID}, "step %d", i)
+		require.False(t, transition.CreatedAt.IsZero())
+	}
+
+	// Failed transitions leave no trace
+	err = db.UpdateWishState(wish.ID, wakey.WishStateDisliked, 60)
+	require.ErrorAs(t, err, &transitionErr)
+	history, err = db.GetWishHistory(wish.ID)
+	require.NoError(t, err)
+	require.Len(t, history, len(expected))
+}
+
+func TestReportDelivery(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 70, Name: "Stats Recipient"}))
+	plan := &wakey.Plan{UserID: 70, Content: "Stats Plan", WakeAt: time.Now()}
+	require.NoError(t, db.SavePlan(plan))
+
+	writtenAt := []time.Duration{2 * time.Hour, 4 * time.Hour, 9 * time.Hour}
+	var wishes []*wakey.Wish
+	for _, ago := range writtenAt {
+		wish := &wakey.Wish{FromID: 71, PlanID: plan.ID, Content: "Stats Wish"}
+		wish.CreatedAt = time.Now().Add(-ago)
+		require.NoError(t, db.SaveWish(wish))
+		require.NoError(t, db.UpdateWishState(wish.ID, wakey.WishStateSent, wakey.BotActorID))
+		wishes = append(wishes, wish)
+	}
+
+	// A wish that wasn't delivered doesn't count
+	require.NoError(t, db.SaveWish(&wakey.Wish{FromID: 71, PlanID: plan.ID, Content: "Waiting Wish"}))
+
+	_, err := db.ReactToWish(wishes[0].ID, 70, wakey.WishStateLiked)
+	require.NoError(t, err)
+	_, err = db.ReactToWish(wishes[1].ID, 70, wakey.WishStateReported)
+	require.NoError(t, err)
+
+	from, to, err := wakey.ParseReportPeriod("", time.Now(), 7, 0)
+	require.NoError(t, err)
+	data, err := db.GetReportData(wakey.ReportDataSince(from, to), to)
+	require.NoError(t, err)
+	report := wakey.BuildReport(data, from, to)
+
+	require.Equal(t, 3, report.Delivered)
+	require.InDelta(t, 4.0, report.MedianDeliveryHours, 0.01)
+	require.InDelta(t, 100.0/3, report.LikedPercent, 0.01)
+	require.Zero(t, report.DislikedPercent)
+	require.InDelta(t, 100.0/3, report.ReportedPercent, 0.01)
+}
@@ -0,0 +1,59 @@
+package wakey_test
+
+import (
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextDigestTime(t *testing.T) {
+	// Wednesday, 10:00 UTC
+	now := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		user     *wakey.User
+		expected time.Time
+	}{
+		{
+			name:     "later this week at notification time",
+			user:     &wakey.User{DigestDay: time.Friday, NotifyAt: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)},
+			expected: time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "today before notification time",
+			user:     &wakey.User{DigestDay: time.Wednesday, NotifyAt: time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)},
+			expected: time.Date(2024, 5, 15, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "today after notification time",
+			user:     &wakey.User{DigestDay: time.Wednesday, NotifyAt: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+			expected: time.Date(2024, 5, 22, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "default hour without notifications",
+			user:     &wakey.User{DigestDay: time.Monday},
+			expected: time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekday in user timezone",
+			// 23:30 UTC+3 on Thursday is 20:30 UTC on Thursday
+			user:     &wakey.User{Tz: 180, DigestDay: time.Thursday, NotifyAt: time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC)},
+			expected: time.Date(2024, 5, 16, 20, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "local day differs from UTC day",
+			// 01:00 UTC+3 on Thursday is 22:00 UTC on Wednesday
+			user:     &wakey.User{Tz: 180, DigestDay: time.Thursday, NotifyAt: time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)},
+			expected: time.Date(2024, 5, 15, 22, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, wakey.NextDigestTime(tt.user, now))
+		})
+	}
+}
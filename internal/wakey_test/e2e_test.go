@@ -0,0 +1,256 @@
+package wakey_test
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// testApp is the bot wired like in main.go, talking to the fake Telegram server
+type testApp struct {
+	tg      *fakeTelegram
+	db      *wakey.DB
+	wishes  *wakey.WishHandler
+	reports *wakey.ReportHandler
+}
+
+func newTestApp(t *testing.T, cfg wakey.Config) *testApp {
+	// The plans prompt sends the pictures from the data directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir("../.."))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	tg := newFakeTelegram(t)
+	db := setupTestDB(t)
+	log := zap.NewNop().Sugar()
+
+	stateMan := wakey.NewStateManager()
+	validator, err := wakey.NewValidator(wakey.ValidationConfig{}, db)
+	require.NoError(t, err)
+
+	limiter := wakey.NewRateLimiter(cfg.RateLimit)
+	callbacks := newTestCallbacks(t)
+	bot := wakey.NewBot(db, stateMan, limiter, callbacks)
+	flows := wakey.NewFlowRunner(stateMan, callbacks, log)
+	wishSched := wakey.NewSched(10)
+	planHandler := wakey.NewPlanHandler(db, tg.api, wakey.NewSched(10), wishSched, flows, validator, stateMan, callbacks, log)
+	wishHandler := wakey.NewWishHandler(db, tg.api, wishSched, nil, flows, validator, stateMan, callbacks, log)
+	reportHandler := wakey.NewReportHandler(db, tg.api, wakey.NewSched(10), cfg.Reports, log, cfg.AdminID)
+	handlers := []wakey.BotHandler{
+		planHandler,
+		wishHandler,
+		wakey.NewProfileHandler(db, flows, validator, stateMan, callbacks, log),
+		wakey.NewAdminHandler(db, tg.api, flows, limiter, callbacks, log, cfg.AdminID, 70),
+		wakey.NewDigestHandler(db, tg.api, wakey.NewSched(10), stateMan, callbacks, log),
+		wakey.NewJournalHandler(db, flows, stateMan, callbacks, log),
+		wakey.NewMoodHandler(db, stateMan, callbacks, log),
+		wakey.NewGeneralHandler(db, flows, stateMan, callbacks, log, tg.api.Me.Username),
+		reportHandler,
+		flows,
+	}
+
+	bot.Start(cfg, tg.api, handlers)
+	t.Cleanup(bot.Stop)
+
+	return &testApp{tg: tg, db: db, wishes: wishHandler, reports: reportHandler}
+}
+
+// register walks the user through the registration and the first plans
+func (app *testApp) register(t *testing.T, user *tele.User, name, plans string) {
+	app.registerWith(t, user, "/start", name, plans)
+}
+
+// registerWith registers the user who opened the bot with the start command,
+// like the one of a deep link
+func (app *testApp) registerWith(t *testing.T, user *tele.User, start, name, plans string) {
+	tg := app.tg
+
+	tg.sendText(user, start)
+	require.Equal(t, wakey.T("en", "profile.ask_name"), tg.lastTo(user.ID).Text)
+
+	tg.sendText(user, name)
+	require.Equal(t, wakey.T("en", "profile.ask_bio", name), tg.lastTo(user.ID).Text)
+
+	tg.sendText(user, "Early bird")
+	require.Equal(t, wakey.T("en", "profile.ask_time"), tg.lastTo(user.ID).Text)
+
+	// The local time three hours ahead of UTC
+	tg.sendText(user, time.Now().UTC().Add(3*time.Hour).Format("15:04"))
+	require.Equal(t, wakey.T("en", "profile.ask_notify_time", wakey.T("en", "time.disable_word")), tg.lastTo(user.ID).Text)
+
+	tg.sendText(user, wakey.T("en", "time.disable_word"))
+	texts := tg.textsTo(user.ID)
+	require.Contains(t, texts, wakey.T("en", "plan.registration_done", wakey.T("en", "plan.notifications_disabled")))
+	require.Equal(t, wakey.T("en", "plan.ask_plans"), texts[len(texts)-2], "the album with the plans prompt")
+
+	tg.sendText(user, plans)
+	require.Equal(t, wakey.T("en", "plan.ask_wake_time"), tg.lastTo(user.ID).Text)
+
+	tg.sendText(user, "07:30")
+	require.Equal(t, wakey.T("en", "plan.ask_mood"), tg.lastTo(user.ID).Text)
+
+	tg.press(user, tg.lastTo(user.ID), "mood_skip")
+	require.Equal(t, wakey.T("en", "plan.ask_wish"), tg.lastTo(user.ID).Text)
+
+	registered, err := app.db.GetUserByID(user.ID)
+	require.NoError(t, err)
+	require.Equal(t, name, registered.Name)
+	require.Equal(t, int32(180), registered.Tz)
+}
+
+func TestEndToEndWish(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 1001, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 1002, FirstName: "Bob", LanguageCode: "en"}
+
+	// Alice registers and doesn't want to write a wish
+	app.register(t, alice, "Alice", "A morning run in the park")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+	require.Equal(t, wakey.T("en", "general.menu"), tg.lastTo(alice.ID).Text)
+
+	// Bob registers and is offered Alice's plans
+	app.register(t, bob, "Bob", "Coffee and a good book")
+	tg.press(bob, tg.lastTo(bob.ID), "send_wish_yes")
+	offer := tg.lastWithButton(bob.ID, "block_recipient")
+	require.Contains(t, offer.Text, "A morning run in the park")
+
+	// An invalid wish keeps Bob on the step
+	tg.sendText(bob, "👍")
+	require.Equal(t, wakey.TN("en", "validation.too_few_letters", 5, 5), tg.lastTo(bob.ID).Text)
+
+	tg.sendText(bob, "Have a wonderful run!")
+	saved := tg.lastWithButton(bob.ID, "edit_wish")
+	require.Equal(t, wakey.T("en", "wish.saved"), saved.Text)
+	require.Equal(t, wakey.T("en", "general.menu"), tg.lastTo(bob.ID).Text)
+
+	// The wish is delivered at Alice's wake time
+	app.wishes.SendWishes(wakey.JobID(alice.ID))
+	delivered := tg.lastTo(alice.ID)
+	require.Equal(t, "Have a wonderful run!", delivered.Text)
+
+	// Alice likes the wish and Bob is thanked
+	tg.press(alice, delivered, "wish_like")
+	require.Equal(t, wakey.T("en", "wish.like_sent"), tg.lastTo(alice.ID).Text)
+	require.Contains(t, tg.textsTo(bob.ID), wakey.T("en", "wish.liked_notice", "Alice"))
+
+	// The pressed button is shown in place of the keyboard
+	msgs := tg.messagesTo(alice.ID)
+	liked := msgs[len(msgs)-2]
+	require.Equal(t, "Have a wonderful run!\n\n"+wakey.T("en", "btn.wish_like"), liked.Text)
+	require.Nil(t, liked.ReplyMarkup)
+
+	btn, _ := findButton(&delivered, "wish_like")
+	wishID, err := strconv.ParseUint(strings.Split(btn.Data, "|")[1], 10, 64)
+	require.NoError(t, err)
+	wish, err := app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateLiked, wish.State)
+
+	// A rated wish can't be reported afterwards
+	tg.press(alice, delivered, "wish_report")
+	require.Equal(t, wakey.T("en", "wish.already_rated"), tg.lastTo(alice.ID).Text)
+	wish, err = app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateLiked, wish.State)
+}
+
+func TestEndToEndWishRead(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 1101, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 1102, FirstName: "Bob", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Painting the fence")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+
+	app.register(t, bob, "Bob", "Fixing the bike")
+	tg.press(bob, tg.lastTo(bob.ID), "send_wish_yes")
+	tg.sendText(bob, "Enjoy the painting!")
+
+	app.wishes.SendWishes(wakey.JobID(alice.ID))
+	delivered := tg.lastTo(alice.ID)
+	btn, _ := findButton(&delivered, "wish_like")
+	wishID, err := strconv.ParseUint(strings.Split(btn.Data, "|")[1], 10, 64)
+	require.NoError(t, err)
+
+	// A button press doesn't count as coming back to read the wish
+	tg.press(alice, tg.lastWithButton(alice.ID, "invite_friends"), "invite_friends")
+	wish, err := app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateSent, wish.State)
+
+	// A message does
+	tg.sendText(alice, "/cancel")
+	wish, err = app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateRead, wish.State)
+}
+
+func TestEndToEndEditedWishDelivery(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 1201, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 1202, FirstName: "Bob", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Learning to swim")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+	app.register(t, bob, "Bob", "Cooking dinner")
+	tg.press(bob, tg.lastTo(bob.ID), "send_wish_yes")
+
+	tg.sendText(bob, "Enjoy the pool!")
+	saved := tg.lastWithButton(bob.ID, "edit_wish")
+	btn, _ := findButton(&saved, "edit_wish")
+	wishID, err := strconv.ParseUint(strings.Split(btn.Data, "|")[1], 10, 64)
+	require.NoError(t, err)
+
+	tg.press(bob, saved, "edit_wish")
+	tg.sendText(bob, "Enjoy the swimming pool!")
+
+	// The edited wish waits for the verdict on the new text
+	received := len(tg.messagesTo(alice.ID))
+	app.wishes.SendWishes(wakey.JobID(alice.ID))
+	require.Len(t, tg.messagesTo(alice.ID), received)
+
+	wish, err := app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.NoError(t, app.db.UpdateWishToxicity(wish.ID, wish.ContentVersion, 0))
+
+	app.wishes.SendWishes(wakey.JobID(alice.ID))
+	require.Equal(t, "Enjoy the swimming pool!", tg.lastTo(alice.ID).Text)
+}
+
+func TestEndToEndUnblock(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	alice := &tele.User{ID: 1011, FirstName: "Alice", LanguageCode: "en"}
+	app.register(t, alice, "Alice", "Yoga")
+	require.NoError(t, app.db.BlockUser(alice.ID, 1012, "Someone"))
+
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+	tg.press(alice, tg.lastTo(alice.ID), "show_blocks")
+	blocks := tg.lastTo(alice.ID)
+	require.Equal(t, wakey.T("en", "profile.blocks"), blocks.Text)
+
+	// Unblocking edits the list in place and answers the callback
+	tg.press(alice, blocks, "unblock")
+	require.Equal(t, wakey.T("en", "profile.unblocked"), tg.lastAnswer().Text)
+	require.Equal(t, wakey.T("en", "profile.no_more_blocks"), tg.lastTo(alice.ID).Text)
+	require.Nil(t, tg.lastTo(alice.ID).ReplyMarkup)
+
+	isBlocked, err := app.db.IsBlocked(alice.ID, 1012)
+	require.NoError(t, err)
+	require.False(t, isBlocked)
+}
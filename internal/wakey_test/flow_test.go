@@ -0,0 +1,205 @@
+package wakey_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeContext implements the parts of tele.Context used by the flow runner.
+// Calling any other method panics.
+type fakeContext struct {
+	tele.Context
+	sender *tele.User
+	msg    *tele.Message
+	data   string
+	store  map[string]interface{}
+	sent   []interface{}
+}
+
+func newFakeContext(userID int64) *fakeContext {
+	return &fakeContext{
+		sender: &tele.User{ID: userID, LanguageCode: "en"},
+		msg:    &tele.Message{},
+		store:  make(map[string]interface{}),
+	}
+}
+
+func (c *fakeContext) Sender() *tele.User            { return c.sender }
+func (c *fakeContext) Message() *tele.Message        { return c.msg }
+func (c *fakeContext) Text() string                  { return c.msg.Text }
+func (c *fakeContext) Data() string                  { return c.data }
+func (c *fakeContext) Get(key string) interface{}    { return c.store[key] }
+func (c *fakeContext) Set(key string, v interface{}) { c.store[key] = v }
+
+func (c *fakeContext) Send(what interface{}, opts ...interface{}) error {
+	c.sent = append(c.sent, what)
+	return nil
+}
+
+// input makes the context carry a new text message from the user
+func (c *fakeContext) input(text string) *fakeContext {
+	c.msg = &tele.Message{Text: text}
+	c.sent = nil
+	return c
+}
+
+// press makes the context carry a callback with the data
+func (c *fakeContext) press(data string) *fakeContext {
+	c.data = data
+	c.sent = nil
+	return c
+}
+
+func (c *fakeContext) lastSent() interface{} {
+	if len(c.sent) == 0 {
+		return nil
+	}
+	return c.sent[len(c.sent)-1]
+}
+
+func TestFlowRunner(t *testing.T) {
+	stateMan := wakey.NewStateManager()
+	runner := wakey.NewFlowRunner(stateMan, newTestCallbacks(t), zap.NewNop().Sugar())
+
+	var completed *wakey.UserData
+	runner.Register(&wakey.Flow{
+		ID: "test",
+		Steps: []wakey.FlowStep{
+			{
+				State:  wakey.StateAwaitingName,
+				Prompt: "profile.ask_name",
+				Accept: func(c tele.Context, data *wakey.UserData) error {
+					data.Name = c.Text()
+					return nil
+				},
+			},
+			{
+				State:  wakey.StateAwaitingBio,
+				Prompt: "profile.ask_bio",
+				PromptArgs: func(c tele.Context, data *wakey.UserData) []any {
+					return []any{data.Name}
+				},
+				Validate: func(c tele.Context) error {
+					if len(c.Text()) < 3 {
+						return errors.New("too short")
+					}
+					return nil
+				},
+				Accept: func(c tele.Context, data *wakey.UserData) error {
+					data.Bio = c.Text()
+					return nil
+				},
+			},
+		},
+		Back: true,
+		OnComplete: func(c tele.Context, data *wakey.UserData) error {
+			completed = data
+			return c.Send("done")
+		},
+	})
+	require.ElementsMatch(t, []wakey.UserState{wakey.StateAwaitingName, wakey.StateAwaitingBio}, runner.States())
+
+	userID := int64(1)
+	c := newFakeContext(userID)
+
+	// Starting the flow sends the first prompt
+	require.NoError(t, runner.Start(c, "test", nil))
+	require.Equal(t, wakey.T("en", "profile.ask_name"), c.lastSent())
+	state, _ := stateMan.GetState(userID)
+	require.Equal(t, wakey.StateAwaitingName, state)
+
+	// The accepted input moves the user to the next step
+	require.NoError(t, runner.HandleState(c.input("Alice"), wakey.StateAwaitingName))
+	require.Equal(t, wakey.T("en", "profile.ask_bio", "Alice"), c.lastSent())
+	state, _ = stateMan.GetState(userID)
+	require.Equal(t, wakey.StateAwaitingBio, state)
+
+	// Media isn't accepted by text steps
+	c.input("")
+	c.msg.Photo = &tele.Photo{File: tele.File{FileID: "photo"}}
+	require.NoError(t, runner.HandleState(c, wakey.StateAwaitingBio))
+	require.Equal(t, wakey.T("en", "bot.text_only"), c.lastSent())
+
+	// A validation error keeps the user on the step
+	require.NoError(t, runner.HandleState(c.input("Hi"), wakey.StateAwaitingBio))
+	require.Equal(t, "too short", c.lastSent())
+	state, _ = stateMan.GetState(userID)
+	require.Equal(t, wakey.StateAwaitingBio, state)
+
+	// Back returns to the previous step, stale back buttons are rejected
+	require.NoError(t, runner.HandleAction(c.press(fmt.Sprintf("flow_back|%d", wakey.StateAwaitingName)), "flow_back"))
+	require.Equal(t, wakey.T("en", "flow.back_outdated"), c.lastSent())
+	require.NoError(t, runner.HandleAction(c.press(fmt.Sprintf("flow_back|%d", wakey.StateAwaitingBio)), "flow_back"))
+	require.Equal(t, wakey.T("en", "profile.ask_name"), c.lastSent())
+	state, _ = stateMan.GetState(userID)
+	require.Equal(t, wakey.StateAwaitingName, state)
+
+	require.NoError(t, runner.HandleState(c.input("Bob"), wakey.StateAwaitingName))
+	require.NoError(t, runner.HandleState(c.input("Early bird"), wakey.StateAwaitingBio))
+	require.Equal(t, "done", c.lastSent())
+	require.NotNil(t, completed)
+	require.Equal(t, "Bob", completed.Name)
+	require.Equal(t, "Early bird", completed.Bio)
+	state, _ = stateMan.GetState(userID)
+	require.Equal(t, wakey.StateNone, state)
+
+	// Unknown flows are reported to the user
+	require.NoError(t, runner.Start(c.input(""), "unknown", nil))
+	require.Equal(t, wakey.T("en", "common.error"), c.lastSent())
+}
+
+func TestFlowRunnerCancel(t *testing.T) {
+	stateMan := wakey.NewStateManager()
+	runner := wakey.NewFlowRunner(stateMan, newTestCallbacks(t), zap.NewNop().Sugar())
+
+	cancelled := false
+	runner.Register(
+		&wakey.Flow{
+			ID:    "plain",
+			Steps: []wakey.FlowStep{{State: wakey.StateAwaitingName, Prompt: "profile.ask_name"}},
+		},
+		&wakey.Flow{
+			ID:    "custom",
+			Steps: []wakey.FlowStep{{State: wakey.StateAwaitingBio, Prompt: "profile.ask_bio", Media: true}},
+			OnCancel: func(c tele.Context, data *wakey.UserData) error {
+				cancelled = true
+				return nil
+			},
+		},
+	)
+
+	userID := int64(2)
+	c := newFakeContext(userID)
+
+	// Nothing happens outside of a flow
+	require.NoError(t, runner.Cancel(c))
+	require.Empty(t, c.sent)
+
+	require.NoError(t, runner.Start(c, "plain", nil))
+	require.NoError(t, runner.Cancel(c.input("/cancel")))
+	require.Equal(t, wakey.T("en", "general.cancelled"), c.lastSent())
+	_, exists := stateMan.GetState(userID)
+	require.False(t, exists)
+
+	// OnCancel replaces the default reply
+	require.NoError(t, runner.Start(c, "custom", nil))
+	require.NoError(t, runner.Cancel(c.input("/cancel")))
+	require.True(t, cancelled)
+	require.Empty(t, c.sent)
+	_, exists = stateMan.GetState(userID)
+	require.False(t, exists)
+
+	// A state can belong to one flow only
+	require.Panics(t, func() {
+		runner.Register(&wakey.Flow{
+			ID:    "duplicate",
+			Steps: []wakey.FlowStep{{State: wakey.StateAwaitingName, Prompt: "profile.ask_name"}},
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package wakey_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	messageKeyRe = regexp.MustCompile(`^[a-z]+\.[a-z_]+$`)
+	formatVerbRe = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z]`)
+)
+
+func TestCatalogKeysMatch(t *testing.T) {
+	langs := wakey.Languages()
+	require.Contains(t, langs, wakey.DefaultLang)
+	require.Contains(t, langs, "en")
+
+	defaultKeys := wakey.MessageKeys(wakey.DefaultLang)
+	require.NotEmpty(t, defaultKeys)
+	for _, lang := range langs {
+		require.Equal(t, defaultKeys, wakey.MessageKeys(lang), "keys of %s differ from %s", lang, wakey.DefaultLang)
+	}
+}
+
+func TestCatalogPluralForms(t *testing.T) {
+	expected := map[string][]string{
+		"ru": {"few", "many", "one"},
+		"en": {"one", "other"},
+	}
+
+	for _, lang := range wakey.Languages() {
+		require.Contains(t, expected, lang, "no expected plural forms for %s", lang)
+		for _, key := range wakey.MessageKeys(lang) {
+			forms := wakey.PluralForms(lang, key)
+			defaultForms := wakey.PluralForms(wakey.DefaultLang, key)
+			require.Equal(t, len(defaultForms) > 0, len(forms) > 0, "%s: %s must be plural in every language", lang, key)
+			if len(forms) > 0 {
+				require.Equal(t, expected[lang], forms, "%s: %s", lang, key)
+			}
+		}
+	}
+}
+
+func TestCatalogFormatVerbs(t *testing.T) {
+	verbs := func(text string) []string {
+		return formatVerbRe.FindAllString(strings.ReplaceAll(text, "%%", ""), -1)
+	}
+
+	for _, key := range wakey.MessageKeys(wakey.DefaultLang) {
+		// Numbers hitting every plural category of the supported languages
+		for _, n := range []int64{1, 2, 5} {
+			expected := verbs(wakey.TN(wakey.DefaultLang, key, n))
+			for _, lang := range wakey.Languages() {
+				require.Equal(t, expected, verbs(wakey.TN(lang, key, n)), "%s: %s", lang, key)
+			}
+		}
+	}
+}
+
+func TestPlurals(t *testing.T) {
+	cases := []struct {
+		lang     string
+		n        int64
+		category string
+	}{
+		{"ru", 1, "one"},
+		{"ru", 21, "one"},
+		{"ru", 2, "few"},
+		{"ru", 34, "few"},
+		{"ru", 5, "many"},
+		{"ru", 11, "many"},
+		{"ru", 12, "many"},
+		{"ru", 111, "many"},
+		{"ru", 0, "many"},
+		{"en", 1, "one"},
+		{"en", 0, "other"},
+		{"en", 21, "other"},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.category, wakey.PluralCategory(tc.lang, tc.n), "%s %d", tc.lang, tc.n)
+	}
+
+	require.Equal(t, "📊 21 день", wakey.TN("ru", "btn.mood_chart", 21, 21))
+	require.Equal(t, "📊 3 дня", wakey.TN("ru", "btn.mood_chart", 3, 3))
+	require.Equal(t, "📊 30 дней", wakey.TN("ru", "btn.mood_chart", 30, 30))
+	require.Equal(t, "📊 1 day", wakey.TN("en", "btn.mood_chart", 1, 1))
+	require.Equal(t, "📊 90 days", wakey.TN("en", "btn.mood_chart", 90, 90))
+}
+
+func TestTranslate(t *testing.T) {
+	require.Equal(t, "Mood: 4", wakey.T("en", "mood.rating", "4"))
+	require.Equal(t, "Настроение: 4", wakey.T("ru", "mood.rating", "4"))
+
+	// Unknown languages fall back to the default one
+	require.Equal(t, wakey.T(wakey.DefaultLang, "common.error"), wakey.T("", "common.error"))
+	require.Equal(t, wakey.T(wakey.DefaultLang, "common.error"), wakey.T("de", "common.error"))
+
+	// Unknown keys are returned as is
+	require.Equal(t, "no.such_key", wakey.T("en", "no.such_key"))
+
+	require.Equal(t, "en", wakey.SupportedLang("en-US"))
+	require.Equal(t, "ru", wakey.SupportedLang("ru"))
+	require.Equal(t, wakey.DefaultLang, wakey.SupportedLang("de"))
+	require.Equal(t, wakey.DefaultLang, wakey.SupportedLang(""))
+}
+
+// TestSourceMessageKeys checks that every message key used by the bot exists
+// in the catalogs. Keys are string literals like "wish.saved" whose section
+// is one of the catalog sections.
+func TestSourceMessageKeys(t *testing.T) {
+	known := make(map[string]bool)
+	sections := make(map[string]bool)
+	for _, key := range wakey.MessageKeys(wakey.DefaultLang) {
+		known[key] = true
+		section, _, _ := strings.Cut(key, ".")
+		sections[section] = true
+	}
+
+	files, err := filepath.Glob("../wakey/*.go")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	fset := token.NewFileSet()
+	used := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		require.NoError(t, err)
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+
+			value, err := strconv.Unquote(lit.Value)
+			if err != nil || !messageKeyRe.MatchString(value) {
+				return true
+			}
+			section, _, _ := strings.Cut(value, ".")
+			if !sections[section] {
+				return true
+			}
+
+			used++
+			require.True(t, known[value], "%s: unknown message key %q", fset.Position(lit.Pos()), value)
+			return true
+		})
+	}
+	require.NotZero(t, used)
+}
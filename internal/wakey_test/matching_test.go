@@ -0,0 +1,167 @@
+package wakey_test
+
+import (
+	"testing"
+	"time"
+
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func candidate(id uint, wakeIn time.Duration, now time.Time) wakey.MatchCandidate {
+	return wakey.MatchCandidate{
+		Plan: wakey.Plan{
+			Model:  gorm.Model{ID: id},
+			WakeAt: now.Add(wakeIn),
+		},
+	}
+}
+
+func TestFairMatchStrategy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	strategy := wakey.NewFairMatchStrategy()
+
+	tests := []struct {
+		name       string
+		candidates func() []wakey.MatchCandidate
+		expected   uint
+	}{
+		{
+			name: "prefers soonest waking plan",
+			candidates: func() []wakey.MatchCandidate {
+				return []wakey.MatchCandidate{
+					candidate(1, 20*time.Hour, now),
+					candidate(2, 5*time.Hour, now),
+					candidate(3, 10*time.Hour, now),
+				}
+			},
+			expected: 2,
+		},
+		{
+			name: "prefers recipient with fewer recent wishes",
+			candidates: func() []wakey.MatchCandidate {
+				busy := candidate(1, 5*time.Hour, now)
+				busy.RecentWishes = 3
+				quiet := candidate(2, 10*time.Hour, now)
+				return []wakey.MatchCandidate{busy, quiet}
+			},
+			expected: 2,
+		},
+		{
+			name: "avoids repeated pairs",
+			candidates: func() []wakey.MatchCandidate {
+				repeated := candidate(1, 5*time.Hour, now)
+				repeated.PairWishes = 1
+				fresh := candidate(2, 5*time.Hour, now)
+				fresh.RecentWishes = 2
+				return []wakey.MatchCandidate{repeated, fresh}
+			},
+			expected: 2,
+		},
+		{
+			name: "prefers plans with fewer wishes",
+			candidates: func() []wakey.MatchCandidate {
+				filled := candidate(1, 5*time.Hour, now)
+				filled.PlanWishes = 1
+				empty := candidate(2, 20*time.Hour, now)
+				return []wakey.MatchCandidate{filled, empty}
+			},
+			expected: 2,
+		},
+		{
+			name: "breaks ties by plan id",
+			candidates: func() []wakey.MatchCandidate {
+				return []wakey.MatchCandidate{
+					candidate(7, 5*time.Hour, now),
+					candidate(3, 5*time.Hour, now),
+					candidate(5, 5*time.Hour, now),
+				}
+			},
+			expected: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := tt.candidates()
+			idx, ok := strategy.Choose(candidates, now)
+			require.True(t, ok)
+			require.Equal(t, tt.expected, candidates[idx].ID)
+		})
+	}
+
+	t.Run("no candidates", func(t *testing.T) {
+		_, ok := strategy.Choose(nil, now)
+		require.False(t, ok)
+	})
+}
+
+func TestNewMatchStrategy(t *testing.T) {
+	strategy, err := wakey.NewMatchStrategy(wakey.MatchingConfig{})
+	require.NoError(t, err)
+	require.IsType(t, &wakey.FairMatchStrategy{}, strategy)
+
+	strategy, err = wakey.NewMatchStrategy(wakey.MatchingConfig{Strategy: wakey.MatchStrategyRandom})
+	require.NoError(t, err)
+	require.IsType(t, &wakey.RandomMatchStrategy{}, strategy)
+
+	_, err = wakey.NewMatchStrategy(wakey.MatchingConfig{Strategy: "unknown"})
+	require.Error(t, err)
+}
+
+func TestFindPlanForWishMatching(t *testing.T) {
+	db := setupTestDB(t)
+	db.SetMatching(wakey.NewFairMatchStrategy(), wakey.MatchingConfig{MaxWishesPerPlan: 2})
+
+	users := []*wakey.User{
+		{ID: 300, Name: "Sender"},
+		{ID: 301, Name: "Early Recipient"},
+		{ID: 302, Name: "Late Recipient"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	earlyPlan := &wakey.Plan{
+		UserID:  301,
+		Content: "Early Plan",
+		WakeAt:  time.Now().Add(6 * time.Hour),
+	}
+	latePlan := &wakey.Plan{
+		UserID:  302,
+		Content: "Late Plan",
+		WakeAt:  time.Now().Add(12 * time.Hour),
+	}
+	require.NoError(t, db.SavePlan(earlyPlan))
+	require.NoError(t, db.SavePlan(latePlan))
+
+	// The soonest waking plan is offered first
+	plan, err := db.FindPlanForWish(300)
+	require.NoError(t, err)
+	require.Equal(t, earlyPlan.ID, plan.ID)
+
+	// The offered plan is locked, so the other one is offered next
+	plan, err = db.FindPlanForWish(300)
+	require.NoError(t, err)
+	require.Equal(t, latePlan.ID, plan.ID)
+
+	// Release the locks and give the early plan a wish from the sender
+	earlyPlan.OfferedAt = time.Time{}
+	require.NoError(t, db.SavePlan(earlyPlan))
+	latePlan.OfferedAt = time.Time{}
+	require.NoError(t, db.SavePlan(latePlan))
+	require.NoError(t, db.SaveWish(&wakey.Wish{FromID: 300, PlanID: earlyPlan.ID, Content: "First"}))
+
+	// The sender is not paired with the same recipient again
+	plan, err = db.FindPlanForWish(300)
+	require.NoError(t, err)
+	require.Equal(t, latePlan.ID, plan.ID)
+
+	// Plans accept wishes until the limit is reached
+	require.NoError(t, db.SaveWish(&wakey.Wish{FromID: 303, PlanID: earlyPlan.ID, Content: "Second"}))
+	_, err = db.FindPlanForWish(304)
+	require.Error(t, err)
+	require.Equal(t, wakey.ErrNotFound, err)
+}
@@ -94,7 +94,7 @@ func TestMessageModerator(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mod := setupModerator(t)
-			score, err := mod.CheckMessage(ctx, tt.message)
+			result, err := mod.CheckMessage(ctx, tt.message)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -102,8 +102,8 @@ func TestMessageModerator(t *testing.T) {
 			}
 
 			require.NoError(t, err)
-			require.GreaterOrEqual(t, score, tt.expectedRange[0], "Score should be >= min expected")
-			require.LessOrEqual(t, score, tt.expectedRange[1], "Score should be <= max expected")
+			require.GreaterOrEqual(t, result.Score, tt.expectedRange[0], "Score should be >= min expected")
+			require.LessOrEqual(t, result.Score, tt.expectedRange[1], "Score should be <= max expected")
 		})
 	}
 }
@@ -0,0 +1,115 @@
+package wakey_test
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupMoodTag(t *testing.T) {
+	tag, ok := wakey.LookupMoodTag(wakey.TagFeeling, "calm")
+	require.True(t, ok)
+	require.Equal(t, "joy", tag.Group)
+
+	tag, ok = wakey.LookupMoodTag(wakey.TagNeed, "support")
+	require.True(t, ok)
+	require.Equal(t, "contact", tag.Group)
+
+	_, ok = wakey.LookupMoodTag(wakey.TagNeed, "calm")
+	require.False(t, ok)
+}
+
+func TestWeeklyMoodTrend(t *testing.T) {
+	feeling := func(key string) wakey.PlanTag {
+		return wakey.PlanTag{Kind: wakey.TagFeeling, Tag: key}
+	}
+	need := func(key string) wakey.PlanTag {
+		return wakey.PlanTag{Kind: wakey.TagNeed, Tag: key}
+	}
+
+	// Monday, May 13 2024 and Monday, May 20 2024
+	entries := []wakey.MoodEntry{
+		{CreatedAt: time.Date(2024, 5, 13, 9, 0, 0, 0, time.UTC), Mood: 2, Tags: []wakey.PlanTag{feeling("anxiety"), need("rest")}},
+		{CreatedAt: time.Date(2024, 5, 15, 9, 0, 0, 0, time.UTC), Mood: 4, Tags: []wakey.PlanTag{feeling("anxiety"), feeling("calm")}},
+		{CreatedAt: time.Date(2024, 5, 19, 9, 0, 0, 0, time.UTC)},
+		{CreatedAt: time.Date(2024, 5, 20, 9, 0, 0, 0, time.UTC), Mood: 5, Tags: []wakey.PlanTag{feeling("vigor")}},
+	}
+
+	weeks := wakey.WeeklyMoodTrend(entries, time.UTC, 1)
+	require.Len(t, weeks, 2)
+
+	require.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), weeks[0].Start)
+	require.Equal(t, 3, weeks[0].Entries)
+	require.Equal(t, 2, weeks[0].Rated)
+	require.InDelta(t, 3.0, weeks[0].AvgMood, 0.001)
+	require.Equal(t, []string{"anxiety"}, weeks[0].TopFeelings)
+	require.Equal(t, []string{"rest"}, weeks[0].TopNeeds)
+
+	require.Equal(t, time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC), weeks[1].Start)
+	require.InDelta(t, 5.0, weeks[1].AvgMood, 0.001)
+	require.Empty(t, weeks[1].TopNeeds)
+
+	// Sunday evening in UTC is already Monday in UTC+3
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	weeks = wakey.WeeklyMoodTrend([]wakey.MoodEntry{
+		{CreatedAt: time.Date(2024, 5, 19, 22, 0, 0, 0, time.UTC), Mood: 3},
+	}, loc, 3)
+	require.Len(t, weeks, 1)
+	require.Equal(t, time.Date(2024, 5, 20, 0, 0, 0, 0, loc), weeks[0].Start)
+
+	require.Empty(t, wakey.WeeklyMoodTrend(nil, time.UTC, 3))
+}
+
+func TestMoodChart(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	now := time.Date(2024, 5, 30, 12, 0, 0, 0, loc)
+	feeling := func(key string) wakey.PlanTag {
+		return wakey.PlanTag{Kind: wakey.TagFeeling, Tag: key}
+	}
+
+	entries := []wakey.MoodEntry{
+		{CreatedAt: now.AddDate(0, 0, -40), Mood: 1, Tags: []wakey.PlanTag{feeling("anger")}},
+		{CreatedAt: now.AddDate(0, 0, -29), Mood: 2, Tags: []wakey.PlanTag{feeling("anxiety")}},
+		{CreatedAt: now.AddDate(0, 0, -3), Mood: 3, Tags: []wakey.PlanTag{feeling("anxiety"), feeling("calm")}},
+		{CreatedAt: now.AddDate(0, 0, -3).Add(time.Hour), Mood: 5, Tags: []wakey.PlanTag{feeling("calm")}},
+		{CreatedAt: now.AddDate(0, 0, -1), Tags: []wakey.PlanTag{feeling("calm")}},
+		// 23:30 UTC is already the next day in UTC+3
+		{CreatedAt: time.Date(2024, 5, 29, 23, 30, 0, 0, time.UTC), Mood: 4},
+	}
+
+	chart := wakey.BuildMoodChart(entries, 30, now, loc, 2)
+	require.Len(t, chart.Moods, 30)
+	require.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, loc), chart.Start)
+	require.True(t, chart.HasMood())
+
+	// Entries older than the period are ignored
+	require.InDelta(t, 2.0, chart.Moods[0], 0.001)
+	require.Equal(t, 1, chart.Updates[0])
+
+	require.InDelta(t, 4.0, chart.Moods[26], 0.001)
+	require.Equal(t, 2, chart.Updates[26])
+	require.Zero(t, chart.Moods[28])
+	require.Equal(t, 1, chart.Updates[28])
+	require.InDelta(t, 4.0, chart.Moods[29], 0.001)
+
+	require.Len(t, chart.TopTags, 2)
+	require.Equal(t, "calm", chart.TopTags[0].Tag.Key)
+	require.Equal(t, 3, chart.TopTags[0].Count)
+	require.Equal(t, "anxiety", chart.TopTags[1].Tag.Key)
+
+	data, err := chart.Render()
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 800, img.Bounds().Dx())
+
+	// An empty chart is still rendered
+	chart = wakey.BuildMoodChart(nil, 90, now, loc, 2)
+	require.False(t, chart.HasMood())
+	_, err = chart.Render()
+	require.NoError(t, err)
+}
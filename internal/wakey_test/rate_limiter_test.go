@@ -0,0 +1,160 @@
+package wakey_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	type call struct {
+		user    int64
+		class   wakey.RateClass
+		after   time.Duration // since start
+		allowed bool
+		notify  bool
+	}
+
+	cases := []struct {
+		name  string
+		calls []call
+	}{
+		{
+			name: "burst is exhausted",
+			calls: []call{
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, false, true},
+				{1, wakey.RateWishLookups, 0, false, false},
+			},
+		},
+		{
+			name: "bucket refills over time",
+			calls: []call{
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, false, true},
+				{1, wakey.RateWishLookups, 30 * time.Second, true, false},
+				{1, wakey.RateWishLookups, 30 * time.Second, false, true},
+			},
+		},
+		{
+			name: "users and classes are independent",
+			calls: []call{
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateWishLookups, 0, false, true},
+				{2, wakey.RateWishLookups, 0, true, false},
+				{1, wakey.RateMessages, 0, true, false},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rl := wakey.NewRateLimiter(wakey.RateLimitConfig{
+				WishLookups: wakey.RateLimit{Rate: 2, Burst: 2},
+			})
+			for i, c := range tc.calls {
+				decision := rl.Allow(c.user, c.class, start.Add(c.after))
+				require.Equal(t, c.allowed, decision.Allowed, "call %d", i)
+				require.Equal(t, c.notify, decision.Notify, "call %d", i)
+				if !decision.Allowed {
+					require.Positive(t, decision.RetryAfter, "call %d", i)
+					require.LessOrEqual(t, decision.RetryAfter, 30*time.Second, "call %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterDefaults(t *testing.T) {
+	rl := wakey.NewRateLimiter(wakey.RateLimitConfig{})
+	now := time.Now()
+
+	for i := 0; i < 20; i++ {
+		require.True(t, rl.Allow(1, wakey.RateMessages, now).Allowed)
+	}
+	require.False(t, rl.Allow(1, wakey.RateMessages, now).Allowed)
+}
+
+func TestRateLimiterReportsOffenders(t *testing.T) {
+	rl := wakey.NewRateLimiter(wakey.RateLimitConfig{
+		Callbacks:    wakey.RateLimit{Rate: 1, Burst: 1},
+		ReportAfter:  3,
+		ReportWindow: 5,
+	})
+	offenses, unsubscribe := rl.SubscribeToOffenses(10)
+	defer unsubscribe()
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	require.True(t, rl.Allow(7, wakey.RateCallbacks, now).Allowed)
+	for i := 0; i < 5; i++ {
+		require.False(t, rl.Allow(7, wakey.RateCallbacks, now).Allowed)
+	}
+
+	offense := <-offenses
+	require.Equal(t, wakey.RateOffense{UserID: 7, Class: wakey.RateCallbacks, Throttled: 3, Window: 5 * time.Minute}, offense)
+	require.Empty(t, offenses, "the user is reported once per window")
+
+	// The next window starts counting again
+	later := now.Add(10 * time.Minute)
+	require.True(t, rl.Allow(7, wakey.RateCallbacks, later).Allowed)
+	for i := 0; i < 3; i++ {
+		require.False(t, rl.Allow(7, wakey.RateCallbacks, later).Allowed)
+	}
+	require.Equal(t, 3, (<-offenses).Throttled)
+}
+
+func TestEndToEndRateLimit(t *testing.T) {
+	app := newTestApp(t, wakey.Config{
+		AdminID: 2001,
+		RateLimit: wakey.RateLimitConfig{
+			Messages:    wakey.RateLimit{Rate: 1, Burst: 2},
+			Callbacks:   wakey.RateLimit{Rate: 1, Burst: 1},
+			ReportAfter: 2,
+		},
+	})
+	tg := app.tg
+
+	admin := &tele.User{ID: 2001, FirstName: "Admin", LanguageCode: "en"}
+	spammer := &tele.User{ID: 2002, FirstName: "Spammer", LanguageCode: "en"}
+
+	tg.sendText(spammer, "hello")
+	tg.sendText(spammer, "hello")
+	answered := len(tg.messagesTo(spammer.ID))
+
+	// The first throttled message is answered politely, the next are dropped
+	tg.sendText(spammer, "hello")
+	require.Len(t, tg.messagesTo(spammer.ID), answered+1)
+	require.True(t, strings.HasPrefix(tg.lastTo(spammer.ID).Text, "Please slow down a little"))
+	tg.sendText(spammer, "hello")
+	require.Len(t, tg.messagesTo(spammer.ID), answered+1)
+
+	// The admin is told about the spammer
+	require.Eventually(t, func() bool {
+		_, ok := findButton(lastOrEmpty(tg.messagesTo(admin.ID)), "skip_ban")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	report := tg.lastTo(admin.ID)
+	require.Equal(t, wakey.T(wakey.DefaultLang, "admin.rate_limited", spammer.ID, 2, 10, "messages"), report.Text)
+
+	// Throttled button presses get an answer to stop the spinner
+	tg.press(admin, report, "skip_ban")
+	require.Equal(t, wakey.T("en", "admin.ban_skipped", spammer.ID), tg.lastTo(admin.ID).Text)
+	tg.press(admin, report, "skip_ban")
+	require.True(t, strings.HasPrefix(tg.lastAnswer().Text, "Please slow down a little"))
+}
+
+func lastOrEmpty(msgs []tele.Message) *tele.Message {
+	if len(msgs) == 0 {
+		return &tele.Message{}
+	}
+	return &msgs[len(msgs)-1]
+}
@@ -0,0 +1,105 @@
+package wakey_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestReferrals(t *testing.T) {
+	db := setupTestDB(t)
+
+	users := []*wakey.User{
+		{ID: 1, Name: "First"},
+		{ID: 2, Name: "Second"},
+		{ID: 3, Name: "Third", ReferrerID: 2},
+		{ID: 4, Name: "Fourth", ReferrerID: 2},
+		{ID: 5, Name: "Fifth", ReferrerID: 1},
+		{ID: 6, Name: "Sixth"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	count, err := db.CountReferrals(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	count, err = db.CountReferrals(6)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	top, err := db.GetTopReferrers(5)
+	require.NoError(t, err)
+	require.Equal(t, []wakey.Referrer{
+		{UserID: 2, Name: "Second", Referred: 2},
+		{UserID: 1, Name: "First", Referred: 1},
+	}, top)
+
+	top, err = db.GetTopReferrers(1)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+
+	from, to, err := wakey.ParseReportPeriod("", time.Now(), 7, 0)
+	require.NoError(t, err)
+	data, err := db.GetReportData(from, to)
+	require.NoError(t, err)
+	report := wakey.BuildReport(data, from, to)
+	require.Equal(t, 3, report.ReferredUsers)
+	require.Len(t, report.TopReferrers, 2)
+}
+
+func TestEndToEndReferral(t *testing.T) {
+	app := newTestApp(t, wakey.Config{AdminID: 5001})
+	tg := app.tg
+
+	alice := &tele.User{ID: 5001, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 5002, FirstName: "Bob", LanguageCode: "en"}
+	carol := &tele.User{ID: 5003, FirstName: "Carol", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Morning yoga")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+
+	// Alice's invite link carries her referral code, not her ID
+	registered, err := app.db.GetUserByID(alice.ID)
+	require.NoError(t, err)
+	code := registered.ReferralCode.String
+	require.NotEmpty(t, code)
+	require.NotContains(t, code, strconv.FormatInt(alice.ID, 10))
+
+	tg.press(alice, tg.lastTo(alice.ID), "invite_friends")
+	tg.press(alice, tg.lastTo(alice.ID), "show_link")
+	link := fmt.Sprintf("https://t.me/%s?start=ref_%s", tg.api.Me.Username, code)
+	require.Contains(t, tg.textsTo(alice.ID), wakey.T("en", "general.invite_link", link))
+
+	// Bob comes with the link, Carol with a guessed one
+	app.registerWith(t, bob, "/start ref_"+code, "Bob", "Reading")
+	app.registerWith(t, carol, fmt.Sprintf("/start ref_%d", alice.ID), "Carol", "Running")
+
+	registered, err = app.db.GetUserByID(bob.ID)
+	require.NoError(t, err)
+	require.Equal(t, alice.ID, registered.ReferrerID)
+	require.NotEqual(t, code, registered.ReferralCode.String, "every user gets a code of their own")
+
+	registered, err = app.db.GetUserByID(carol.ID)
+	require.NoError(t, err)
+	require.Zero(t, registered.ReferrerID, "user IDs are not referral codes")
+
+	// Alice sees the friends she invited in her profile
+	tg.sendText(alice, "/cancel")
+	tg.press(alice, tg.lastTo(alice.ID), "show_profile")
+	texts := tg.textsTo(alice.ID)
+	require.Contains(t, texts[len(texts)-2], "Friends invited: 1", "the profile before the menu")
+
+	// The admin's report lists the top inviters
+	tg.sendText(alice, "/stat")
+	texts = tg.textsTo(alice.ID)
+	summary := texts[len(texts)-2]
+	require.True(t, strings.HasSuffix(summary, wakey.T("en", "report.top_referrer", 1, "Alice", alice.ID, 1)), summary)
+}
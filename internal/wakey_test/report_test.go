@@ -0,0 +1,309 @@
+package wakey_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestBuildReport(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	// Two weeks from Monday, May 13 2024
+	from := at(time.May, 13, 0)
+	to := at(time.May, 27, 0)
+
+	data := &wakey.ReportData{
+		TotalUsers: 10,
+		Signups: []wakey.Signup{
+			{UserID: 1, At: at(time.May, 13, 10)},
+			{UserID: 2, At: at(time.May, 15, 10)},
+			{UserID: 3, At: at(time.May, 21, 10), Referred: true},
+		},
+		Activity: []wakey.Activity{
+			{UserID: 4, Kind: wakey.ActivityPlan, At: at(time.April, 25, 9)}, // over 30 days before the end
+			{UserID: 1, Kind: wakey.ActivityPlan, At: at(time.May, 13, 11)},
+			{UserID: 1, Kind: wakey.ActivityWish, At: at(time.May, 14, 8)},
+			{UserID: 2, Kind: wakey.ActivityPlan, At: at(time.May, 15, 11)},
+			{UserID: 1, Kind: wakey.ActivityPlan, At: at(time.May, 20, 7)},
+			{UserID: 3, Kind: wakey.ActivityWish, At: at(time.May, 22, 12)},
+			{UserID: 4, Kind: wakey.ActivityPlan, At: at(time.May, 25, 9)},
+		},
+		WishEvents: []wakey.WishEvent{
+			// Delivered before the period, its reaction doesn't count
+			{WishID: 10, State: wakey.WishStateSent, At: at(time.April, 30, 10), WrittenAt: at(time.April, 30, 8)},
+			{WishID: 10, State: wakey.WishStateLiked, At: at(time.May, 13, 10)},
+			{WishID: 11, State: wakey.WishStateSent, At: at(time.May, 14, 10), WrittenAt: at(time.May, 14, 8)},
+			{WishID: 11, State: wakey.WishStateLiked, At: at(time.May, 15, 10)},
+			{WishID: 12, State: wakey.WishStateSent, At: at(time.May, 21, 6), WrittenAt: at(time.May, 21, 0)},
+			{WishID: 12, State: wakey.WishStateReported, At: at(time.May, 21, 7)},
+			{WishID: 13, State: wakey.WishStateSent, At: at(time.May, 22, 10), WrittenAt: at(time.May, 22, 0)},
+		},
+		TopReferrers: []wakey.Referrer{{UserID: 1, Name: "First", Referred: 1}},
+	}
+
+	report := wakey.BuildReport(data, from, to)
+
+	require.Equal(t, int64(10), report.TotalUsers)
+	require.Equal(t, 3, report.NewUsers)
+	require.Equal(t, 1, report.ReferredUsers)
+	require.Equal(t, 4, report.ActiveUsers)
+	require.InDelta(t, 6.0/14, report.DAU, 0.001)
+	require.Equal(t, 3, report.WAU)
+	require.Equal(t, 4, report.MAU)
+
+	require.Equal(t, 4, report.Plans)
+	require.Equal(t, 2, report.Wishes)
+	require.InDelta(t, 0.5, report.WishesPerActiveUser, 0.001)
+
+	require.Equal(t, 3, report.Delivered)
+	require.InDelta(t, 100.0/3, report.LikedPercent, 0.001)
+	require.Zero(t, report.DislikedPercent)
+	require.InDelta(t, 100.0/3, report.ReportedPercent, 0.001)
+	require.InDelta(t, 6.0, report.MedianDeliveryHours, 0.001)
+
+	require.Len(t, report.Days, 14)
+	require.Equal(t, wakey.ReportDay{Day: from, ActiveUsers: 1, NewUsers: 1, Plans: 1}, report.Days[0])
+	require.Equal(t, wakey.ReportDay{Day: at(time.May, 14, 0), ActiveUsers: 1, Wishes: 1, Delivered: 1}, report.Days[1])
+	require.Equal(t, wakey.ReportDay{Day: at(time.May, 15, 0), ActiveUsers: 1, NewUsers: 1, Plans: 1, Liked: 1}, report.Days[2])
+	require.Equal(t, wakey.ReportDay{Day: at(time.May, 26, 0)}, report.Days[13])
+
+	require.Equal(t, []wakey.Cohort{
+		{
+			Week:      at(time.May, 13, 0),
+			Users:     2,
+			Retention: []wakey.Retention{{Retained: 1, Eligible: 2}, {Retained: 1, Eligible: 2}, {}},
+		},
+		{
+			Week:      at(time.May, 20, 0),
+			Users:     1,
+			Retention: []wakey.Retention{{Retained: 1, Eligible: 1}, {}, {}},
+		},
+	}, report.Cohorts)
+	require.InDelta(t, 50.0, report.Cohorts[0].Retention[0].Percent(), 0.001)
+	require.Equal(t, -1.0, report.Cohorts[0].Retention[2].Percent(), "no user reached day 30")
+
+	require.Equal(t, data.TopReferrers, report.TopReferrers)
+}
+
+func TestBuildReportEmpty(t *testing.T) {
+	from := time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)
+	report := wakey.BuildReport(&wakey.ReportData{}, from, from.AddDate(0, 0, 7))
+
+	require.Len(t, report.Days, 7)
+	require.Zero(t, report.DAU)
+	require.Zero(t, report.WishesPerActiveUser)
+	require.Zero(t, report.MedianDeliveryHours)
+	require.Empty(t, report.Cohorts)
+}
+
+func TestBuildReportCohortsBeforePeriod(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	// The default week, the data starts on the Monday 30 days before its end
+	from := at(time.May, 20, 0)
+	to := at(time.May, 27, 0)
+	require.Equal(t, at(time.April, 22, 0), wakey.ReportDataSince(from, to))
+
+	data := &wakey.ReportData{
+		Signups: []wakey.Signup{
+			{UserID: 1, At: at(time.April, 20, 10)}, // before the data
+			{UserID: 2, At: at(time.April, 24, 10)},
+		},
+		Activity: []wakey.Activity{
+			{UserID: 2, Kind: wakey.ActivityPlan, At: at(time.May, 1, 9)},
+			{UserID: 2, Kind: wakey.ActivityPlan, At: at(time.May, 24, 9)},
+		},
+	}
+
+	report := wakey.BuildReport(data, from, to)
+	require.Zero(t, report.NewUsers)
+	require.Equal(t, []wakey.Cohort{{
+		Week:      at(time.April, 22, 0),
+		Users:     1,
+		Retention: []wakey.Retention{{Eligible: 1}, {Retained: 1, Eligible: 1}, {Retained: 1, Eligible: 1}},
+	}}, report.Cohorts)
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	now := time.Date(2024, 5, 26, 15, 30, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		args     string
+		from, to time.Time
+		err      bool
+	}{
+		{"", day(time.May, 20), day(time.May, 27), false},
+		{"30", day(time.April, 27), day(time.May, 27), false},
+		{" 1 ", day(time.May, 26), day(time.May, 27), false},
+		{"2024-05-01 2024-05-31", day(time.May, 1), day(time.June, 1), false},
+		{"2024-05-01 2024-05-01", day(time.May, 1), day(time.May, 2), false},
+		{"0", time.Time{}, time.Time{}, true},
+		{"-3", time.Time{}, time.Time{}, true},
+		{"week", time.Time{}, time.Time{}, true},
+		{"61", time.Time{}, time.Time{}, true},
+		{"2024-05-31 2024-05-01", time.Time{}, time.Time{}, true},
+		{"2024-01-01 2024-05-01", time.Time{}, time.Time{}, true},
+		{"2024-05-01", time.Time{}, time.Time{}, true},
+		{"1 2 3", time.Time{}, time.Time{}, true},
+	}
+
+	for _, tc := range cases {
+		from, to, err := wakey.ParseReportPeriod(tc.args, now, 7, 60)
+		if tc.err {
+			require.ErrorIs(t, err, wakey.ErrInvalidReportPeriod, "args %q", tc.args)
+			continue
+		}
+		require.NoError(t, err, "args %q", tc.args)
+		require.Equal(t, tc.from, from, "args %q", tc.args)
+		require.Equal(t, tc.to, to, "args %q", tc.args)
+	}
+}
+
+func TestEndToEndReport(t *testing.T) {
+	app := newTestApp(t, wakey.Config{AdminID: 6001, Reports: wakey.ReportConfig{MaxDays: 31}})
+	tg := app.tg
+
+	admin := &tele.User{ID: 6001, FirstName: "Admin", LanguageCode: "en"}
+	alice := &tele.User{ID: 6002, FirstName: "Alice", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Stretching")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+
+	// Users can't see the report
+	sent := len(tg.messagesTo(alice.ID))
+	tg.sendText(alice, "/stat")
+	require.Len(t, tg.messagesTo(alice.ID), sent)
+
+	tg.sendText(admin, "/stat")
+	texts := tg.textsTo(admin.ID)
+	require.Len(t, texts, 2)
+	require.True(t, strings.HasPrefix(texts[0], "📊 *Report for "), texts[0])
+	require.Contains(t, texts[0], "(7 days)")
+	require.Contains(t, texts[0], "• New: 1, with invite links: 0")
+	require.Contains(t, texts[1], wakey.T("en", "report.daily"))
+	require.Contains(t, texts[1], wakey.T("en", "report.cohorts"))
+
+	today := time.Now().UTC().Format("01-02")
+	require.Contains(t, texts[1], today+"    1    1    1    0    0    0")
+
+	tg.sendText(admin, "/stat 90")
+	require.Equal(t, wakey.TN("en", "report.invalid_period", 31, 31), tg.lastTo(admin.ID).Text)
+
+	tg.sendText(admin, "/stat 1")
+	require.Contains(t, tg.textsTo(admin.ID)[3], "(1 day)")
+}
+
+func TestReportCSV(t *testing.T) {
+	from := time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)
+	data := &wakey.ReportData{
+		Signups: []wakey.Signup{{UserID: 1, At: from.Add(10 * time.Hour)}},
+		Activity: []wakey.Activity{
+			{UserID: 1, Kind: wakey.ActivityPlan, At: from.Add(11 * time.Hour)},
+			{UserID: 1, Kind: wakey.ActivityPlan, At: from.Add(35 * time.Hour)},
+		},
+		WishEvents: []wakey.WishEvent{
+			{WishID: 1, State: wakey.WishStateSent, At: from.Add(30 * time.Hour), WrittenAt: from},
+			{WishID: 1, State: wakey.WishStateLiked, At: from.Add(31 * time.Hour)},
+		},
+	}
+	report := wakey.BuildReport(data, from, from.AddDate(0, 0, 2))
+
+	var daily strings.Builder
+	require.NoError(t, report.WriteDailyCSV(&daily))
+	require.Equal(t, "date,active_users,new_users,plans,wishes,delivered,liked,disliked,reported\n"+
+		"2024-05-13,1,1,1,0,0,0,0,0\n"+
+		"2024-05-14,1,0,1,0,1,1,0,0\n", daily.String())
+
+	var cohorts strings.Builder
+	require.NoError(t, report.WriteCohortsCSV(&cohorts))
+	require.Equal(t, "week,users,d1_retained,d1_eligible,d1_percent,d7_retained,d7_eligible,d7_percent,d30_retained,d30_eligible,d30_percent\n"+
+		"2024-05-13,1,1,1,100.00,0,0,,0,0,\n", cohorts.String())
+}
+
+func TestCompareReports(t *testing.T) {
+	previous := &wakey.Report{NewUsers: 3, ActiveUsers: 20, Plans: 40, Wishes: 10, Delivered: 10, ReportedPercent: 10, MedianDeliveryHours: 4}
+	current := &wakey.Report{NewUsers: 0, ActiveUsers: 15, Plans: 20, Wishes: 9, Delivered: 8, LikedPercent: 50, ReportedPercent: 20, MedianDeliveryHours: 2}
+
+	changes := wakey.CompareReports(current, previous, 30)
+	byKey := make(map[string]wakey.MetricChange)
+	for _, change := range changes {
+		byKey[change.Key] = change
+	}
+
+	require.True(t, byKey["report.metric_plans"].Anomaly, "plans halved")
+	require.True(t, byKey["report.metric_reported"].Anomaly, "reports doubled")
+	require.False(t, byKey["report.metric_active_users"].Anomaly, "a drop under the threshold")
+	require.False(t, byKey["report.metric_new_users"].Anomaly, "too few users the week before")
+	require.False(t, byKey["report.metric_delivery_hours"].Anomaly, "faster delivery is better")
+
+	delta, ok := byKey["report.metric_plans"].DeltaPercent()
+	require.True(t, ok)
+	require.InDelta(t, -50.0, delta, 0.001)
+
+	_, ok = byKey["report.metric_liked"].DeltaPercent()
+	require.False(t, ok, "nothing liked the week before")
+}
+
+func TestNextWeeklyReport(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+	require.Equal(t, time.Date(2024, 5, 20, 9, 0, 0, 0, time.UTC), wakey.NextWeeklyReport(time.Monday, 9, 0, now))
+	require.Equal(t, time.Date(2024, 5, 15, 12, 30, 0, 0, time.UTC), wakey.NextWeeklyReport(time.Wednesday, 12, 30, now))
+	require.Equal(t, time.Date(2024, 5, 22, 9, 0, 0, 0, time.UTC), wakey.NextWeeklyReport(time.Wednesday, 9, 0, now), "today's time has passed")
+}
+
+func TestEndToEndReportExport(t *testing.T) {
+	app := newTestApp(t, wakey.Config{AdminID: 6101})
+	tg := app.tg
+
+	admin := &tele.User{ID: 6101, FirstName: "Admin", LanguageCode: "en"}
+	alice := &tele.User{ID: 6102, FirstName: "Alice", LanguageCode: "en"}
+
+	app.register(t, alice, "Alice", "Stretching")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+
+	tg.sendText(admin, "/stat export 2")
+	msgs := tg.messagesTo(admin.ID)
+	require.Len(t, msgs, 2)
+
+	now := time.Now().UTC()
+	period := now.AddDate(0, 0, -1).Format(time.DateOnly) + "_" + now.Format(time.DateOnly)
+	require.NotNil(t, msgs[0].Document)
+	require.Equal(t, "wakey-daily-"+period+".csv", msgs[0].Document.FileName)
+	require.Equal(t, wakey.T("en", "report.export_daily", now.AddDate(0, 0, -1).Format(time.DateOnly), now.Format(time.DateOnly)), msgs[0].Text)
+	require.True(t, strings.HasSuffix(tg.fileOf(msgs[0]), now.Format(time.DateOnly)+",1,1,1,0,0,0,0,0\n"), tg.fileOf(msgs[0]))
+
+	require.Equal(t, "wakey-cohorts-"+period+".csv", msgs[1].Document.FileName)
+	require.True(t, strings.HasPrefix(tg.fileOf(msgs[1]), "week,users,d1_retained"), tg.fileOf(msgs[1]))
+
+	tg.sendText(admin, "/stat export 1 2 3")
+	require.Equal(t, wakey.TN("en", "report.invalid_period", 92, 92), tg.lastTo(admin.ID).Text)
+}
+
+func TestEndToEndWeeklyReport(t *testing.T) {
+	app := newTestApp(t, wakey.Config{AdminID: 6201, Reports: wakey.ReportConfig{WeeklyDay: "friday"}})
+	tg := app.tg
+
+	app.reports.SendWeeklyReport(wakey.JobID(6201))
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	report := tg.lastTo(6201).Text
+	title := wakey.T(wakey.DefaultLang, "report.weekly_title", today.AddDate(0, 0, -7).Format(time.DateOnly), today.AddDate(0, 0, -1).Format(time.DateOnly))
+	require.True(t, strings.HasPrefix(report, title), report)
+	require.Contains(t, report, wakey.T(wakey.DefaultLang, "report.weekly_metric",
+		wakey.T(wakey.DefaultLang, "report.metric_plans"), "0", "0", wakey.T(wakey.DefaultLang, "report.weekly_unchanged")))
+	require.NotContains(t, report, "⚠️")
+}
@@ -0,0 +1,379 @@
+package wakey_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+const fakeBotToken = "123456:test-token"
+
+// fakeTelegram is an in-process Telegram Bot API server. It records the
+// messages the bot sends, applies the edits to them and delivers text
+// messages and button presses to the bot as updates.
+type fakeTelegram struct {
+	t      *testing.T
+	server *httptest.Server
+	api    *tele.Bot
+
+	mu       sync.Mutex
+	messages []*tele.Message // in the order they were sent
+	byID     map[int]*tele.Message
+	answers  []tele.CallbackResponse
+	commands []tele.CommandParams
+	files    map[int]string // contents of the sent documents by message ID
+	updateID int
+}
+
+// idlePoller never polls as the tests deliver updates with ProcessUpdate
+type idlePoller struct{}
+
+func (idlePoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	<-stop
+}
+
+// newFakeTelegram starts the server and creates a synchronous bot using it,
+// so every delivered update is handled by the time the call returns
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	ft := &fakeTelegram{
+		t:     t,
+		byID:  make(map[int]*tele.Message),
+		files: make(map[int]string),
+	}
+	ft.server = httptest.NewServer(http.HandlerFunc(ft.serveHTTP))
+	t.Cleanup(ft.server.Close)
+
+	api, err := tele.NewBot(tele.Settings{
+		URL:         ft.server.URL,
+		Token:       fakeBotToken,
+		Poller:      idlePoller{},
+		Synchronous: true,
+		OnError: func(err error, c tele.Context) {
+			t.Errorf("handler failed: %v", err)
+		},
+	})
+	require.NoError(t, err)
+	ft.api = api
+
+	return ft
+}
+
+func (ft *fakeTelegram) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+fakeBotToken+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	params, err := readParams(r)
+	if err != nil {
+		ft.t.Errorf("%s: can't read params: %v", method, err)
+		replyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	switch method {
+	case "getMe":
+		reply(w, tele.User{ID: 1, IsBot: true, FirstName: "Wakey", Username: "wakey_test_bot"})
+	case "sendMessage":
+		reply(w, ft.newMessage(params, params["text"]))
+	case "sendPhoto", "sendVoice", "sendSticker", "sendVideoNote":
+		reply(w, ft.newMessage(params, params["caption"]))
+	case "sendDocument":
+		msg := ft.newMessage(params, params["caption"])
+		msg.Document = &tele.Document{FileName: params["document.filename"]}
+		ft.files[msg.ID] = params["document"]
+		reply(w, msg)
+	case "sendMediaGroup":
+		var media []struct {
+			Caption string `json:"caption"`
+		}
+		if err := json.Unmarshal([]byte(params["media"]), &media); err != nil {
+			replyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		msgs := make([]*tele.Message, len(media))
+		for i, item := range media {
+			msgs[i] = ft.newMessage(params, item.Caption)
+		}
+		reply(w, msgs)
+	case "editMessageText", "editMessageCaption", "editMessageReplyMarkup":
+		msg, err := ft.editMessage(method, params)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		reply(w, msg)
+	case "answerCallbackQuery":
+		ft.answers = append(ft.answers, tele.CallbackResponse{
+			CallbackID: params["callback_query_id"],
+			Text:       params["text"],
+			ShowAlert:  params["show_alert"] == "true",
+		})
+		reply(w, true)
+	case "setMyCommands":
+		ft.commands = append(ft.commands, parseCommands(params))
+		reply(w, true)
+	case "deleteWebhook", "deleteMessage":
+		reply(w, true)
+	default:
+		ft.t.Errorf("unexpected Bot API method %s", method)
+		replyError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// readParams returns the request params as strings like the bot sends them
+func readParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+		for key, values := range r.MultipartForm.Value {
+			params[key] = values[0]
+		}
+		// Uploaded files are read into the key with their name in key.filename
+		for key, headers := range r.MultipartForm.File {
+			file, err := headers[0].Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			params[key] = string(data)
+			params[key+".filename"] = headers[0].Filename
+		}
+		return params, nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			params[key] = v
+		case nil:
+		default:
+			data, _ := json.Marshal(v)
+			params[key] = string(data)
+		}
+	}
+	return params, nil
+}
+
+func reply(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func replyError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+func parseMarkup(params map[string]string) *tele.ReplyMarkup {
+	data, ok := params["reply_markup"]
+	if !ok {
+		return nil
+	}
+	var markup tele.ReplyMarkup
+	if err := json.Unmarshal([]byte(data), &markup); err != nil {
+		return nil
+	}
+	return &markup
+}
+
+func parseCommands(params map[string]string) tele.CommandParams {
+	cmds := tele.CommandParams{LanguageCode: params["language_code"]}
+	_ = json.Unmarshal([]byte(params["commands"]), &cmds.Commands)
+	if scope, ok := params["scope"]; ok {
+		cmds.Scope = &tele.CommandScope{}
+		_ = json.Unmarshal([]byte(scope), cmds.Scope)
+	}
+	return cmds
+}
+
+func (ft *fakeTelegram) newMessage(params map[string]string, text string) *tele.Message {
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	msg := &tele.Message{
+		ID:          len(ft.messages) + 1,
+		Chat:        &tele.Chat{ID: chatID, Type: tele.ChatPrivate},
+		Unixtime:    time.Now().Unix(),
+		Text:        text,
+		ReplyMarkup: parseMarkup(params),
+	}
+	ft.messages = append(ft.messages, msg)
+	ft.byID[msg.ID] = msg
+	return msg
+}
+
+func (ft *fakeTelegram) editMessage(method string, params map[string]string) (*tele.Message, error) {
+	id, _ := strconv.Atoi(params["message_id"])
+	msg, exists := ft.byID[id]
+	if !exists {
+		return nil, fmt.Errorf("Bad Request: message to edit not found")
+	}
+
+	switch method {
+	case "editMessageText":
+		msg.Text = params["text"]
+	case "editMessageCaption":
+		msg.Text = params["caption"]
+	}
+	// Telegram drops the keyboard unless the edit sets it again
+	msg.ReplyMarkup = parseMarkup(params)
+
+	return msg, nil
+}
+
+// messagesTo returns the current state of the messages sent to the chat
+func (ft *fakeTelegram) messagesTo(chatID int64) []tele.Message {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	var msgs []tele.Message
+	for _, msg := range ft.messages {
+		if msg.Chat.ID == chatID {
+			msgs = append(msgs, *msg)
+		}
+	}
+	return msgs
+}
+
+// fileOf returns the content of the document sent in the message
+func (ft *fakeTelegram) fileOf(msg tele.Message) string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.files[msg.ID]
+}
+
+// lastTo returns the last message sent to the chat
+func (ft *fakeTelegram) lastTo(chatID int64) tele.Message {
+	msgs := ft.messagesTo(chatID)
+	require.NotEmpty(ft.t, msgs, "no messages sent to %d", chatID)
+	return msgs[len(msgs)-1]
+}
+
+// lastWithButton returns the last message sent to the chat that has the button
+func (ft *fakeTelegram) lastWithButton(chatID int64, unique string) tele.Message {
+	msgs := ft.messagesTo(chatID)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if _, ok := findButton(&msgs[i], unique); ok {
+			return msgs[i]
+		}
+	}
+	ft.t.Fatalf("no message to %d has the %s button", chatID, unique)
+	return tele.Message{}
+}
+
+// textsTo returns the texts of the messages sent to the chat
+func (ft *fakeTelegram) textsTo(chatID int64) []string {
+	var texts []string
+	for _, msg := range ft.messagesTo(chatID) {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// commandsFor returns the last command menu the bot published for the scope
+// and language
+func (ft *fakeTelegram) commandsFor(scope tele.CommandScope, lang string) []tele.Command {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	for i := len(ft.commands) - 1; i >= 0; i-- {
+		cmds := ft.commands[i]
+		if cmds.Scope != nil && *cmds.Scope == scope && cmds.LanguageCode == lang {
+			return cmds.Commands
+		}
+	}
+	return nil
+}
+
+// lastAnswer returns the last answer to a callback query
+func (ft *fakeTelegram) lastAnswer() tele.CallbackResponse {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	require.NotEmpty(ft.t, ft.answers, "no callback answers")
+	return ft.answers[len(ft.answers)-1]
+}
+
+func findButton(msg *tele.Message, unique string) (tele.InlineButton, bool) {
+	if msg.ReplyMarkup == nil {
+		return tele.InlineButton{}, false
+	}
+	for _, row := range msg.ReplyMarkup.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Data == "\f"+unique || strings.HasPrefix(btn.Data, "\f"+unique+"|") {
+				return btn, true
+			}
+		}
+	}
+	return tele.InlineButton{}, false
+}
+
+func (ft *fakeTelegram) nextUpdateID() int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	ft.updateID++
+	return ft.updateID
+}
+
+// sendText delivers a text message from the user to the bot
+func (ft *fakeTelegram) sendText(user *tele.User, text string) {
+	ft.api.ProcessUpdate(tele.Update{
+		ID: ft.nextUpdateID(),
+		Message: &tele.Message{
+			ID:       ft.nextUpdateID(),
+			Sender:   user,
+			Chat:     &tele.Chat{ID: user.ID, Type: tele.ChatPrivate},
+			Unixtime: time.Now().Unix(),
+			Text:     text,
+		},
+	})
+}
+
+// press delivers a press of the button on the message from the user to the bot
+func (ft *fakeTelegram) press(user *tele.User, msg tele.Message, unique string) {
+	btn, ok := findButton(&msg, unique)
+	require.True(ft.t, ok, "message %q has no %s button", msg.Text, unique)
+
+	ft.pressData(user, msg, btn.Data)
+}
+
+// pressData delivers a button press with arbitrary callback data, like a
+// forged one
+func (ft *fakeTelegram) pressData(user *tele.User, msg tele.Message, data string) {
+	id := ft.nextUpdateID()
+	ft.api.ProcessUpdate(tele.Update{
+		ID: id,
+		Callback: &tele.Callback{
+			ID:      strconv.Itoa(id),
+			Sender:  user,
+			Message: &msg,
+			Data:    data,
+		},
+	})
+}
@@ -0,0 +1,157 @@
+package wakey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeTranslator struct {
+	calls int
+	err   error
+}
+
+func (ft *fakeTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	ft.calls++
+	if ft.err != nil {
+		return "", ft.err
+	}
+	return lang + ": " + text, nil
+}
+
+func TestParseModeration(t *testing.T) {
+	response := `ANALYSIS:
+The message is friendly.
+
+LANGUAGE:
+[ru]
+
+TRANSLATION:
+Good morning!
+Have a nice day.
+
+FINAL SCORE: 0.05`
+
+	result, err := wakey.ParseModeration(response)
+	require.NoError(t, err)
+	require.Equal(t, 0.05, result.Score)
+	require.Equal(t, "ru", result.Lang)
+	require.Equal(t, "Good morning!\nHave a nice day.", result.Translation)
+
+	// Language and translation are optional
+	result, err = wakey.ParseModeration("FINAL SCORE: 0.9")
+	require.NoError(t, err)
+	require.Equal(t, 0.9, result.Score)
+	require.Empty(t, result.Lang)
+	require.Empty(t, result.Translation)
+
+	// The score is required
+	_, err = wakey.ParseModeration("LANGUAGE: en")
+	require.Error(t, err)
+}
+
+func TestWishTranslationStorage(t *testing.T) {
+	db := setupTestDB(t)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 300, Name: "Sender"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 301, Name: "Recipient"}))
+	plan := &wakey.Plan{UserID: 301, Content: "Plan"}
+	require.NoError(t, db.SavePlan(plan))
+	wish := &wakey.Wish{FromID: 300, PlanID: plan.ID, Content: "Доброе утро!"}
+	require.NoError(t, db.SaveWish(wish))
+
+	require.NoError(t, db.SetWishLanguage(wish.ID, 0, "ru"))
+	require.NoError(t, db.SaveWishTranslation(wish.ID, 0, "en", "Good morning!"))
+
+	fetched, err := db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Equal(t, "ru", fetched.Lang)
+	require.Equal(t, "en", fetched.TranslationLang)
+	require.Equal(t, "Good morning!", fetched.Translation)
+
+	// Editing the wish invalidates the cached translation
+	require.NoError(t, db.UpdateWishContent(wish.ID, "Хорошего дня!", wakey.MediaNone, ""))
+	fetched, err = db.GetWishByID(wish.ID)
+	require.NoError(t, err)
+	require.Empty(t, fetched.Translation)
+	require.Empty(t, fetched.TranslationLang)
+
+	require.Equal(t, wakey.ErrNotFound, db.SetWishLanguage(999, 0, "en"))
+	require.Equal(t, wakey.ErrNotFound, db.SaveWishTranslation(999, 0, "en", "text"))
+}
+
+func TestSendWishesTranslation(t *testing.T) {
+	db := setupTestDB(t)
+	api := &fakeBotAPI{}
+	translator := &fakeTranslator{}
+	stateMan := wakey.NewStateManager()
+	log := zap.NewNop().Sugar()
+	callbacks := newTestCallbacks(t)
+	validator, err := wakey.NewValidator(wakey.ValidationConfig{}, db)
+	require.NoError(t, err)
+	wh := wakey.NewWishHandler(db, api, wakey.NewSched(10), translator, wakey.NewFlowRunner(stateMan, callbacks, log), validator, stateMan, callbacks, log)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 310, Name: "Sender", Lang: "ru"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 311, Name: "Recipient", Lang: "en"}))
+	plan := &wakey.Plan{UserID: 311, Content: "Plan"}
+	require.NoError(t, db.SavePlan(plan))
+
+	foreign := &wakey.Wish{FromID: 310, PlanID: plan.ID, Content: "Доброе утро!", Lang: "ru"}
+	native := &wakey.Wish{FromID: 310, PlanID: plan.ID, Content: "Good morning!", Lang: "en"}
+	for _, wish := range []*wakey.Wish{foreign, native} {
+		require.NoError(t, db.SaveWish(wish))
+	}
+
+	wh.SendWishes(wakey.JobID(311))
+
+	// The greeting and both wishes are delivered, only the foreign one is translated
+	msgs := api.messagesTo(311)
+	require.Len(t, msgs, 3)
+	require.Equal(t, wakey.T("en", "wish.translated", "en: Доброе утро!"), msgs[1].what)
+	require.Equal(t, "Good morning!", msgs[2].what)
+	require.Equal(t, 1, translator.calls)
+
+	fetched, err := db.GetWishByID(foreign.ID)
+	require.NoError(t, err)
+	require.Equal(t, "en", fetched.TranslationLang)
+	require.Equal(t, "en: Доброе утро!", fetched.Translation)
+	require.Equal(t, wakey.WishStateSent, fetched.State)
+
+	// A failed translation doesn't block the delivery of the original
+	translator.err = errors.New("translation failed")
+	failed := &wakey.Wish{FromID: 310, PlanID: plan.ID, Content: "Хорошего дня!", Lang: "ru"}
+	require.NoError(t, db.SaveWish(failed))
+
+	wh.SendWishes(wakey.JobID(311))
+	msgs = api.messagesTo(311)
+	require.Len(t, msgs, 5)
+	require.Equal(t, "Хорошего дня!", msgs[4].what)
+}
+
+func TestNewTranslator(t *testing.T) {
+	translator, err := wakey.NewTranslator(wakey.TranslationConfig{Provider: wakey.TranslatorNone}, wakey.LLMConfig{})
+	require.NoError(t, err)
+	require.Nil(t, translator)
+
+	translator, err = wakey.NewTranslator(wakey.TranslationConfig{Provider: wakey.TranslatorStub}, wakey.LLMConfig{})
+	require.NoError(t, err)
+	translation, err := translator.Translate(context.Background(), "Привет", "en")
+	require.NoError(t, err)
+	require.Equal(t, "[en] Привет", translation)
+
+	_, err = wakey.NewTranslator(wakey.TranslationConfig{Provider: "unknown"}, wakey.LLMConfig{})
+	require.Error(t, err)
+
+	// The prompt is checked before the model is set up
+	for _, prompt := range []string{"Translate the message", "Translate into %s or %s", "Translate into %d", "Translate into %s with 100% accuracy"} {
+		_, err = wakey.NewTranslator(wakey.TranslationConfig{Provider: wakey.TranslatorLLM, Prompt: prompt}, wakey.LLMConfig{})
+		require.ErrorContains(t, err, "translation prompt", "prompt %q", prompt)
+	}
+	_, err = wakey.NewTranslator(wakey.TranslationConfig{Provider: wakey.TranslatorLLM, Prompt: "Translate into %s with 100%% accuracy"}, wakey.LLMConfig{})
+	require.Error(t, err, "no model is configured")
+	require.NotContains(t, err.Error(), "translation prompt")
+}
@@ -0,0 +1,202 @@
+package wakey_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestValidate(t *testing.T) {
+	validator, err := wakey.NewValidator(wakey.ValidationConfig{
+		Name:  wakey.FieldRules{MinLen: 2, MaxLen: 10, MinLetters: 1, Disallowed: []string{`(?i)t\.me/`}},
+		Bio:   wakey.FieldRules{Disallowed: []string{`https?://`}},
+		Plans: wakey.FieldRules{MinLetters: 3},
+		Wish:  wakey.FieldRules{MinLen: 3, MaxLen: 20, MinLetters: 5, Disallowed: []string{`@[a-zA-Z0-9_]{5,}`}},
+	}, setupTestDB(t))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		field  wakey.InputField
+		text   string
+		media  bool
+		reason string
+		limit  int
+	}{
+		{"valid name", wakey.FieldName, "Alice", false, "", 0},
+		{"surrounding spaces", wakey.FieldName, "  Bob  ", false, "", 0},
+		{"empty", wakey.FieldName, "", false, "validation.empty", 0},
+		{"spaces only", wakey.FieldName, "   \n ", false, "validation.empty", 0},
+		{"command typo", wakey.FieldName, "/cancle", false, "validation.command", 0},
+		{"too long", wakey.FieldName, "Bartholomew Jr", false, "validation.too_long", 10},
+		{"length in runes", wakey.FieldName, "Анастасия", false, "", 0},
+		{"too short", wakey.FieldName, "A", false, "validation.too_short", 2},
+		{"emoji only", wakey.FieldName, "🌞🌞", false, "validation.too_few_letters", 1},
+		{"disallowed", wakey.FieldName, "T.me/alice", false, "validation.disallowed", 0},
+		{"valid wish", wakey.FieldWish, "Have a nice day!", false, "", 0},
+		{"wish with few letters", wakey.FieldWish, "hi 👋👋", false, "validation.too_few_letters", 5},
+		{"wish with contact", wakey.FieldWish, "write me @alice_b", false, "validation.disallowed", 0},
+		{"media without caption", wakey.FieldWish, "", true, "", 0},
+		{"short caption", wakey.FieldWish, "👋", true, "", 0},
+		{"long caption", wakey.FieldWish, strings.Repeat("a", 21), true, "validation.too_long", 20},
+		{"caption with contact", wakey.FieldWish, "@alice_b", true, "validation.disallowed", 0},
+		{"default rules", wakey.FieldBio, strings.Repeat("a", 501), false, "validation.too_long", 500},
+		{"patterns with default limits", wakey.FieldBio, "see https://example.com", false, "validation.disallowed", 0},
+		{"configured min letters", wakey.FieldPlans, "ab", false, "validation.too_few_letters", 3},
+		{"default max length", wakey.FieldPlans, strings.Repeat("a", 1001), false, "validation.too_long", 1000},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			verr := validator.Validate(tc.field, tc.text, tc.media)
+			if tc.reason == "" {
+				require.Nil(t, verr)
+				return
+			}
+			require.NotNil(t, verr)
+			require.Equal(t, tc.field, verr.Field)
+			require.Equal(t, tc.reason, verr.Reason)
+			require.Equal(t, tc.limit, verr.Limit)
+		})
+	}
+}
+
+func TestNewValidator(t *testing.T) {
+	cases := []struct {
+		name   string
+		config wakey.ValidationConfig
+		valid  bool
+	}{
+		{"defaults", wakey.ValidationConfig{}, true},
+		{"invalid pattern", wakey.ValidationConfig{Bio: wakey.FieldRules{MaxLen: 10, Disallowed: []string{"("}}}, false},
+		{"min above max", wakey.ValidationConfig{Plans: wakey.FieldRules{MinLen: 20, MaxLen: 10}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := wakey.NewValidator(tc.config, nil)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	verr := &wakey.ValidationError{Field: wakey.FieldName, Reason: "validation.too_long", Limit: 64}
+	require.Equal(t, wakey.TN("en", "validation.too_long", 64, 64), verr.Message("en"))
+	require.Contains(t, verr.Message("ru"), "64")
+
+	verr = &wakey.ValidationError{Field: wakey.FieldName, Reason: "validation.empty"}
+	require.Equal(t, wakey.T("ru", "validation.empty"), verr.Message("ru"))
+}
+
+func TestCheckRepeated(t *testing.T) {
+	db := setupTestDB(t)
+	validator, err := wakey.NewValidator(wakey.ValidationConfig{RepeatLimit: 2, RepeatWindow: 24}, db)
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 400, Name: "Sender"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 401, Name: "Recipient"}))
+	plan := &wakey.Plan{UserID: 401, Content: "Plan"}
+	require.NoError(t, db.SavePlan(plan))
+
+	verr, err := validator.CheckRepeated(400, "Good morning!")
+	require.NoError(t, err)
+	require.Nil(t, verr)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.SaveWish(&wakey.Wish{FromID: 400, PlanID: plan.ID, Content: "Good morning!"}))
+	}
+
+	// The limit is reached, surrounding spaces don't make a wish new
+	verr, err = validator.CheckRepeated(400, " Good morning! ")
+	require.NoError(t, err)
+	require.NotNil(t, verr)
+	require.Equal(t, "validation.repeated", verr.Reason)
+
+	// Other texts and other senders are not affected
+	verr, err = validator.CheckRepeated(400, "Have a nice day!")
+	require.NoError(t, err)
+	require.Nil(t, verr)
+	verr, err = validator.CheckRepeated(401, "Good morning!")
+	require.NoError(t, err)
+	require.Nil(t, verr)
+}
+
+func TestValidatedFlowStep(t *testing.T) {
+	db := setupTestDB(t)
+	validator, err := wakey.NewValidator(wakey.ValidationConfig{}, db)
+	require.NoError(t, err)
+
+	stateMan := wakey.NewStateManager()
+	runner := wakey.NewFlowRunner(stateMan, newTestCallbacks(t), zap.NewNop().Sugar())
+	runner.Register(&wakey.Flow{
+		ID: "validated",
+		Steps: []wakey.FlowStep{{
+			State:    wakey.StateAwaitingWish,
+			Prompt:   "wish.edit_prompt",
+			Media:    true,
+			Validate: validator.Field(wakey.FieldWish),
+		}},
+	})
+
+	userID := int64(410)
+	c := newFakeContext(userID)
+	require.NoError(t, runner.Start(c, "validated", nil))
+
+	// The rejected input is explained in the user's language and the user stays on the step
+	require.NoError(t, runner.HandleState(c.input("🌞"), wakey.StateAwaitingWish))
+	require.Equal(t, wakey.TN("en", "validation.too_few_letters", 5, 5), c.lastSent())
+	state, _ := stateMan.GetState(userID)
+	require.Equal(t, wakey.StateAwaitingWish, state)
+
+	// A photo doesn't need a caption
+	c.input("")
+	c.msg.Photo = &tele.Photo{File: tele.File{FileID: "photo"}}
+	require.NoError(t, runner.HandleState(c, wakey.StateAwaitingWish))
+	state, _ = stateMan.GetState(userID)
+	require.Equal(t, wakey.StateNone, state)
+}
+
+func TestEndToEndTrimmedInput(t *testing.T) {
+	app := newTestApp(t, wakey.Config{})
+	tg := app.tg
+
+	dora := &tele.User{ID: 4201, FirstName: "Dora", LanguageCode: "en"}
+	tg.sendText(dora, "/start")
+	tg.sendText(dora, "  Dora \n")
+	require.Equal(t, wakey.T("en", "profile.ask_bio", "Dora"), tg.lastTo(dora.ID).Text, "the name is stored without the spaces")
+	tg.sendText(dora, "/cancel")
+
+	alice := &tele.User{ID: 4202, FirstName: "Alice", LanguageCode: "en"}
+	bob := &tele.User{ID: 4203, FirstName: "Bob", LanguageCode: "en"}
+	app.register(t, alice, "Alice", "Planting tomatoes")
+	tg.press(alice, tg.lastTo(alice.ID), "send_wish_no")
+	app.register(t, bob, "Bob", "Walking the dog")
+	tg.press(bob, tg.lastTo(bob.ID), "send_wish_yes")
+
+	// The wish and its edit are stored without the spaces
+	tg.sendText(bob, "  Good luck with the tomatoes! \n")
+	saved := tg.lastWithButton(bob.ID, "edit_wish")
+	btn, _ := findButton(&saved, "edit_wish")
+	wishID, err := strconv.ParseUint(strings.Split(btn.Data, "|")[1], 10, 64)
+	require.NoError(t, err)
+
+	wish, err := app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, "Good luck with the tomatoes!", wish.Content)
+
+	tg.press(bob, saved, "edit_wish")
+	tg.sendText(bob, "\n Have a great harvest!  ")
+	wish, err = app.db.GetWishByID(uint(wishID))
+	require.NoError(t, err)
+	require.Equal(t, "Have a great harvest!", wish.Content)
+}
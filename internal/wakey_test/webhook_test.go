@@ -0,0 +1,164 @@
+package wakey_test
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestNewPoller(t *testing.T) {
+	poller, err := wakey.NewPoller(wakey.TransportConfig{})
+	require.NoError(t, err)
+	require.Equal(t, &tele.LongPoller{Timeout: 30 * time.Second}, poller)
+
+	_, err = wakey.NewPoller(wakey.TransportConfig{Mode: "carrier_pigeon"})
+	require.Error(t, err)
+
+	cases := []struct {
+		name   string
+		config wakey.WebhookConfig
+	}{
+		{"no listen address", wakey.WebhookConfig{SecretToken: "secret"}},
+		{"webhook without secret", wakey.WebhookConfig{Listen: "127.0.0.1:0", PublicURL: "https://example.com/wakey"}},
+		{"invalid secret token", wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "not a token!"}},
+		{"plain http url", wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "secret", PublicURL: "http://example.com/wakey"}},
+		{"cert without key", wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "secret", CertFile: "cert.pem"}},
+		{"self-signed without cert", wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "secret", SelfSigned: true}},
+		{"missing cert", wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "secret", CertFile: "missing.pem", KeyFile: "missing.key"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := wakey.NewPoller(wakey.TransportConfig{Mode: wakey.TransportWebhook, Webhook: tc.config})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestWebhookPoller(t *testing.T) {
+	wp, err := wakey.NewWebhookPoller(wakey.WebhookConfig{Listen: "127.0.0.1:0", SecretToken: "secret"}, time.Second)
+	require.NoError(t, err)
+
+	api, err := tele.NewBot(tele.Settings{Offline: true, Poller: wp})
+	require.NoError(t, err)
+
+	// Without a public url the webhook isn't registered with Telegram
+	require.NoError(t, wakey.SetupTransport(api, wp))
+
+	received := make(chan string, 1)
+	api.Handle(tele.OnText, func(c tele.Context) error {
+		received <- c.Text()
+		return nil
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		api.Start()
+		close(stopped)
+	}()
+
+	url := "http://" + wp.Addr().String()
+	post := func(secret, body string) int {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	update := `{"update_id": 1, "message": {"message_id": 1, "from": {"id": 42}, "chat": {"id": 42, "type": "private"}, "text": "good morning"}}`
+
+	require.Equal(t, http.StatusOK, post("secret", update))
+	select {
+	case text := <-received:
+		require.Equal(t, "good morning", text)
+	case <-time.After(time.Second):
+		t.Fatal("the update wasn't handled")
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post("", update))
+	require.Equal(t, http.StatusUnauthorized, post("wrong", update))
+	require.Equal(t, http.StatusBadRequest, post("secret", "{not json"))
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// Stopping the bot closes the listener
+	api.Stop()
+	<-stopped
+	_, err = http.Post(url, "application/json", strings.NewReader(update))
+	require.Error(t, err)
+	require.Empty(t, received)
+}
+
+// chanPoller delivers the updates sent to the channel
+type chanPoller chan tele.Update
+
+func (p chanPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	for {
+		select {
+		case update := <-p:
+			dest <- update
+		case <-stop:
+			return
+		}
+	}
+}
+
+// slowStats handles /stat slowly
+type slowStats struct {
+	handled atomic.Bool
+}
+
+func (h *slowStats) Actions() []string { return nil }
+
+func (h *slowStats) HandleAction(c tele.Context, action string) error { return nil }
+
+func (h *slowStats) States() []wakey.UserState { return []wakey.UserState{wakey.StatePrintStats} }
+
+func (h *slowStats) HandleState(c tele.Context, state wakey.UserState) error {
+	time.Sleep(200 * time.Millisecond)
+	h.handled.Store(true)
+	return nil
+}
+
+func TestBotStopWaitsForUpdates(t *testing.T) {
+	tg := newFakeTelegram(t)
+	db := setupTestDB(t)
+	bot := wakey.NewBot(db, wakey.NewStateManager(), wakey.NewRateLimiter(wakey.RateLimitConfig{}), newTestCallbacks(t))
+
+	updates := make(chanPoller)
+	api, err := tele.NewBot(tele.Settings{
+		URL:         tg.server.URL,
+		Token:       fakeBotToken,
+		Poller:      bot.TrackUpdates(updates),
+		Synchronous: true,
+	})
+	require.NoError(t, err)
+
+	handler := &slowStats{}
+	bot.Start(wakey.Config{}, api, []wakey.BotHandler{handler})
+
+	sender := &tele.User{ID: 7001, FirstName: "Slow"}
+	updates <- tele.Update{ID: 1, Message: &tele.Message{
+		ID:       1,
+		Sender:   sender,
+		Chat:     &tele.Chat{ID: sender.ID, Type: tele.ChatPrivate},
+		Text:     "/stat",
+		Entities: tele.Entities{{Type: tele.EntityCommand, Length: 5}},
+	}}
+
+	bot.Stop()
+	require.True(t, handler.handled.Load(), "Stop returned before the update was handled")
+}
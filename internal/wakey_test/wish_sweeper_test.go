@@ -0,0 +1,175 @@
+package wakey_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"wakey/internal/wakey"
+
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+type sentMessage struct {
+	to   string
+	what interface{}
+}
+
+type fakeBotAPI struct {
+	mu   sync.Mutex
+	sent []sentMessage
+	errs map[string]error
+}
+
+func (api *fakeBotAPI) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err, ok := api.errs[to.Recipient()]; ok {
+		return nil, err
+	}
+
+	api.sent = append(api.sent, sentMessage{to: to.Recipient(), what: what})
+	return &tele.Message{}, nil
+}
+
+func (api *fakeBotAPI) Handle(endpoint interface{}, h tele.HandlerFunc, m ...tele.MiddlewareFunc) {}
+func (api *fakeBotAPI) Use(middlewares ...tele.MiddlewareFunc)                                    {}
+func (api *fakeBotAPI) SetCommands(opts ...interface{}) error                                     { return nil }
+func (api *fakeBotAPI) Start()                                                                    {}
+func (api *fakeBotAPI) Stop()                                                                     {}
+
+func (api *fakeBotAPI) messagesTo(userID int64) []sentMessage {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var msgs []sentMessage
+	for _, msg := range api.sent {
+		if msg.to == tele.ChatID(userID).Recipient() {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestWishSweeper(t *testing.T) {
+	db := setupTestDB(t)
+	api := &fakeBotAPI{}
+
+	users := []*wakey.User{
+		{ID: 200, Name: "Redirecting Sender", AllowRedirect: true},
+		{ID: 201, Name: "Plain Sender"},
+		{ID: 202, Name: "Missing Recipient"},
+		{ID: 203, Name: "Other Recipient"},
+	}
+	for _, user := range users {
+		require.NoError(t, db.CreateUser(user))
+	}
+
+	now := time.Now()
+	pastPlan := &wakey.Plan{
+		UserID:  202,
+		Content: "Past Plan",
+		WakeAt:  now.Add(-12 * time.Hour),
+	}
+	recentPlan := &wakey.Plan{
+		UserID:  202,
+		Content: "Recent Plan",
+		WakeAt:  now.Add(-time.Hour),
+	}
+	futurePlan := &wakey.Plan{
+		UserID:  203,
+		Content: "Future Plan",
+		WakeAt:  now.Add(12 * time.Hour),
+	}
+	for _, plan := range []*wakey.Plan{pastPlan, recentPlan, futurePlan} {
+		require.NoError(t, db.SavePlan(plan))
+	}
+
+	redirected := &wakey.Wish{FromID: 200, PlanID: pastPlan.ID, Content: "Redirected Wish"}
+	undelivered := &wakey.Wish{FromID: 201, PlanID: pastPlan.ID, Content: "Undelivered Wish"}
+	recent := &wakey.Wish{FromID: 201, PlanID: recentPlan.ID, Content: "Recent Wish"}
+	held := &wakey.Wish{FromID: 200, PlanID: pastPlan.ID, Content: "Held Wish"}
+	for _, wish := range []*wakey.Wish{redirected, undelivered, recent, held} {
+		require.NoError(t, db.SaveWish(wish))
+	}
+	require.NoError(t, db.UpdateWishState(held.ID, wakey.WishStateHeld, wakey.BotActorID))
+
+	// Only wishes past the grace period are returned
+	stuck, err := db.GetUndeliveredWishes(now.Add(-6 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stuck, 2)
+
+	sweeper := wakey.NewWishSweeper(db, api, wakey.DeliveryConfig{GracePeriod: 6})
+	sweeper.Sweep()
+
+	// The sender who allowed redirects gets their wish moved to another plan
+	fetched, err := db.GetWishByID(redirected.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateNew, fetched.State)
+	require.Equal(t, futurePlan.ID, fetched.PlanID)
+	require.Len(t, api.messagesTo(200), 1)
+
+	// The redirect doesn't hold the plan back from the other senders
+	target, err := db.GetPlanByID(futurePlan.ID)
+	require.NoError(t, err)
+	require.True(t, target.OfferedAt.IsZero())
+
+	// The other sender is told that the wish could not be delivered
+	fetched, err = db.GetWishByID(undelivered.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateUndelivered, fetched.State)
+	require.Len(t, api.messagesTo(201), 1)
+
+	// The wish still within the grace period is left untouched
+	fetched, err = db.GetWishByID(recent.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateNew, fetched.State)
+	require.Equal(t, recentPlan.ID, fetched.PlanID)
+
+	// The wish waiting for the admin review is left to the admin
+	fetched, err = db.GetWishByID(held.ID)
+	require.NoError(t, err)
+	require.Equal(t, wakey.WishStateHeld, fetched.State)
+	require.Equal(t, pastPlan.ID, fetched.PlanID)
+
+	// Nothing is left to sweep
+	stuck, err = db.GetUndeliveredWishes(now.Add(-6 * time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, stuck)
+
+	// Reassigning a non-existent wish fails
+	err = db.ReassignWish(999, futurePlan.ID)
+	require.Equal(t, wakey.ErrNotFound, err)
+
+	// A wish that left the delivery queue stays with its plan
+	require.NoError(t, db.UpdateWishState(recent.ID, wakey.WishStateRetracted, 201))
+	err = db.ReassignWish(recent.ID, futurePlan.ID)
+	require.Equal(t, wakey.ErrWishNotPending, err)
+
+	fetched, err = db.GetWishByID(recent.ID)
+	require.NoError(t, err)
+	require.Equal(t, recentPlan.ID, fetched.PlanID)
+}
+
+func TestWishSweeperMediaNotice(t *testing.T) {
+	db := setupTestDB(t)
+	api := &fakeBotAPI{}
+
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 210, Name: "Sticker Sender", Lang: "en"}))
+	require.NoError(t, db.CreateUser(&wakey.User{ID: 211, Name: "Missing Recipient"}))
+
+	plan := &wakey.Plan{UserID: 211, Content: "Past Plan", WakeAt: time.Now().Add(-12 * time.Hour)}
+	require.NoError(t, db.SavePlan(plan))
+
+	wish := &wakey.Wish{FromID: 210, PlanID: plan.ID, MediaType: wakey.MediaSticker, FileID: "sticker-file"}
+	require.NoError(t, db.SaveWish(wish))
+
+	wakey.NewWishSweeper(db, api, wakey.DeliveryConfig{GracePeriod: 6}).Sweep()
+
+	// The notice names the media the sender can't see quoted
+	msgs := api.messagesTo(210)
+	require.Len(t, msgs, 1)
+	require.Contains(t, msgs[0].what, "🖼 Sticker")
+}
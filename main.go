@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 	"wakey/internal/wakey"
 
@@ -37,11 +38,27 @@ func main() {
 	}
 	defer db.Stop()
 
+	matcher, err := wakey.NewMatchStrategy(cfg.Matching)
+	if err != nil {
+		logger.Panicf("Failed to initialize match strategy: %v", err)
+	}
+	db.SetMatching(matcher, cfg.Matching)
+
 	moderator, err := wakey.NewMessageModerator(cfg.Moderation)
 	if err != nil {
 		logger.Panicf("Failed to initialize message moderator: %v", err)
 	}
 
+	translator, err := wakey.NewTranslator(cfg.Translation, cfg.Moderation.LLM)
+	if err != nil {
+		logger.Panicf("Failed to initialize translator: %v", err)
+	}
+
+	validator, err := wakey.NewValidator(cfg.Validation, db)
+	if err != nil {
+		logger.Panicf("Failed to initialize input validator: %v", err)
+	}
+
 	toxicityChecker := wakey.NewToxicityChecker(db, moderator)
 	toxicityChecker.Start()
 	defer toxicityChecker.Stop()
@@ -54,6 +71,14 @@ func main() {
 	planSched.Start()
 	defer planSched.Stop()
 
+	digestSched := wakey.NewSched(cfg.MaxJobs)
+	digestSched.Start()
+	defer digestSched.Stop()
+
+	reportSched := wakey.NewSched(cfg.MaxJobs)
+	reportSched.Start()
+	defer reportSched.Stop()
+
 	stateMan := wakey.NewStateManager()
 	stateStorage := wakey.NewStateStorage(db)
 	stateStorage.LoadToManager(stateMan)
@@ -64,12 +89,25 @@ func main() {
 	stateMan.Start(cleanupInterval, maxStateAge)
 	defer stateMan.Stop()
 
-	bot := wakey.NewBot(db, stateMan)
+	callbacks, err := wakey.NewCallbackCodec(cfg.Callbacks, cfg.TgToken)
+	if err != nil {
+		logger.Panicf("Failed to initialize callback signing: %v", err)
+	}
+
+	limiter := wakey.NewRateLimiter(cfg.RateLimit)
+	bot := wakey.NewBot(db, stateMan, limiter, callbacks)
+
+	poller, err := wakey.NewPoller(cfg.Transport)
+	if err != nil {
+		logger.Panicf("Failed to initialize %s transport: %v", cfg.Transport.Mode, err)
+	}
 
+	// The updates are handled concurrently by the tracking poller
 	pref := tele.Settings{
-		Token:   cfg.TgToken,
-		Poller:  &tele.LongPoller{Timeout: 30 * time.Second},
-		OnError: bot.LogError,
+		Token:       cfg.TgToken,
+		Poller:      bot.TrackUpdates(poller),
+		Synchronous: true,
+		OnError:     bot.LogError,
 	}
 
 	api, err := tele.NewBot(pref)
@@ -77,17 +115,30 @@ func main() {
 		logger.Panic(err)
 	}
 
-	planHandler := wakey.NewPlanHandler(db, api, planSched, wishSched, stateMan, bot.Logger())
-	wishHandler := wakey.NewWishHandler(db, api, wishSched, stateMan, bot.Logger())
-	profileHandler := wakey.NewProfileHandler(db, stateMan, bot.Logger())
-	adminHandler := wakey.NewAdminHandler(db, api, stateMan, bot.Logger(), cfg.AdminID, cfg.MaxToxic)
-	generalHandler := wakey.NewGeneralHandler(db, stateMan, bot.Logger(), api.Me.Username)
-	handlers := []wakey.BotHandler{planHandler, wishHandler, profileHandler, adminHandler, generalHandler}
+	if err := wakey.SetupTransport(api, poller); err != nil {
+		logger.Panicf("Failed to set up %s transport: %v", cfg.Transport.Mode, err)
+	}
+
+	flowRunner := wakey.NewFlowRunner(stateMan, callbacks, bot.Logger())
+	planHandler := wakey.NewPlanHandler(db, api, planSched, wishSched, flowRunner, validator, stateMan, callbacks, bot.Logger())
+	wishHandler := wakey.NewWishHandler(db, api, wishSched, translator, flowRunner, validator, stateMan, callbacks, bot.Logger())
+	profileHandler := wakey.NewProfileHandler(db, flowRunner, validator, stateMan, callbacks, bot.Logger())
+	adminHandler := wakey.NewAdminHandler(db, api, flowRunner, limiter, callbacks, bot.Logger(), cfg.AdminID, cfg.MaxToxic)
+	digestHandler := wakey.NewDigestHandler(db, api, digestSched, stateMan, callbacks, bot.Logger())
+	journalHandler := wakey.NewJournalHandler(db, flowRunner, stateMan, callbacks, bot.Logger())
+	moodHandler := wakey.NewMoodHandler(db, stateMan, callbacks, bot.Logger())
+	generalHandler := wakey.NewGeneralHandler(db, flowRunner, stateMan, callbacks, bot.Logger(), api.Me.Username)
+	reportHandler := wakey.NewReportHandler(db, api, reportSched, cfg.Reports, bot.Logger(), cfg.AdminID)
+	handlers := []wakey.BotHandler{planHandler, wishHandler, profileHandler, adminHandler, digestHandler, journalHandler, moodHandler, generalHandler, reportHandler, flowRunner}
 
 	bot.Start(cfg, api, handlers)
 	defer bot.Stop()
 
+	wishSweeper := wakey.NewWishSweeper(db, api, cfg.Delivery)
+	wishSweeper.Start()
+	defer wishSweeper.Stop()
+
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 }